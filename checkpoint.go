@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"time"
+
+	"gray/glm"
+	"gray/scene"
+)
+
+// A checkpoint is the state needed to carry on a render: the accumulated
+// framebuffer and its per-pixel sample counts. Together with the seed the
+// counts determine which pass, and so which sample stream, comes next.
+// Fingerprint identifies the scene and trace options the samples are of.
+type checkpoint struct {
+  Width, Height int
+  Seed int64
+  Fingerprint []byte
+  Acc []glm.Vec3
+  Samples []int
+}
+
+// renderFingerprint hashes what decides the samples a render takes: the
+// scene, as sent to workers, and the trace options.
+func renderFingerprint(sc *scene.Scene, opts TraceOptions) ([]byte, error) {
+  h := sha256.New()
+  if err := gob.NewEncoder(h).Encode(&LoadArgs{sc, opts}); err != nil {
+    return nil, err
+  }
+  return h.Sum(nil), nil
+}
+
+// saveCheckpoint writes fb to file, replacing it only once the new state is
+// completely written.
+func saveCheckpoint(file string, fb *framebuffer, seed int64, fingerprint []byte) error {
+  fb.RLock()
+  cp := checkpoint{fb.Width, fb.Height, seed, fingerprint, append([]glm.Vec3{}, fb.Acc...), append([]int{}, fb.Samples...)}
+  fb.RUnlock()
+
+  tmp := file + ".tmp"
+  w, err := os.Create(tmp)
+  if err != nil {
+    return err
+  }
+  if err := gob.NewEncoder(w).Encode(&cp); err != nil {
+    w.Close()
+    return err
+  }
+  if err := w.Close(); err != nil {
+    return err
+  }
+  return os.Rename(tmp, file)
+}
+
+// loadCheckpoint restores fb from file and returns the seed it was rendered
+// with. The checkpoint must be of the render with the given fingerprint.
+func loadCheckpoint(file string, fb *framebuffer, fingerprint []byte) (int64, error) {
+  r, err := os.Open(file)
+  if err != nil {
+    return 0, err
+  }
+  defer r.Close()
+  cp := checkpoint{}
+  if err := gob.NewDecoder(r).Decode(&cp); err != nil {
+    return 0, fmt.Errorf("%s: %v", file, err)
+  }
+  if cp.Width != fb.Width || cp.Height != fb.Height {
+    return 0, fmt.Errorf("%s: checkpoint is %dx%d, scene is %dx%d", file, cp.Width, cp.Height, fb.Width, fb.Height)
+  }
+  if string(cp.Fingerprint) != string(fingerprint) {
+    return 0, fmt.Errorf("%s: checkpoint is of a different scene or trace options", file)
+  }
+  if len(cp.Acc) != cp.Width*cp.Height || len(cp.Samples) != cp.Width*cp.Height {
+    return 0, fmt.Errorf("%s: truncated checkpoint", file)
+  }
+  fb.Lock()
+  defer fb.Unlock()
+  fb.Acc = cp.Acc
+  fb.Samples = cp.Samples
+  return cp.Seed, nil
+}
+
+// checkpointEvery saves fb to file every interval and a last time once stop
+// is closed, then closes done.
+func checkpointEvery(file string, interval time.Duration, fb *framebuffer, seed int64, fingerprint []byte, stop, done chan struct{}) {
+  defer close(done)
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ticker.C:
+    case <-stop:
+      if err := saveCheckpoint(file, fb, seed, fingerprint); err != nil {
+        fmt.Println("Checkpoint failed:", err)
+      }
+      return
+    }
+    if err := saveCheckpoint(file, fb, seed, fingerprint); err != nil {
+      fmt.Println("Checkpoint failed:", err)
+    }
+  }
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gray/glm"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+  sc := testScene()
+  opts := TraceOptions{MaxDepth: 4}
+  fingerprint, err := renderFingerprint(sc, opts)
+  if err != nil {
+    t.Fatal(err)
+  }
+  fb := newFramebuffer(3, 2)
+  for i := range fb.Acc {
+    fb.Acc[i] = *glm.NewVec3(float64(i), 0.5, 1)
+    fb.Samples[i] = i + 1
+  }
+  file := filepath.Join(t.TempDir(), "render.checkpoint")
+  if err := saveCheckpoint(file, fb, 42, fingerprint); err != nil {
+    t.Fatal(err)
+  }
+
+  // the same scene, built again, has the same fingerprint.
+  again, err := renderFingerprint(testScene(), opts)
+  if err != nil {
+    t.Fatal(err)
+  }
+  restored := newFramebuffer(3, 2)
+  seed, err := loadCheckpoint(file, restored, again)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if seed != 42 {
+    t.Errorf("seed %d, want 42", seed)
+  }
+  for i := range fb.Acc {
+    if restored.Acc[i] != fb.Acc[i] || restored.Samples[i] != fb.Samples[i] {
+      t.Errorf("pixel %d is %v with %d samples, want %v with %d", i, restored.Acc[i], restored.Samples[i], fb.Acc[i], fb.Samples[i])
+    }
+  }
+
+  moved := testScene()
+  moved.Eye.Elem[0] += 1
+  deeper := opts
+  deeper.MaxDepth++
+  for _, c := range []struct {
+    name string
+    width, height int
+    fingerprint func() ([]byte, error)
+  }{
+    {"other size", 2, 3, func() ([]byte, error) { return fingerprint, nil }},
+    {"other scene", 3, 2, func() ([]byte, error) { return renderFingerprint(moved, opts) }},
+    {"other options", 3, 2, func() ([]byte, error) { return renderFingerprint(sc, deeper) }},
+    {"no fingerprint", 3, 2, func() ([]byte, error) { return nil, nil }},
+  } {
+    f, err := c.fingerprint()
+    if err != nil {
+      t.Fatal(err)
+    }
+    if _, err := loadCheckpoint(file, newFramebuffer(c.width, c.height), f); err == nil {
+      t.Errorf("%s: resumed without error", c.name)
+    }
+  }
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+)
+
+// control lets a render be paused, resumed or cancelled from another
+// goroutine (the preview server, a signal handler, ...).
+type control struct {
+  mu sync.Mutex
+  cond *sync.Cond
+  paused, cancelled bool
+}
+
+func newControl() *control {
+  c := &control{}
+  c.cond = sync.NewCond(&c.mu)
+  return c
+}
+
+func (c *control) Pause() {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.paused = true
+}
+
+func (c *control) Resume() {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.paused = false
+  c.cond.Broadcast()
+}
+
+func (c *control) Cancel() {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.cancelled = true
+  c.cond.Broadcast()
+}
+
+func (c *control) Paused() bool {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.paused
+}
+
+func (c *control) Cancelled() bool {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.cancelled
+}
+
+// wait blocks while the render is paused and reports whether it should
+// carry on.
+func (c *control) wait() bool {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  for c.paused && !c.cancelled {
+    c.cond.Wait()
+  }
+  return !c.cancelled
+}
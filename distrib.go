@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/rpc"
+	"runtime"
+	"sync"
+	"time"
+
+	"gray/glm"
+	"gray/scene"
+)
+
+// Worker is the net/rpc service run by `gray worker`. A coordinator loads its
+// scene once per connection and then asks for tiles.
+type Worker struct {
+  mu sync.RWMutex
+  sc *scene.Scene
+  cam *camera
+  tr tracer
+}
+
+type LoadArgs struct {
+  Scene *scene.Scene
+  Trace TraceOptions
+}
+
+// Load replaces the worker's scene and replies with the number of tiles it
+// can trace concurrently.
+func (w *Worker) Load(args *LoadArgs, reply *int) error {
+  if args.Scene == nil {
+    return errors.New("no scene")
+  }
+  if err := args.Scene.Validate(); err != nil {
+    return err
+  }
+  w.mu.Lock()
+  defer w.mu.Unlock()
+  w.sc = args.Scene
+  w.cam = newCamera(w.sc)
+  w.tr = newTracer(w.sc, args.Trace)
+  *reply = runtime.NumCPU()
+  return nil
+}
+
+func (w *Worker) RenderTile(t *Tile, reply *[]glm.Vec3) error {
+  w.mu.RLock()
+  defer w.mu.RUnlock()
+  if w.sc == nil {
+    return errors.New("no scene loaded")
+  }
+  *reply = renderTile(w.tr, w.cam, *t)
+  return nil
+}
+
+// serveWorker accepts coordinators on ln, giving each connection its own
+// Worker.
+func serveWorker(ln net.Listener) error {
+  for {
+    conn, err := ln.Accept()
+    if err != nil {
+      return err
+    }
+    server := rpc.NewServer()
+    if err := server.Register(&Worker{}); err != nil {
+      return err
+    }
+    go server.ServeConn(conn)
+  }
+}
+
+func workerMain(args []string) {
+  flags := flag.NewFlagSet("worker", flag.ExitOnError)
+  listen := flags.String("listen", "localhost:7070", "address to accept coordinators on")
+  flags.Parse(args)
+  ln, err := net.Listen("tcp", *listen)
+  if err != nil {
+    fmt.Println(err)
+    return
+  }
+  fmt.Println("Worker listening on", ln.Addr())
+  fmt.Println(serveWorker(ln))
+}
+
+// remoteRenderer traces tiles on a worker. Several of them share one
+// connection so that all of the worker's CPUs are kept busy. A tile taking
+// longer than timeout, if set, gives the worker up for hung: the connection
+// is closed, failing the other tiles on it too, so that all of them are
+// traced elsewhere.
+type remoteRenderer struct {
+  addr string
+  client *rpc.Client
+  timeout time.Duration
+}
+
+func (r remoteRenderer) RenderTile(t Tile) ([]glm.Vec3, error) {
+  var colours []glm.Vec3
+  call := r.client.Go("Worker.RenderTile", &t, &colours, make(chan *rpc.Call, 1))
+  var expired <-chan time.Time
+  if r.timeout > 0 {
+    timer := time.NewTimer(r.timeout)
+    defer timer.Stop()
+    expired = timer.C
+  }
+  select {
+  case <-call.Done:
+  case <-expired:
+    r.client.Close()
+    return nil, fmt.Errorf("worker %s: no reply after %v", r.addr, r.timeout)
+  }
+  if call.Error != nil {
+    return nil, fmt.Errorf("worker %s: %v", r.addr, call.Error)
+  }
+  if len(colours) != (t.X1-t.X0)*(t.Y1-t.Y0) {
+    return nil, fmt.Errorf("worker %s: returned %d pixels for a %dx%d tile", r.addr, len(colours), t.X1-t.X0, t.Y1-t.Y0)
+  }
+  return colours, nil
+}
+
+// dialWorker connects to the worker at addr, sends it sc and opts and
+// returns one renderer per CPU the worker has, each waiting at most timeout
+// for a tile.
+func dialWorker(addr string, sc *scene.Scene, opts TraceOptions, timeout time.Duration) ([]tileRenderer, error) {
+  client, err := rpc.Dial("tcp", addr)
+  if err != nil {
+    return nil, err
+  }
+  var cpus int
+  if err := client.Call("Worker.Load", &LoadArgs{sc, opts}, &cpus); err != nil {
+    client.Close()
+    return nil, err
+  }
+  fmt.Println("Worker", addr, "ready with", cpus, "CPUs")
+  renderers := make([]tileRenderer, cpus)
+  for i := range renderers {
+    renderers[i] = remoteRenderer{addr, client, timeout}
+  }
+  return renderers, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gray/glm"
+	"gray/scene"
+)
+
+// A faultyConn fails after replies writes: it closes, like a worker that
+// dies, or with hang set stops answering without closing.
+type faultyConn struct {
+  net.Conn
+  mu sync.Mutex
+  replies int
+  hang bool
+  failed bool
+  closed chan struct{}
+  once sync.Once
+}
+
+func (c *faultyConn) Write(b []byte) (int, error) {
+  c.mu.Lock()
+  if c.replies > 0 {
+    c.replies--
+    c.mu.Unlock()
+    return c.Conn.Write(b)
+  }
+  c.failed = true
+  c.mu.Unlock()
+  if c.hang {
+    <-c.closed
+  }
+  c.Close()
+  return 0, net.ErrClosed
+}
+
+func (c *faultyConn) Close() error {
+  c.once.Do(func() { close(c.closed) })
+  return c.Conn.Close()
+}
+
+func (c *faultyConn) Failed() bool {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.failed
+}
+
+// faultyListener hands out faultyConns.
+type faultyListener struct {
+  net.Listener
+  replies int
+  hang bool
+  conns chan *faultyConn
+}
+
+func (l faultyListener) Accept() (net.Conn, error) {
+  conn, err := l.Listener.Accept()
+  if err != nil {
+    return nil, err
+  }
+  c := &faultyConn{Conn: conn, replies: l.replies, hang: l.hang, closed: make(chan struct{})}
+  l.conns <- c
+  return c, nil
+}
+
+func testScene() *scene.Scene {
+  mat := scene.Material{Ambient: *glm.NewVec3(0.7, 0.7, 0.7), Diffuse: *glm.NewVec3(0.7, 0.7, 0.7), Specular: *glm.NewVec3(0.5, 0.5, 0.5), Shininess: 25}
+  return &scene.Scene{
+    Lights: []scene.Light{
+      scene.Light{Pos: *glm.NewVec3(-100.0, 150.0, 400.0), Colour: *glm.NewVec3(0.7, 0.7, 0.7), Falloff: *glm.NewVec3(1.0, 0.0, 0.0)},
+    },
+    Primitives: []scene.Primitive{
+      scene.Sphere{Pos: *glm.NewVec3(0.0, 0.0, -400.0), Rad: 100.0, Mat: mat},
+    },
+    Eye: *glm.NewVec3(0.0, 0.0, 800.0),
+    View: *glm.NewVec3(0.0, 0.0, -1.0),
+    Up: *glm.NewVec3(0.0, 1.0, 0.0),
+    Ambient: *glm.NewVec3(0.3, 0.3, 0.3),
+    FOV: 50,
+    Width: 96,
+    Height: 96,
+  }
+}
+
+// renderWithFaultyWorker renders over a healthy worker and one that fails
+// after its scene and first tile, and checks that every pixel of every pass
+// is still traced.
+func renderWithFaultyWorker(t *testing.T, hang bool, timeout time.Duration) {
+  good, err := net.Listen("tcp", "localhost:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer good.Close()
+  ln, err := net.Listen("tcp", "localhost:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  // the scene's reply takes two writes, with its gob types, and each tile
+  // one.
+  bad := faultyListener{ln, 3, hang, make(chan *faultyConn, 1)}
+  defer bad.Close()
+  go serveWorker(good)
+  go serveWorker(bad)
+
+  sc := testScene()
+  opts := &Options{Passes: 3, Workers: strings.Join([]string{good.Addr().String(), bad.Addr().String()}, ","), WorkerTimeout: timeout, Seed: 1}
+  fb := newFramebuffer(sc.Width, sc.Height)
+  done := make(chan error, 1)
+  go func() { done <- Render(sc, opts, fb, newControl(), func(int) {}) }()
+  select {
+  case err := <-done:
+    if err != nil {
+      t.Fatal(err)
+    }
+  case <-time.After(time.Minute):
+    t.Fatal("render did not finish")
+  }
+  if c := <-bad.conns; !c.Failed() {
+    t.Fatal("the faulty worker was never asked for a second tile")
+  }
+  for i, n := range fb.Samples {
+    if n != opts.Passes*MSAA*MSAA {
+      t.Fatalf("pixel %d has %d samples, want %d", i, n, opts.Passes*MSAA*MSAA)
+    }
+  }
+}
+
+func TestRenderSurvivesDeadWorker(t *testing.T) {
+  renderWithFaultyWorker(t, false, 0)
+}
+
+func TestRenderSurvivesHungWorker(t *testing.T) {
+  renderWithFaultyWorker(t, true, time.Second)
+}
+
+func TestWorkerRejectsBadScene(t *testing.T) {
+  sc := testScene()
+  sc.Primitives = append(sc.Primitives, scene.Quartic{Terms: []scene.QuarticTerm{{Coef: 1, I: 5}}})
+  var n int
+  if err := (&Worker{}).Load(&LoadArgs{Scene: sc}, &n); err == nil {
+    t.Fatal("loaded a quintic as a quartic")
+  }
+}
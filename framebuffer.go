@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sync"
+
+	"gray/glm"
+)
+
+// A framebuffer accumulates linear colour samples per pixel. Rows are stored
+// bottom-up, the way the camera walks them.
+type framebuffer struct {
+  sync.RWMutex
+  Width, Height int
+  Acc []glm.Vec3
+  Samples []int
+}
+
+func newFramebuffer(width, height int) *framebuffer {
+  return &framebuffer{
+    Width: width,
+    Height: height,
+    Acc: make([]glm.Vec3, width*height),
+    Samples: make([]int, width*height),
+  }
+}
+
+// addTile adds the summed colours of a rendered tile, each made of samples
+// samples, to the buffer.
+func (fb *framebuffer) addTile(t Tile, colours []glm.Vec3, samples int) {
+  fb.Lock()
+  defer fb.Unlock()
+  width := t.X1 - t.X0
+  for y := t.Y0; y < t.Y1; y++ {
+    for x := t.X0; x < t.X1; x++ {
+      fb.Acc[y*fb.Width + x].Iadd(&colours[(y-t.Y0)*width + x-t.X0])
+      fb.Samples[y*fb.Width + x] += samples
+    }
+  }
+}
+
+// Image resolves the current samples into an 8 bit sRGB image.
+func (fb *framebuffer) Image(tm *ToneMapping) *image.RGBA {
+  fb.RLock()
+  defer fb.RUnlock()
+  img_rect := image.NewRGBA(image.Rect(0, 0, fb.Width, fb.Height))
+  for x := 0; x < fb.Width; x++ {
+    for y := 0; y < fb.Height; y++ {
+      n := fb.Samples[y*fb.Width + x]
+      if n == 0 {
+        img_rect.Set(x, fb.Height - y - 1, color.RGBA{0, 0, 0, 255})
+        continue
+      }
+      a := tm.Map(*fb.Acc[y*fb.Width + x].Scale(1/float64(n)))
+      // pack into a color.RGBA struct
+      pixel := color.RGBA{toByte(a.Elem[0]), toByte(a.Elem[1]), toByte(a.Elem[2]), 255}
+      img_rect.Set(x, fb.Height - y - 1, pixel)
+    }
+  }
+  return img_rect
+}
+
+// toByte rounds a display value in [0,1] to the nearest 8 bit level.
+func toByte(x float64) uint8 {
+  return uint8(255*math.Max(0, math.Min(1, x)) + 0.5)
+}
+
+func (fb *framebuffer) WritePNG(file string, tm *ToneMapping) error {
+  w, err := os.Create(file)
+  if err != nil {
+    return err
+  }
+  defer w.Close()
+  return png.Encode(w, fb.Image(tm))
+}
+
+// traced reports whether t's pass has already been added to the buffer.
+// Tiles are added whole, so looking at one pixel is enough.
+func (fb *framebuffer) traced(t Tile) bool {
+  fb.RLock()
+  defer fb.RUnlock()
+  return fb.Samples[t.Y0*fb.Width + t.X0] >= (t.Pass+1)*MSAA*MSAA
+}
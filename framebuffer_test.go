@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"gray/glm"
+)
+
+func TestImageRounds(t *testing.T) {
+  for _, c := range []struct {
+    in float64
+    want uint8
+  }{{0, 0}, {1, 255}, {0.5, 128}, {0.499/255, 0}, {0.501/255, 1}, {254.6/255, 255}, {-1, 0}, {2, 255}} {
+    if got := toByte(c.in); got != c.want {
+      t.Errorf("%g became %d, want %d", c.in, got, c.want)
+    }
+  }
+  fb := newFramebuffer(1, 1)
+  fb.addTile(Tile{0, 0, 1, 1, 0, 0}, []glm.Vec3{*glm.NewVec3(1, 4, 0)}, 1)
+  img := fb.Image(&ToneMapping{0, *glm.NewVec3(1, 1, 1), "clamp"})
+  if p := img.RGBAAt(0, 0); p.R != 255 || p.G != 255 || p.B != 0 {
+    t.Errorf("white and black came out %v", p)
+  }
+}
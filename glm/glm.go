@@ -70,6 +70,10 @@ func (v *Vec3) Dot(in *Vec3) float64 {
 	return v.Elem[0]*in.Elem[0] + v.Elem[1]*in.Elem[1] + v.Elem[2]*in.Elem[2]
 }
 
+func (v *Vec3) Length() float64 {
+	return math.Sqrt(v.Dot(v))
+}
+
 func (v *Vec3) Cross(in *Vec3) *Vec3 {
 	out := new(Vec3)
 	out.Elem[0] = v.Elem[1]*in.Elem[2] - in.Elem[1]*v.Elem[2]
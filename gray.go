@@ -1,14 +1,16 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"image"
-	"image/color"
-	"image/png"
 	"math"
-	"math/rand"
 	"os"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"gray/glm"
@@ -19,21 +21,99 @@ const (
   MSAA = 1
 	SUBPIXEL_OFFSET = float64(MSAA - 1) / 2.0
   JITTER = false
-  REFLECTIONS = false
-  MAX_DEPTH = 10
+  TILE_SIZE = 32
 )
 
-type response struct {
-  y,x int
-  colour glm.Vec3
+// Options control a render and are set from the command line.
+type Options struct {
+  Output string
+  Passes int
+  Preview string
+  Workers string
+  // WorkerTimeout is how long a worker may take over one tile before its
+  // tiles are traced elsewhere; zero waits forever.
+  WorkerTimeout time.Duration
+  Seed int64
+  Checkpoint string
+  CheckpointEvery time.Duration
+  Resume bool
+  Tone ToneMapping
+  Trace TraceOptions
+}
+
+// TraceOptions control how far rays are followed. Workers are sent them
+// with the scene.
+type TraceOptions struct {
+  // reflections stop after MaxDepth bounces, or once they would add less
+  // than MinContribution to the pixel.
+  MaxDepth int
+  MinContribution float64
+  // GlossSamples is the number of rays a rough mirror reflects at the first
+  // bounce; deeper bounces take one each.
+  GlossSamples int
+  // LightSamples is the number of shadow rays sent to each area light.
+  LightSamples int
+  SphereSampling scene.SphereSampling
+  // VolumeSteps is the number of steps a ray takes through volumes without
+  // a grid, such as fog, over its length in the scene.
+  VolumeSteps int
+  // SubsurfaceWalks is the number of random walks under each translucent
+  // surface seen directly.
+  SubsurfaceWalks int
+}
+
+// A Tile is a rectangle of pixels [X0,X1)x[Y0,Y1) traced during one pass.
+type Tile struct {
+  X0, Y0, X1, Y1 int
+  Pass int
+  Seed int64
+}
+
+type camera struct {
+  eye, top_pixel, hor, up glm.Vec3
+  aspect_ratio float64
 }
 
 func degree_to_rad(in float64) float64 {
 	return math.Pi * in / 180.0
 }
 
-func background() *glm.Vec3 {
-  return glm.NewVec3(0.1, 0.1, 0.1)
+func newCamera(sc *scene.Scene) *camera {
+	var aspect_ratio float64 = float64(sc.Width) / float64(sc.Height)
+	var view_len float64 = (float64(sc.Height) / math.Tan(degree_to_rad(sc.FOV)/2.0)) / 2.0
+
+	hor := sc.View.Cross(&sc.Up)
+	top_pixel := sc.Eye.Copy().Iadd(sc.View.Scale(view_len))
+	hor.Normalize()
+	sc.Up.Normalize()
+	top_pixel.Iadd(hor.Scale(float64(-sc.Width) / 2.0))
+	top_pixel.Iadd(sc.Up.Scale(float64(-sc.Height) / 2.0))
+  return &camera{sc.Eye, *top_pixel, *hor, sc.Up, aspect_ratio}
+}
+
+// ray returns the primary ray through the (fractional) pixel position x,y.
+func (c *camera) ray(x, y float64) *glm.Vec3 {
+  subpixel := c.top_pixel.Add(c.hor.Scale(c.aspect_ratio * x)).Add(c.up.Scale(y))
+  return subpixel.Subtract(&c.eye)
+}
+
+func splitTiles(width, height, pass int, seed int64) []Tile {
+  tiles := []Tile{}
+  for y := 0; y < height; y += TILE_SIZE {
+    for x := 0; x < width; x += TILE_SIZE {
+      tiles = append(tiles, Tile{x, y, min(x+TILE_SIZE, width), min(y+TILE_SIZE, height), pass, seed})
+    }
+  }
+  return tiles
+}
+
+// background is the colour seen along a ray that escapes the scene.
+func (tr *tracer) background(ray glm.Vec3) glm.Vec3 {
+  if tr.env == nil {
+    return *glm.NewVec3(0.1, 0.1, 0.1)
+  }
+  ray.Normalize()
+  return tr.env.Env.Radiance(ray)
 }
 
 //TODO: write fail-early intersectShadowNodes
@@ -51,156 +131,528 @@ func intersectNodes(nodes []scene.Primitive, ray, origin *glm.Vec3) (any bool, m
 }
 
 
-func trace(root []scene.Primitive, ambient glm.Vec3, ray, origin *glm.Vec3, lights []scene.Light, depth int) (*glm.Vec3, bool) {
+// A tracer follows rays through a scene, drawing random numbers from s.
+type tracer struct {
+  sc *scene.Scene
+  opts TraceOptions
+  lights []scene.AreaLight
+  // nil without an environment.
+  env *scene.EnvironmentLight
+  s *sampler
+}
+
+// newTracer returns a tracer for sc, which needs a sampler before use.
+func newTracer(sc *scene.Scene, opts TraceOptions) tracer {
+  tr := tracer{sc: sc, opts: opts, lights: scene.AreaLights(sc.Primitives, opts.SphereSampling)}
+  if sc.Environment != nil {
+    tr.env = scene.NewEnvironmentLight(*sc.Environment)
+  }
+  return tr
+}
+
+// phong adds the diffuse and specular light reflected along ray from light
+// arriving from the unit direction dir. spec_scale scales the highlight.
+func phong(mat *scene.Material, normal, ray, dir, light glm.Vec3, spec_scale float64, diffuse, specular *glm.Vec3) {
+  diffuse_coef := normal.Dot(&dir)
+  if diffuse_coef > 0.00001 {
+    diffuse_tmp := mat.Diffuse.Scale(diffuse_coef)
+    diffuse.Iadd(glm.NewVec3(diffuse_tmp.Elem[0]*light.Elem[0], diffuse_tmp.Elem[1]*light.Elem[1], diffuse_tmp.Elem[2]*light.Elem[2]))
+  }
+  reflected_shadow_ray := dir.Subtract(normal.Scale(2*diffuse_coef))
+  specular_coef := math.Abs(math.Pow(reflected_shadow_ray.Dot(&ray), mat.Shininess)) * spec_scale
+  if specular_coef > 0.00001 {
+    specular_tmp := mat.Specular.Scale(specular_coef)
+    specular.Iadd(glm.NewVec3(specular_tmp.Elem[0]*light.Elem[0], specular_tmp.Elem[1]*light.Elem[1], specular_tmp.Elem[2]*light.Elem[2]))
+  }
+}
+
+// direct returns the diffuse and specular light reflected along the unit ray
+// at surface, the hit on root[node] with the unit shading normal, from the
+// scene's lights.
+func (tr *tracer) direct(surface scene.Hit, node int, mat *scene.Material, normal, ray glm.Vec3) (diffuse, specular glm.Vec3) {
+  root, lights := tr.sc.Primitives, tr.sc.Lights
+  intersection := &surface.Point
+  // cast shadow ray.
+  for _, light := range lights {
+    // lights that span an angle are sampled over the cone they fill.
+    samples := 1
+    if light.Angle > 0 {
+      samples = max(1, tr.opts.LightSamples)
+    }
+    for i := 0; i < samples; i++ {
+      pos := light.Pos
+      if light.Angle > 0 {
+        to := light.Pos.Subtract(intersection)
+        dist := to.Length()
+        dir := scene.SampleCone(*to.Scale(1 / dist), math.Cos(light.Angle/2 * math.Pi/180), tr.s.Float64(), tr.s.Float64())
+        pos = *intersection.Add(dir.Scale(dist))
+      }
+      // start secondary rays just clear of the surface, on their own side.
+      shadow_origin := surface.Offset(*pos.Subtract(intersection))
+      shadow_ray := pos.Subtract(&shadow_origin)
+      // only occluders between the point and the light (raylen < 1) count.
+      if hit, _, occluder_len, _ := intersectNodes(root, shadow_ray, &shadow_origin); !hit || occluder_len >= 1 {
+        dist := shadow_ray.Length()
+        colour := light.Colour.Scale(1 / ((light.Falloff.Elem[0] + light.Falloff.Elem[1]*dist + light.Falloff.Elem[2]*dist*dist) * float64(samples)))
+        shadow_ray.Normalize()
+        *colour = mul(*colour, tr.transmittance(*shadow_ray, shadow_origin, dist))
+        // add diffuse/specular components.
+        phong(mat, normal, ray, *shadow_ray, *colour, 1, &diffuse, &specular)
+      }
+    }
+  }
+  // sample the area lights, other than the one hit.
+  for _, area := range tr.lights {
+    if area.Node == node {
+      continue
+    }
+    radiance := area.Emitter.Radiance()
+    samples := max(1, tr.opts.LightSamples)
+    for i := 0; i < samples; i++ {
+      lit, pdf := area.Emitter.Sample(*intersection, tr.s.Float64(), tr.s.Float64())
+      if pdf == 0 {
+        continue
+      }
+      shadow_origin := surface.Offset(*lit.Point.Subtract(intersection))
+      // aim at the lit side of the light's point, so that it does not shadow
+      // itself.
+      target := lit.Offset(*intersection.Subtract(&lit.Point))
+      shadow_ray := target.Subtract(&shadow_origin)
+      if hit, _, occluder_len, _ := intersectNodes(root, shadow_ray, &shadow_origin); hit && occluder_len < 1 {
+        continue
+      }
+      dist := shadow_ray.Length()
+      shadow_ray.Normalize()
+      // radiance/pdf estimates the light arriving; the Lambertian and
+      // normalised Phong lobes take it over pi.
+      light := mul(*radiance.Scale(1 / (math.Pi*pdf*float64(samples))), tr.transmittance(*shadow_ray, shadow_origin, dist))
+      phong(mat, normal, ray, *shadow_ray, light, (mat.Shininess + 2)/2, &diffuse, &specular)
+    }
+  }
+  // sample the environment, which lights along rays that escape. It lights
+  // diffusely only; its highlights are its reflections.
+  if tr.env != nil {
+    samples := max(1, tr.opts.LightSamples)
+    for i := 0; i < samples; i++ {
+      dir, radiance, pdf := tr.env.Sample(tr.s.Float64(), tr.s.Float64())
+      if pdf == 0 || normal.Dot(&dir) <= 0 {
+        continue
+      }
+      shadow_origin := surface.Offset(dir)
+      if hit, _, _, _ := intersectNodes(root, &dir, &shadow_origin); hit {
+        continue
+      }
+      light := mul(*radiance.Scale(1 / (math.Pi*pdf*float64(samples))), tr.transmittance(dir, shadow_origin, math.Inf(1)))
+      phong(mat, normal, ray, dir, light, 0, &diffuse, &specular)
+    }
+  }
+  return
+}
+
+// trace returns the colour seen along ray, or miss beyond the scene, through
+// any volumes in between. weight is the share of the pixel the colour makes
+// up.
+func (tr *tracer) trace(ray, origin *glm.Vec3, depth int, weight float64, miss glm.Vec3) glm.Vec3 {
+  root, ambient := tr.sc.Primitives, tr.sc.Ambient
 	if hit, node, raylen, normal := intersectNodes(root, ray, origin); hit {
+	  // the distance to the hit, before the ray is normalized.
+	  dist := raylen * ray.Length()
 	  // ambient silhouette
-	  mat := root[node].GetMaterial()
+	  surface := scene.HitAt(root[node], *ray, *origin, raylen, normal)
+	  intersection := &surface.Point
+	  mat := scene.MaterialAt(root[node], *intersection)
 	  colour := glm.NewVec3(ambient.Elem[0]*mat.Ambient.Elem[0], ambient.Elem[1]*mat.Ambient.Elem[1], ambient.Elem[2]*mat.Ambient.Elem[2])
-	  // setup for casting secondary (shadow) rays.
-	  intersection := origin.Add(ray.Scale(raylen))
-	  diffuse := glm.Vec3{}
-	  specular := glm.Vec3{}
     ray.Normalize()
     normal.Normalize()
 
-	  // cast shadow ray.
-	  for _, light := range lights {
-      shadow_ray := light.Pos.Subtract(intersection)
-      if hit, _, _, _ = intersectNodes(root, shadow_ray, intersection); !hit {
-        shadow_ray.Normalize()
-        // add diffuse/specular components.
-        diffuse_coef := normal.Dot(shadow_ray)
-        if diffuse_coef > 0.00001 {
-          fmt.Println(diffuse_coef)
-          diffuse_tmp := mat.Diffuse.Scale(diffuse_coef)
-          diffuse.Iadd(glm.NewVec3(diffuse_tmp.Elem[0]*light.Colour.Elem[0], diffuse_tmp.Elem[1]*light.Colour.Elem[1], diffuse_tmp.Elem[2]*light.Colour.Elem[2]))
-        }
-        reflected_shadow_ray := shadow_ray.Subtract(normal.Scale(2*diffuse_coef))
-        specular_coef := math.Abs(math.Pow(reflected_shadow_ray.Dot(ray), mat.Shininess))
-        if specular_coef > 0.00001 {
-          specular_tmp := mat.Specular.Scale(specular_coef)
-          specular.Iadd(glm.NewVec3(specular_tmp.Elem[0]*light.Colour.Elem[0], specular_tmp.Elem[1]*light.Colour.Elem[1], specular_tmp.Elem[2]*light.Colour.Elem[2]))
-        }
-      }
+    diffuse, specular := tr.direct(surface, node, &mat, normal, *ray)
+    if mat.Subsurface != nil {
+      diffuse = tr.subsurface(surface, node, *ray, mat.Subsurface, depth)
     }
     colour.Iadd(&diffuse).Iadd(&specular)
-    // cast reflectance ray.
-    if REFLECTIONS {
-      reflected := ray.Subtract(normal.Scale(2*normal.Dot(ray)))
-      if (depth < MAX_DEPTH) {
-        if reflected_color, hit := trace(root, ambient, reflected, intersection, lights, depth+1); hit {
-          colour.Iscale(1 - mat.Mirror).Iadd(reflected_color.Scale(mat.Mirror))
-        }
-      }
+    // cast reflectance rays.
+    if mat.Mirror > 0 && depth < tr.opts.MaxDepth && weight*mat.Mirror >= tr.opts.MinContribution {
+      reflected := tr.reflect(surface, *ray, normal, mat.Roughness, *colour, depth, weight*mat.Mirror)
+      colour.Iscale(1 - mat.Mirror).Iadd(reflected.Scale(mat.Mirror))
     }
-    return colour, true
+    colour.Iadd(&mat.Emission)
+    return tr.throughMedia(*ray, *origin, dist, *colour)
+  }
+  if len(tr.sc.Volumes) > 0 {
+    ray.Normalize()
   }
-	return &glm.Vec3{}, false
+	return tr.throughMedia(*ray, *origin, math.Inf(1), miss)
 }
 
-func parallelTrace(output chan response, x,y int, root []scene.Primitive, ambient glm.Vec3, ray, origin *glm.Vec3, lights []scene.Light, depth int) {
-  go func() {
-    if ret, hit := trace(root, ambient, ray, origin, lights, depth); hit {
-      output <- response{y,x, *ret}
-    } else {
-      output <- response{y,x, *background()}
+// reflect averages the colour reflected towards ray at the surface, over a
+// glossy lobe around the mirror direction for rough materials. Rays that
+// escape the scene see the environment, or without one the unreflected
+// colour, as do rays the lobe sends into the surface.
+func (tr *tracer) reflect(surface scene.Hit, ray, normal glm.Vec3, roughness float64, unreflected glm.Vec3, depth int, weight float64) glm.Vec3 {
+  samples := 1
+  if roughness > 0 && depth == 0 {
+    samples = max(1, tr.opts.GlossSamples)
+  }
+  sum := glm.Vec3{}
+  for i := 0; i < samples; i++ {
+    h := normal
+    if roughness > 0 {
+      h = scene.SampleGGX(normal, roughness*roughness, tr.s.Float64(), tr.s.Float64())
     }
-  }()
+    dir := scene.Reflect(ray, h)
+    if dir.Dot(&surface.Normal) * ray.Dot(&surface.Normal) >= 0 {
+      sum.Iadd(&unreflected)
+      continue
+    }
+    origin := surface.Offset(dir)
+    miss := unreflected
+    if tr.env != nil {
+      miss = tr.background(dir)
+    }
+    colour := tr.trace(&dir, &origin, depth+1, weight, miss)
+    sum.Iadd(&colour)
+  }
+  return *sum.Scale(1 / float64(samples))
 }
 
-func Render(scene *scene.Scene) {
-	// hard coded scene.
-	var aspect_ratio float64 = float64(scene.Width) / float64(scene.Height)
-	var view_len float64 = (float64(scene.Height) / math.Tan(degree_to_rad(scene.FOV)/2.0)) / 2.0
-
-	hor := scene.View.Cross(&scene.Up)
-	top_pixel := scene.Eye.Copy().Iadd(scene.View.Scale(view_len))
-	hor.Normalize()
-	scene.Up.Normalize()
-	top_pixel.Iadd(hor.Scale(float64(-scene.Width) / 2.0))
-	top_pixel.Iadd(scene.Up.Scale(float64(-scene.Height) / 2.0))
-
-	img_rect := image.NewRGBA(image.Rect(0, 0, scene.Width, scene.Height))
-
-	// dump scene info
-	fmt.Println("Scene info:")
-	fmt.Println(scene)
-	pdone := 0
-	// TODO: make less hobo
-  // reserve unique channels for every ray that we want to trace
-  pixels := scene.Height * scene.Width * MSAA * MSAA
-	c := make(chan response, pixels)
-
-  // Scatter rays in parallel
-	for y := 0; y < scene.Height; y++ {
-		for x := 0; x < scene.Width; x++ {
-		  for yaa := 0; yaa < MSAA; yaa++ {
-		    for xaa := 0; xaa < MSAA; xaa++ {
+// renderTile traces one pass worth of samples (MSAA*MSAA per pixel) over t
+// and returns the summed colour of each pixel in row-major order.
+func renderTile(tr tracer, cam *camera, t Tile) []glm.Vec3 {
+  width := t.X1 - t.X0
+  out := make([]glm.Vec3, width*(t.Y1-t.Y0))
+  for y := t.Y0; y < t.Y1; y++ {
+    for x := t.X0; x < t.X1; x++ {
+      acc := &out[(y-t.Y0)*width + x-t.X0]
+      s := newSampler(t.Seed, t.Pass, x, y)
+      tr.s = s
+      for yaa := 0; yaa < MSAA; yaa++ {
+        for xaa := 0; xaa < MSAA; xaa++ {
           x_offset := SUBPIXEL_OFFSET
           y_offset := SUBPIXEL_OFFSET
-		      if JITTER {
-		        x_offset += (rand.Float64() - 0.5) * (0.5/float64(MSAA))
-		        y_offset += (rand.Float64() - 0.5) * (0.5/float64(MSAA))
+          if t.Pass > 0 {
+            // later passes spread their samples over the whole subpixel.
+            x_offset += s.Float64() - 0.5
+            y_offset += s.Float64() - 0.5
+          } else if JITTER {
+            x_offset += (s.Float64() - 0.5) * (0.5/float64(MSAA))
+            y_offset += (s.Float64() - 0.5) * (0.5/float64(MSAA))
           }
-          subpixel := top_pixel.Add(hor.Scale(aspect_ratio * float64(x))).Add(
-            scene.Up.Scale(float64(y))).Add(
-            hor.Scale(aspect_ratio * (float64(xaa) - x_offset)/float64(MSAA))).Add(
-            scene.Up.Scale((float64(yaa) - y_offset)/float64(MSAA)))
-          ray := subpixel.Subtract(&scene.Eye)
-          parallelTrace(c, x,y, scene.Primitives, scene.Ambient, ray, &scene.Eye, scene.Lights, 0)
+          ray := cam.ray(float64(x) + (float64(xaa) - x_offset)/float64(MSAA),
+            float64(y) + (float64(yaa) - y_offset)/float64(MSAA))
+          colour := tr.trace(ray, &cam.eye, 0, 1, tr.background(*ray))
+          acc.Iadd(&colour)
         }
       }
     }
-    if (100*y)/scene.Height - pdone >= 5 {
-      pdone = (100*y)/scene.Height
-      fmt.Println("Done ", pdone, "%...")
-    }
   }
-  fmt.Println("Done scatter, now gather")
+  return out
+}
+
+// A tileRenderer traces tiles, either in this process or on a worker.
+type tileRenderer interface {
+  RenderTile(t Tile) ([]glm.Vec3, error)
+}
+
+type localRenderer struct {
+  tr tracer
+  cam *camera
+}
+
+func (r localRenderer) RenderTile(t Tile) ([]glm.Vec3, error) {
+  return renderTile(r.tr, r.cam, t), nil
+}
 
-  // Gather results
-  acc := make([]glm.Vec3, scene.Height * scene.Width)
-	for i := 0; i < pixels; i++ {
-    result := <-c
-    acc[result.y * scene.Width + result.x].Iadd(&result.colour)
+// renderPass hands the tiles out to the renderers until all of them are
+// done. A tile whose renderer fails goes back on the queue for the others and
+// the failed renderer is retired; the renderers still alive are returned.
+func renderPass(renderers []tileRenderer, tiles []Tile, fb *framebuffer, ctl *control) ([]tileRenderer, error) {
+  if len(tiles) == 0 {
+    return renderers, nil
   }
-	for x := 0; x < scene.Width; x++ {
-    for y := 0; y < scene.Height; y++ {
-      a := acc[y * scene.Width + x]
-      a.Iscale(1/float64(MSAA*MSAA))
-      //clamp colour values.
-      a.Elem[0] = math.Max(0.0, math.Min(a.Elem[0], 1.0))
-      a.Elem[1] = math.Max(0.0, math.Min(a.Elem[1], 1.0))
-      a.Elem[2] = math.Max(0.0, math.Min(a.Elem[2], 1.0))
-      // pack into a color.RGBA struct
-      pixel := color.RGBA{uint8(255*a.Elem[0]), uint8(255*a.Elem[1]), uint8(255*a.Elem[2]), 255}
-      //fmt.Println(c)
-      img_rect.Set(x, scene.Height - y - 1, pixel)
+  queue := make(chan Tile, len(tiles))
+  for _, t := range tiles {
+    queue <- t
+  }
+  var mu sync.Mutex
+  remaining := len(tiles)
+  done := make(chan struct{})
+  alive := make([]bool, len(renderers))
+  var wg sync.WaitGroup
+  for i, r := range renderers {
+    alive[i] = true
+    wg.Add(1)
+    go func(i int, r tileRenderer) {
+      defer wg.Done()
+      for ctl.wait() {
+        var t Tile
+        select {
+        case t = <-queue:
+        case <-done:
+          return
+        }
+        colours, err := r.RenderTile(t)
+        if err != nil {
+          fmt.Println("Tile", t.X0, t.Y0, "failed, reassigning:", err)
+          queue <- t
+          alive[i] = false
+          return
+        }
+        fb.addTile(t, colours, MSAA*MSAA)
+        mu.Lock()
+        if remaining--; remaining == 0 {
+          close(done)
+        }
+        mu.Unlock()
+      }
+    }(i, r)
+  }
+  wg.Wait()
+  survivors := []tileRenderer{}
+  for i, r := range renderers {
+    if alive[i] {
+      survivors = append(survivors, r)
     }
-	}
+  }
+  if remaining > 0 && !ctl.Cancelled() {
+    return survivors, fmt.Errorf("all renderers failed with %d tiles left", remaining)
+  }
+  return survivors, nil
+}
+
+// Render traces opts.Passes progressive passes of sc into fb. Every pass adds
+// MSAA*MSAA samples to each pixel, so the image refines as passes complete.
+// Tiles are traced locally, or by the workers in opts.Workers if given. ctl
+// is consulted between tiles and onPass is called after each full pass.
+func Render(sc *scene.Scene, opts *Options, fb *framebuffer, ctl *control, onPass func(pass int)) error {
+  cam := newCamera(sc)
 
-	w, err := os.Create("out.png")
-	defer w.Close()
-	png.Encode(w, img_rect)
-	if (err != nil) {
-	  fmt.Println(err)
-	}
+  // dump scene info
+  fmt.Println("Scene info:")
+  fmt.Println(sc)
+  renderers := []tileRenderer{}
+  if opts.Workers != "" {
+    for _, addr := range strings.Split(opts.Workers, ",") {
+      workers, err := dialWorker(addr, sc, opts.Trace, opts.WorkerTimeout)
+      if err != nil {
+        fmt.Println("Skipping worker", addr, ":", err)
+        continue
+      }
+      renderers = append(renderers, workers...)
+    }
+    if len(renderers) == 0 {
+      return errors.New("no workers available")
+    }
+  } else {
+    tr := newTracer(sc, opts.Trace)
+    for i := 0; i < runtime.NumCPU(); i++ {
+      renderers = append(renderers, localRenderer{tr, cam})
+    }
+  }
+  for pass := 0; pass < opts.Passes; pass++ {
+    // skip tiles that already have this pass, e.g. from a checkpoint.
+    tiles := []Tile{}
+    for _, t := range splitTiles(sc.Width, sc.Height, pass, opts.Seed) {
+      if !fb.traced(t) {
+        tiles = append(tiles, t)
+      }
+    }
+    var err error
+    if renderers, err = renderPass(renderers, tiles, fb, ctl); err != nil {
+      return err
+    }
+    if ctl.Cancelled() {
+      fmt.Println("Render cancelled during pass", pass+1)
+      return nil
+    }
+    onPass(pass)
+  }
+  return nil
 }
 
 func main() {
-  sc, err := scene.CreateScene()
+  if len(os.Args) > 1 && os.Args[1] == "worker" {
+    workerMain(os.Args[2:])
+    return
+  }
+  opts := &Options{}
+  var err error
+  flag.StringVar(&opts.Output, "o", "out.png", "output image")
+  flag.IntVar(&opts.Passes, "passes", 1, "number of progressive passes to render")
+  flag.StringVar(&opts.Preview, "preview", "", "serve a live preview on this address, e.g. localhost:8080")
+  flag.StringVar(&opts.Workers, "workers", "", "comma separated worker addresses to distribute tiles to")
+  flag.DurationVar(&opts.WorkerTimeout, "worker-timeout", 5*time.Minute, "longest a worker may take over one tile before its tiles go elsewhere")
+  flag.Int64Var(&opts.Seed, "seed", 1, "seed for the sample patterns")
+  flag.StringVar(&opts.Checkpoint, "checkpoint", "", "periodically save the render state to this file")
+  flag.DurationVar(&opts.CheckpointEvery, "checkpoint-every", 5*time.Minute, "time between checkpoints")
+  flag.BoolVar(&opts.Resume, "resume", false, "continue from the -checkpoint file, adding passes up to -passes")
+  flag.Float64Var(&opts.Tone.Exposure, "exposure", 0, "exposure adjustment in stops")
+  white := flag.String("white", "1,1,1", "linear r,g,b colour that is balanced to white")
+  flag.StringVar(&opts.Tone.Operator, "tonemap", "clamp", "tone mapping operator: clamp, reinhard, aces or hable")
+  flag.IntVar(&opts.Trace.MaxDepth, "max-depth", 10, "most reflections followed along a ray")
+  flag.Float64Var(&opts.Trace.MinContribution, "min-contribution", 0.01, "smallest share of a pixel a reflection is traced for")
+  flag.IntVar(&opts.Trace.GlossSamples, "gloss-samples", 8, "reflection rays per rough mirror hit")
+  flag.IntVar(&opts.Trace.LightSamples, "light-samples", 4, "shadow rays per area light at each hit")
+  flag.IntVar(&opts.Trace.VolumeSteps, "volume-steps", 32, "steps taken through fog along each ray")
+  flag.IntVar(&opts.Trace.SubsurfaceWalks, "subsurface-walks", 4, "random walks under each translucent surface seen directly")
+  env := flag.String("env", "", "environment to light the scene with: an equirectangular .hdr, .png or .jpg image, or \"sky\" for a gradient")
+  env_rotation := flag.Float64("env-rotation", 0, "turn the environment about the up axis by this many degrees")
+  env_intensity := flag.Float64("env-intensity", 1, "scale the environment's brightness")
+  sun := flag.String("sun", "", "light the scene with daylight from the sun in this x,y,z direction, with y up and -z north")
+  sun_time := flag.String("sun-time", "", "light the scene with daylight at this RFC 3339 time, at -latitude and -longitude")
+  latitude := flag.Float64("latitude", 51.48, "latitude for -sun-time, in degrees north")
+  longitude := flag.Float64("longitude", 0, "longitude for -sun-time, in degrees east")
+  turbidity := flag.Float64("turbidity", 3, "haziness of the daylight sky, from 2 for clear to 10")
+  fog := flag.String("fog", "", "fill the scene with fog that scatters this r,g,b fraction of light per unit length")
+  fog_absorption := flag.String("fog-absorption", "0,0,0", "r,g,b fraction of light the fog absorbs per unit length")
+  fog_g := flag.Float64("fog-g", 0, "fog's scattering asymmetry, from -1 for backwards to 1 for forwards")
+  fog_top := flag.Float64("fog-top", math.Inf(1), "height the fog reaches up to")
+  volume := flag.String("volume", "", "add smoke with densities from a Mitsuba .vol grid file")
+  volume_scattering := flag.String("volume-scattering", "1,1,1", "r,g,b fraction of light the smoke scatters per unit length at density 1")
+  volume_absorption := flag.String("volume-absorption", "0.1,0.1,0.1", "r,g,b fraction of light the smoke absorbs per unit length at density 1")
+  volume_g := flag.Float64("volume-g", 0, "smoke's scattering asymmetry, from -1 for backwards to 1 for forwards")
+  sphere_sampling := flag.String("sphere-sampling", "solid-angle", "how spherical lights are sampled: solid-angle or area")
+  scene_name := flag.String("scene", "default", "built-in scene, or .gltf/.glb or .obj/.ply/.stl file, to render")
+  subdivide := flag.Int("subdivide", 0, "subdivision levels for an .obj/.ply/.stl -scene")
+  export := flag.String("export", "", "write the scene as OBJ and MTL files instead of rendering")
+  export_opts := scene.ExportOptions{}
+  flag.BoolVar(&export_opts.Tessellate, "export-tessellate", false, "tessellate spheres and boxes when exporting")
+  flag.IntVar(&export_opts.Detail, "export-detail", 16, "stacks per exported sphere")
+  flag.BoolVar(&export_opts.Bounds, "export-bounds", false, "export a wireframe of each primitive's bounding box; there is no scene-wide hierarchy")
+  flag.Parse()
+  if opts.Tone.White, err = parseColour(*white); err == nil {
+    err = opts.Tone.Validate()
+  }
+  if err == nil {
+    opts.Trace.SphereSampling, err = scene.ParseSphereSampling(*sphere_sampling)
+  }
+  var fog_medium, volume_medium scene.Medium
+  if err == nil && *fog != "" {
+    fog_medium, err = parseMedium(*fog, *fog_absorption, *fog_g)
+  }
+  if err == nil && *volume != "" {
+    volume_medium, err = parseMedium(*volume_scattering, *volume_absorption, *volume_g)
+  }
+  daylight := scene.Daylight{Turbidity: *turbidity}
+  switch {
+    case err != nil:
+    case opts.CheckpointEvery <= 0:
+      err = errors.New("-checkpoint-every must be positive")
+    case *sun != "" && *sun_time != "", (*sun != "" || *sun_time != "") && *env != "":
+      err = errors.New("use only one of -env, -sun and -sun-time")
+    case *turbidity < 2 || *turbidity > 10:
+      err = errors.New("-turbidity must be from 2 to 10")
+    case *sun != "":
+      if daylight.Sun, err = parseColour(*sun); err == nil && daylight.Sun.Length() == 0 {
+        err = errors.New("-sun needs a direction")
+      }
+    case *sun_time != "":
+      var t time.Time
+      if t, err = time.Parse(time.RFC3339, *sun_time); err == nil {
+        daylight.Sun = scene.SunPosition(t, *latitude, *longitude)
+      }
+  }
+  if err != nil {
+    fmt.Println(err)
+    return
+  }
+
+  sc, err := scene.LoadScene(*scene_name, *subdivide)
   if err != nil {
     fmt.Println(err)
     fmt.Println()
     return
   }
+  if *env != "" {
+    if sc.Environment, err = scene.LoadEnvironment(*env); err != nil {
+      fmt.Println(err)
+      return
+    }
+    sc.Environment.Rotation = *env_rotation
+    sc.Environment.Intensity = *env_intensity
+  }
+  if *sun != "" || *sun_time != "" {
+    sc.SetDaylight(daylight)
+  }
+  if *fog != "" {
+    sc.Volumes = append(sc.Volumes, scene.NewFog(fog_medium, *fog_top))
+  }
+  if *volume != "" {
+    v, err := scene.LoadVolume(*volume, volume_medium)
+    if err != nil {
+      fmt.Println(err)
+      return
+    }
+    sc.Volumes = append(sc.Volumes, v)
+  }
+  if *export != "" {
+    if err := scene.WriteObj(*export, sc, export_opts); err != nil {
+      fmt.Println(err)
+      os.Exit(1)
+    }
+    return
+  }
   t := time.Now()
   // Set up parallelism
-  scene.ReadObj("data/cow.obj")
   fmt.Println(runtime.NumCPU())
   //runtime.GOMAXPROCS(runtime.NumCPU())
+
+  fb := newFramebuffer(sc.Width, sc.Height)
+  ctl := newControl()
+  var fingerprint []byte
+  if opts.Checkpoint != "" {
+    if fingerprint, err = renderFingerprint(sc, opts.Trace); err != nil {
+      fmt.Println(err)
+      return
+    }
+  }
+  if opts.Resume {
+    if opts.Checkpoint == "" {
+      fmt.Println("-resume needs a -checkpoint file")
+      return
+    }
+    if opts.Seed, err = loadCheckpoint(opts.Checkpoint, fb, fingerprint); err != nil {
+      fmt.Println(err)
+      return
+    }
+    fmt.Println("Resuming from", opts.Checkpoint)
+  }
+  interrupt := make(chan os.Signal, 1)
+  signal.Notify(interrupt, os.Interrupt)
+  go func() {
+    <-interrupt
+    fmt.Println("Interrupted, stopping render")
+    ctl.Cancel()
+  }()
+  stop, checkpointed := make(chan struct{}), make(chan struct{})
+  if opts.Checkpoint != "" {
+    go checkpointEvery(opts.Checkpoint, opts.CheckpointEvery, fb, opts.Seed, fingerprint, stop, checkpointed)
+  } else {
+    close(checkpointed)
+  }
+  onPass := func(pass int) {
+    fmt.Println("Pass", pass+1, "of", opts.Passes, "done")
+  }
+  if opts.Preview != "" {
+    p, err := startPreview(opts.Preview, fb, ctl, &opts.Tone)
+    if err != nil {
+      fmt.Println(err)
+      return
+    }
+    defer p.publish("state", "done")
+    onPass = func(pass int) {
+      fmt.Println("Pass", pass+1, "of", opts.Passes, "done")
+      p.publish("pass", strconv.Itoa(pass+1))
+    }
+  }
   fmt.Println("Starting tracing at ", t, "...")
-  Render(sc)
+  if err := Render(sc, opts, fb, ctl, onPass); err != nil {
+    fmt.Println(err)
+  }
   fmt.Println("Done tracing at ", time.Now())
   fmt.Println("Tracing took:", time.Now().Sub(t))
+  close(stop)
+  <-checkpointed
+  if err := fb.WritePNG(opts.Output, &opts.Tone); err != nil {
+    fmt.Println(err)
+  }
 }
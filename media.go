@@ -0,0 +1,272 @@
+package main
+
+import (
+  "fmt"
+  "math"
+  "sort"
+
+  "gray/glm"
+  "gray/scene"
+)
+
+// PARTICIPATING MEDIA
+//
+// Rays through the scene's volumes are marched in jittered steps, adding the
+// light each step scatters towards the eye, lit by one sample of each light
+// in turn, and dimming what lies behind. Light reaching any point through
+// the volumes is dimmed on the way in the same way.
+
+// MAX_VOLUME_STEPS bounds the steps taken through any stretch of a volume.
+const MAX_VOLUME_STEPS = 1024
+
+// mul multiplies a and b channel by channel.
+func mul(a, b glm.Vec3) glm.Vec3 {
+  return *glm.NewVec3(a.Elem[0]*b.Elem[0], a.Elem[1]*b.Elem[1], a.Elem[2]*b.Elem[2])
+}
+
+// expNeg returns exp(-v) channel by channel.
+func expNeg(v glm.Vec3) glm.Vec3 {
+  return *glm.NewVec3(math.Exp(-v.Elem[0]), math.Exp(-v.Elem[1]), math.Exp(-v.Elem[2]))
+}
+
+// A stretch is a length of ray through the same set of volumes.
+type stretch struct {
+  near, far float64
+  volumes []int
+}
+
+// stretches splits the ray from origin along the unit dir, up to length end,
+// where it enters and leaves the volumes, keeping the lengths within any.
+// An infinite end stops where the last unbounded volume has dimmed light to
+// a thousandth.
+func (tr *tracer) stretches(dir, origin glm.Vec3, end float64) []stretch {
+  vols := tr.sc.Volumes
+  spans := make([][2]float64, len(vols))
+  cuts := []float64{0}
+  for i, v := range vols {
+    near, far, ok := v.Span(dir, origin)
+    if !ok || far <= 0 {
+      spans[i] = [2]float64{1, 0}
+      continue
+    }
+    if math.IsInf(far, 1) {
+      ext := v.Medium.Extinction()
+      if sigma := math.Min(ext.Elem[0], math.Min(ext.Elem[1], ext.Elem[2])); sigma > 0 {
+        far = math.Max(near, 0) - math.Log(1e-3)/sigma
+      }
+    }
+    near, far = math.Max(near, 0), math.Min(far, end)
+    spans[i] = [2]float64{near, far}
+    if near < far {
+      cuts = append(cuts, near, far)
+    }
+  }
+  sort.Float64s(cuts)
+  out := []stretch{}
+  for k := 1; k < len(cuts); k++ {
+    s := stretch{near: cuts[k-1], far: cuts[k]}
+    mid := (s.near + s.far) / 2
+    if s.far <= s.near || math.IsInf(mid, 0) {
+      continue
+    }
+    for i, span := range spans {
+      if span[0] <= mid && mid <= span[1] {
+        s.volumes = append(s.volumes, i)
+      }
+    }
+    if len(s.volumes) > 0 {
+      out = append(out, s)
+    }
+  }
+  return out
+}
+
+// steps is how many steps to take over s: one per grid cell of its finest
+// volume, or without grids an even share of VolumeSteps over the length
+// marched in all.
+func (tr *tracer) steps(s stretch, length float64) int {
+  cell := math.Inf(1)
+  for _, i := range s.volumes {
+    if step := tr.sc.Volumes[i].Step(); step > 0 {
+      cell = math.Min(cell, step)
+    }
+  }
+  n := float64(tr.opts.VolumeSteps)
+  if !math.IsInf(cell, 1) {
+    n = math.Ceil((s.far - s.near) / cell)
+  } else if length > 0 {
+    n = math.Ceil(n * (s.far - s.near) / length)
+  }
+  return int(math.Max(1, math.Min(n, MAX_VOLUME_STEPS)))
+}
+
+// coefficients sums the media of the volumes at p, returning the phase
+// asymmetry weighted by how much each scatters.
+func (tr *tracer) coefficients(p glm.Vec3, volumes []int) (extinction, scattering glm.Vec3, g float64) {
+  weight := 0.0
+  for _, i := range volumes {
+    v := tr.sc.Volumes[i]
+    d := v.Density(p)
+    if d <= 0 {
+      continue
+    }
+    ext := v.Medium.Extinction()
+    extinction.Iadd(ext.Scale(d))
+    scattering.Iadd(v.Medium.Scattering.Scale(d))
+    w := d * scene.Luminance(v.Medium.Scattering)
+    g += w * v.Medium.G
+    weight += w
+  }
+  if weight > 0 {
+    g /= weight
+  }
+  return
+}
+
+// transmittance is the fraction of light that survives the volumes along the
+// unit dir from origin over length dist, which may be infinite.
+func (tr *tracer) transmittance(dir, origin glm.Vec3, dist float64) glm.Vec3 {
+  t := *glm.NewVec3(1, 1, 1)
+  if len(tr.sc.Volumes) == 0 {
+    return t
+  }
+  depth := glm.Vec3{}
+  for i, v := range tr.sc.Volumes {
+    near, far, ok := v.Span(dir, origin)
+    near, far = math.Max(near, 0), math.Min(far, dist)
+    if !ok || far <= near {
+      continue
+    }
+    ext := v.Medium.Extinction()
+    if v.Grid == nil {
+      // constant density has a closed form, even over infinite lengths.
+      for c, e := range ext.Elem {
+        if e > 0 {
+          depth.Elem[c] += e * (far - near)
+        }
+      }
+      continue
+    }
+    s := stretch{near, far, []int{i}}
+    n := tr.steps(s, far - near)
+    dt := (s.far - s.near) / float64(n)
+    u := tr.s.Float64()
+    for k := 0; k < n; k++ {
+      p := origin.Add(dir.Scale(s.near + (float64(k) + u)*dt))
+      depth.Iadd(ext.Scale(v.Density(*p) * dt))
+    }
+  }
+  return expNeg(depth)
+}
+
+// throughMedia returns colour, seen at length end along the unit dir from
+// origin, as dimmed by the volumes in between with the light they scatter
+// towards origin added.
+func (tr *tracer) throughMedia(dir, origin glm.Vec3, end float64, colour glm.Vec3) glm.Vec3 {
+  if len(tr.sc.Volumes) == 0 {
+    return colour
+  }
+  t := *glm.NewVec3(1, 1, 1)
+  light := glm.Vec3{}
+  stretches := tr.stretches(dir, origin, end)
+  for _, s := range stretches {
+    n := tr.steps(s, stretches[len(stretches)-1].far)
+    dt := (s.far - s.near) / float64(n)
+    u := tr.s.Float64()
+    for k := 0; k < n; k++ {
+      p := *origin.Add(dir.Scale(s.near + (float64(k) + u)*dt))
+      ext, scattering, g := tr.coefficients(p, s.volumes)
+      step := ext.Scale(dt)
+      dimmed := expNeg(*step)
+      if scattering.Dot(&scattering) > 0 {
+        // the share of the step's light that reaches its start: the
+        // integral of the dimming over the step, for constant media.
+        w := glm.Vec3{}
+        for i := range w.Elem {
+          if ext.Elem[i] > 0 {
+            w.Elem[i] = t.Elem[i] * scattering.Elem[i] * (1 - dimmed.Elem[i]) / ext.Elem[i]
+          }
+        }
+        in := mul(w, tr.inscatter(p, dir, g))
+        light.Iadd(&in)
+      }
+      t = mul(t, dimmed)
+    }
+    // nothing behind shows through.
+    if math.Max(t.Elem[0], math.Max(t.Elem[1], t.Elem[2])) < 1e-4 {
+      return light
+    }
+  }
+  c := mul(colour, t)
+  return *c.Add(&light)
+}
+
+// inscatter is the light arriving at p, from one sample of each light,
+// weighted by the phase function for scattering back along the unit dir.
+func (tr *tracer) inscatter(p, dir glm.Vec3, g float64) glm.Vec3 {
+  root := tr.sc.Primitives
+  sum := glm.Vec3{}
+  for _, light := range tr.sc.Lights {
+    pos := light.Pos
+    to := pos.Subtract(&p)
+    if light.Angle > 0 {
+      dist := to.Length()
+      l := scene.SampleCone(*to.Scale(1 / dist), math.Cos(light.Angle/2 * math.Pi/180), tr.s.Float64(), tr.s.Float64())
+      pos = *p.Add(l.Scale(dist))
+      to = pos.Subtract(&p)
+    }
+    if hit, _, occluder_len, _ := intersectNodes(root, to, &p); hit && occluder_len < 1 {
+      continue
+    }
+    dist := to.Length()
+    l := *to.Scale(1 / dist)
+    colour := light.Colour.Scale(1 / (light.Falloff.Elem[0] + light.Falloff.Elem[1]*dist + light.Falloff.Elem[2]*dist*dist))
+    // point lights give irradiance over pi.
+    c := mul(*colour, tr.transmittance(l, p, dist))
+    sum.Iadd(c.Scale(math.Pi * scene.HenyeyGreenstein(l.Dot(&dir), g)))
+  }
+  for _, area := range tr.lights {
+    lit, pdf := area.Emitter.Sample(p, tr.s.Float64(), tr.s.Float64())
+    if pdf == 0 {
+      continue
+    }
+    target := lit.Offset(*p.Subtract(&lit.Point))
+    to := target.Subtract(&p)
+    if hit, _, occluder_len, _ := intersectNodes(root, to, &p); hit && occluder_len < 1 {
+      continue
+    }
+    dist := to.Length()
+    l := *to.Scale(1 / dist)
+    c := mul(area.Emitter.Radiance(), tr.transmittance(l, p, dist))
+    sum.Iadd(c.Scale(scene.HenyeyGreenstein(l.Dot(&dir), g) / pdf))
+  }
+  if tr.env != nil {
+    l, radiance, pdf := tr.env.Sample(tr.s.Float64(), tr.s.Float64())
+    if hit, _, _, _ := intersectNodes(root, &l, &p); pdf > 0 && !hit {
+      c := mul(radiance, tr.transmittance(l, p, math.Inf(1)))
+      sum.Iadd(c.Scale(scene.HenyeyGreenstein(l.Dot(&dir), g) / pdf))
+    }
+  }
+  return sum
+}
+
+// parseMedium reads a medium from r,g,b scattering and absorption strings.
+func parseMedium(scattering, absorption string, g float64) (scene.Medium, error) {
+  m := scene.Medium{G: g}
+  var err error
+  if m.Scattering, err = parseColour(scattering); err != nil {
+    return m, err
+  }
+  if m.Absorption, err = parseColour(absorption); err != nil {
+    return m, err
+  }
+  for i := range m.Scattering.Elem {
+    if m.Scattering.Elem[i] < 0 || m.Absorption.Elem[i] < 0 {
+      return m, fmt.Errorf("medium coefficients cannot be negative")
+    }
+  }
+  if g <= -1 || g >= 1 {
+    return m, fmt.Errorf("phase asymmetry %g is not between -1 and 1", g)
+  }
+  return m, nil
+}
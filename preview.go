@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const PREVIEW_INTERVAL = time.Second
+
+// preview serves the framebuffer of a running render over HTTP. Viewers are
+// told about new passes and state changes with server-sent events.
+type preview struct {
+  fb *framebuffer
+  ctl *control
+  tm *ToneMapping
+  mu sync.Mutex
+  clients map[chan string]bool
+}
+
+func startPreview(addr string, fb *framebuffer, ctl *control, tm *ToneMapping) (*preview, error) {
+  ln, err := net.Listen("tcp", addr)
+  if err != nil {
+    return nil, err
+  }
+  p := newPreview(fb, ctl, tm)
+  go http.Serve(ln, p.handler())
+  // passes can take a long time, so refresh viewers in between too.
+  go func() {
+    for range time.Tick(PREVIEW_INTERVAL) {
+      if !ctl.Paused() && !ctl.Cancelled() {
+        p.publish("frame", "")
+      }
+    }
+  }()
+  fmt.Println("Preview at http://" + ln.Addr().String() + "/")
+  return p, nil
+}
+
+func newPreview(fb *framebuffer, ctl *control, tm *ToneMapping) *preview {
+  return &preview{fb: fb, ctl: ctl, tm: tm, clients: map[chan string]bool{}}
+}
+
+// handler routes the viewer page, the image, the event stream and the
+// commands.
+func (p *preview) handler() http.Handler {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/", p.serveViewer)
+  mux.HandleFunc("/image.png", p.serveImage)
+  mux.HandleFunc("/events", p.serveEvents)
+  mux.HandleFunc("/pause", p.command(p.ctl.Pause, "paused"))
+  mux.HandleFunc("/resume", p.command(p.ctl.Resume, "running"))
+  mux.HandleFunc("/cancel", p.command(p.ctl.Cancel, "cancelled"))
+  return mux
+}
+
+// publish sends an event to every connected viewer, dropping it for viewers
+// that are not keeping up.
+func (p *preview) publish(event, data string) {
+  msg := fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  for c := range p.clients {
+    select {
+    case c <- msg:
+    default:
+    }
+  }
+}
+
+func (p *preview) serveViewer(w http.ResponseWriter, r *http.Request) {
+  if r.URL.Path != "/" {
+    http.NotFound(w, r)
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  fmt.Fprint(w, viewerHTML)
+}
+
+func (p *preview) serveImage(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "image/png")
+  w.Header().Set("Cache-Control", "no-store")
+  png.Encode(w, p.fb.Image(p.tm))
+}
+
+func (p *preview) serveEvents(w http.ResponseWriter, r *http.Request) {
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  c := make(chan string, 16)
+  p.mu.Lock()
+  p.clients[c] = true
+  p.mu.Unlock()
+  defer func() {
+    p.mu.Lock()
+    delete(p.clients, c)
+    p.mu.Unlock()
+  }()
+
+  state := "running"
+  if p.ctl.Cancelled() {
+    state = "cancelled"
+  } else if p.ctl.Paused() {
+    state = "paused"
+  }
+  fmt.Fprintf(w, "event: state\ndata: %s\n\n", state)
+  flusher.Flush()
+  for {
+    select {
+    case msg := <-c:
+      fmt.Fprint(w, msg)
+      flusher.Flush()
+    case <-r.Context().Done():
+      return
+    }
+  }
+}
+
+func (p *preview) command(f func(), state string) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+      http.Error(w, "POST only", http.StatusMethodNotAllowed)
+      return
+    }
+    f()
+    p.publish("state", state)
+  }
+}
+
+const viewerHTML = `<!DOCTYPE html>
+<html>
+<head>
+<title>gray</title>
+<style>
+  body { background: #222; color: #ddd; font-family: sans-serif; }
+  img { display: block; margin: 1em 0; image-rendering: pixelated; }
+</style>
+</head>
+<body>
+<div>
+  <button id="pause">Pause</button>
+  <button id="resume">Resume</button>
+  <button id="cancel">Cancel</button>
+  <span id="status">connecting...</span>
+</div>
+<img id="frame" src="/image.png">
+<script>
+  var frame = document.getElementById("frame");
+  var statusEl = document.getElementById("status");
+  var pass = 0, state = "running";
+  function show() { statusEl.textContent = state + ", " + pass + " passes done"; }
+  function reload() { frame.src = "/image.png?t=" + Date.now(); }
+  var events = new EventSource("/events");
+  events.addEventListener("frame", reload);
+  events.addEventListener("pass", function(e) { pass = e.data; reload(); show(); });
+  events.addEventListener("state", function(e) { state = e.data; reload(); show(); });
+  ["pause", "resume", "cancel"].forEach(function(cmd) {
+    document.getElementById(cmd).onclick = function() { fetch("/" + cmd, {method: "POST"}); };
+  });
+</script>
+</body>
+</html>
+`
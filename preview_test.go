@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gray/glm"
+)
+
+func testPreview() (*preview, *httptest.Server) {
+  fb := newFramebuffer(4, 2)
+  // one traced pixel, at the bottom left.
+  fb.addTile(Tile{0, 0, 1, 1, 0, 0}, []glm.Vec3{*glm.NewVec3(2, 0, 1)}, 2)
+  p := newPreview(fb, newControl(), &ToneMapping{0, *glm.NewVec3(1, 1, 1), "clamp"})
+  return p, httptest.NewServer(p.handler())
+}
+
+func TestPreviewViewer(t *testing.T) {
+  _, srv := testPreview()
+  defer srv.Close()
+  resp, err := http.Get(srv.URL + "/")
+  if err != nil {
+    t.Fatal(err)
+  }
+  body, _ := io.ReadAll(resp.Body)
+  resp.Body.Close()
+  if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !strings.Contains(string(body), `EventSource("/events")`) {
+    t.Errorf("viewer: status %d, type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+  }
+  if resp, err := http.Get(srv.URL + "/elsewhere"); err != nil || resp.StatusCode != http.StatusNotFound {
+    t.Errorf("other paths: %v, %v", resp, err)
+  }
+}
+
+func TestPreviewImage(t *testing.T) {
+  _, srv := testPreview()
+  defer srv.Close()
+  resp, err := http.Get(srv.URL + "/image.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer resp.Body.Close()
+  if resp.Header.Get("Cache-Control") != "no-store" {
+    t.Errorf("image may be cached: %q", resp.Header.Get("Cache-Control"))
+  }
+  img, err := png.Decode(resp.Body)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if b := img.Bounds(); b.Dx() != 4 || b.Dy() != 2 {
+    t.Fatalf("image is %v, want 4x2", b)
+  }
+  // rows come out top down; the pixel is the mean of its two samples.
+  if r, g, b, _ := img.At(0, 1).RGBA(); r>>8 != 255 || g != 0 || b>>8 != 188 {
+    t.Errorf("traced pixel is %d,%d,%d, want 255,0,188", r>>8, g>>8, b>>8)
+  }
+  if r, g, b, _ := img.At(3, 0).RGBA(); r != 0 || g != 0 || b != 0 {
+    t.Errorf("untraced pixel is %d,%d,%d, want black", r>>8, g>>8, b>>8)
+  }
+}
+
+func TestPreviewCommands(t *testing.T) {
+  p, srv := testPreview()
+  defer srv.Close()
+  post := func(cmd string) int {
+    resp, err := http.Post(srv.URL + "/" + cmd, "text/plain", nil)
+    if err != nil {
+      t.Fatal(err)
+    }
+    resp.Body.Close()
+    return resp.StatusCode
+  }
+  if resp, err := http.Get(srv.URL + "/pause"); err != nil || resp.StatusCode != http.StatusMethodNotAllowed || p.ctl.Paused() {
+    t.Errorf("GET /pause: %v, %v, paused %v", resp, err, p.ctl.Paused())
+  }
+  if post("pause") != http.StatusOK || !p.ctl.Paused() {
+    t.Error("not paused")
+  }
+  if post("resume") != http.StatusOK || p.ctl.Paused() {
+    t.Error("not resumed")
+  }
+  if post("cancel") != http.StatusOK || !p.ctl.Cancelled() {
+    t.Error("not cancelled")
+  }
+}
+
+func TestPreviewEvents(t *testing.T) {
+  p, srv := testPreview()
+  defer srv.Close()
+  p.ctl.Pause()
+  ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+  defer cancel()
+  req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL + "/events", nil)
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer resp.Body.Close()
+  if resp.Header.Get("Content-Type") != "text/event-stream" {
+    t.Errorf("events sent as %q", resp.Header.Get("Content-Type"))
+  }
+  r := bufio.NewReader(resp.Body)
+  // next reads one event, its lines up to the blank one.
+  next := func() string {
+    event := ""
+    for {
+      line, err := r.ReadString('\n')
+      if err != nil {
+        t.Fatalf("reading events: %v", err)
+      }
+      if line == "\n" {
+        return event
+      }
+      event += line
+    }
+  }
+  // the state is sent on connecting.
+  if e := next(); e != "event: state\ndata: paused\n" {
+    t.Errorf("first event %q, want the paused state", e)
+  }
+  if resp, err := http.Post(srv.URL + "/resume", "text/plain", nil); err == nil {
+    resp.Body.Close()
+  }
+  if e := next(); e != "event: state\ndata: running\n" {
+    t.Errorf("after resuming: event %q", e)
+  }
+  p.publish("pass", "3")
+  if e := next(); e != "event: pass\ndata: 3\n" {
+    t.Errorf("after a pass: event %q", e)
+  }
+}
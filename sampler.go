@@ -0,0 +1,30 @@
+package main
+
+// A sampler is a splitmix64 generator. Every pixel of every pass gets its own
+// stream derived from the render seed, so the samples of a pass do not depend
+// on how or where its tiles were traced and a render can be resumed from the
+// pass count alone.
+type sampler struct {
+  state uint64
+}
+
+func newSampler(seed int64, pass, x, y int) *sampler {
+  s := &sampler{uint64(seed)}
+  s.state = s.next() ^ uint64(pass)
+  s.state = s.next() ^ uint64(y)
+  s.state = s.next() ^ uint64(x)
+  return s
+}
+
+func (s *sampler) next() uint64 {
+  s.state += 0x9e3779b97f4a7c15
+  z := s.state
+  z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+  z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+  return z ^ (z >> 31)
+}
+
+// Float64 returns a uniform number in [0,1).
+func (s *sampler) Float64() float64 {
+  return float64(s.next() >> 11) / (1 << 53)
+}
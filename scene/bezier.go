@@ -0,0 +1,338 @@
+package scene
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"gray/glm"
+)
+
+const (
+  // levels of quadtree subdivision bounding each patch.
+  PATCH_DEPTH = 4
+  PATCH_NEWTON_STEPS = 12
+)
+
+// BEZIER PATCH PRIMITIVES
+
+// A BezierPatch is a bicubic Bezier surface. Control points are stored a row
+// of constant v at a time: Control[4*i + j] is the j'th point along u of row
+// i. Rays are intersected directly: a quadtree of subpatch bounds, built from
+// the subdivided control nets, picks where to start Newton iteration.
+type BezierPatch struct {
+  Control [16]glm.Vec3
+  Nodes []PatchNode
+  Mat Material
+}
+
+// A PatchNode bounds the part of a patch over [U0,U1]x[V0,V1]. Leaves have no
+// children; the root is node 0, so a zero child index means none.
+type PatchNode struct {
+  Bound AABB
+  U0, U1, V0, V1 float64
+  Children [4]int
+}
+
+func NewBezierPatch(control [16]glm.Vec3, mat Material) *BezierPatch {
+  p := &BezierPatch{Control: control, Mat: mat}
+  p.build(control, 0, 1, 0, 1, 0)
+  return p
+}
+
+func (p *BezierPatch) build(cp [16]glm.Vec3, u0, u1, v0, v1 float64, depth int) int {
+  bound := AABB{cp[0], cp[0], p.Mat}
+  for _, c := range cp[1:] {
+    bound = bound.Grow(c)
+  }
+  // pad so that flat subpatches still have some volume.
+  pad := bound.Max.Subtract(&bound.Min).Length()*1e-3 + Epsilon
+  bound.Min.Isubtract(glm.NewVec3(pad, pad, pad))
+  bound.Max.Iadd(glm.NewVec3(pad, pad, pad))
+
+  index := len(p.Nodes)
+  p.Nodes = append(p.Nodes, PatchNode{bound, u0, u1, v0, v1, [4]int{}})
+  if depth == PATCH_DEPTH {
+    return index
+  }
+  um, vm := 0.5*(u0 + u1), 0.5*(v0 + v1)
+  low, high := splitU(cp)
+  ll, lh := splitV(low)
+  hl, hh := splitV(high)
+  children := [4]int{
+    p.build(ll, u0, um, v0, vm, depth+1),
+    p.build(hl, um, u1, v0, vm, depth+1),
+    p.build(lh, u0, um, vm, v1, depth+1),
+    p.build(hh, um, u1, vm, v1, depth+1),
+  }
+  p.Nodes[index].Children = children
+  return index
+}
+
+// casteljau splits a cubic at t=0.5.
+func casteljau(a, b, c, d glm.Vec3) (left, right [4]glm.Vec3) {
+  ab := a.Add(&b).Scale(0.5)
+  bc := b.Add(&c).Scale(0.5)
+  cd := c.Add(&d).Scale(0.5)
+  abc := ab.Add(bc).Scale(0.5)
+  bcd := bc.Add(cd).Scale(0.5)
+  mid := abc.Add(bcd).Scale(0.5)
+  return [4]glm.Vec3{a, *ab, *abc, *mid}, [4]glm.Vec3{*mid, *bcd, *cd, d}
+}
+
+func splitU(cp [16]glm.Vec3) (low, high [16]glm.Vec3) {
+  for i := 0; i < 4; i++ {
+    l, h := casteljau(cp[4*i], cp[4*i+1], cp[4*i+2], cp[4*i+3])
+    copy(low[4*i:4*i+4], l[:])
+    copy(high[4*i:4*i+4], h[:])
+  }
+  return
+}
+
+func splitV(cp [16]glm.Vec3) (low, high [16]glm.Vec3) {
+  for j := 0; j < 4; j++ {
+    l, h := casteljau(cp[j], cp[4+j], cp[8+j], cp[12+j])
+    for i := 0; i < 4; i++ {
+      low[4*i+j], high[4*i+j] = l[i], h[i]
+    }
+  }
+  return
+}
+
+// bernstein returns the cubic Bernstein weights at t and their derivatives.
+func bernstein(t float64) (b, db [4]float64) {
+  s := 1 - t
+  b = [4]float64{s*s*s, 3*t*s*s, 3*t*t*s, t*t*t}
+  db = [4]float64{-3*s*s, 3*s*s - 6*t*s, 6*t*s - 3*t*t, 3*t*t}
+  return
+}
+
+// Eval returns the surface point and its partial derivatives at u,v.
+func (p BezierPatch) Eval(u, v float64) (point, du, dv glm.Vec3) {
+  bu, dbu := bernstein(u)
+  bv, dbv := bernstein(v)
+  for i := 0; i < 4; i++ {
+    for j := 0; j < 4; j++ {
+      c := &p.Control[4*i + j]
+      point.Iadd(c.Scale(bv[i]*bu[j]))
+      du.Iadd(c.Scale(bv[i]*dbu[j]))
+      dv.Iadd(c.Scale(dbv[i]*bu[j]))
+    }
+  }
+  return
+}
+
+// Normal returns the surface normal at u,v, stepping off degenerate points
+// such as the poles of a teapot's lid.
+func (p BezierPatch) Normal(u, v float64) glm.Vec3 {
+  for i := 0; i < 4; i++ {
+    _, du, dv := p.Eval(u, v)
+    if n := du.Cross(&dv); n.Dot(n) > Epsilon*Epsilon*du.Dot(&du)*dv.Dot(&dv) {
+      return *n
+    }
+    u += 0.01*(0.5 - u)
+    v += 0.01*(0.5 - v)
+  }
+  _, du, dv := p.Eval(u, v)
+  return *du.Cross(&dv)
+}
+
+// newton solves for the point where the ray meets the patch, starting at
+// u,v. The ray is the intersection of the planes n1.x = d1 and n2.x = d2.
+func (p BezierPatch) newton(n1, n2 glm.Vec3, d1, d2, u, v, tol float64) (float64, float64, bool) {
+  for i := 0; i < PATCH_NEWTON_STEPS; i++ {
+    s, du, dv := p.Eval(u, v)
+    f1, f2 := n1.Dot(&s) - d1, n2.Dot(&s) - d2
+    if math.Abs(f1) < tol && math.Abs(f2) < tol {
+      return u, v, true
+    }
+    a, b := n1.Dot(&du), n1.Dot(&dv)
+    c, d := n2.Dot(&du), n2.Dot(&dv)
+    det := a*d - b*c
+    if det == 0 {
+      return u, v, false
+    }
+    u -= (d*f1 - b*f2) / det
+    v -= (a*f2 - c*f1) / det
+  }
+  return u, v, false
+}
+
+func (p BezierPatch) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  n1, n2 := orthonormal(unit(ray))
+  d1, d2 := n1.Dot(&origin), n2.Dot(&origin)
+  tol := p.tolerance()
+  best_u, best_v := 0.0, 0.0
+  stack := []int{0}
+  for len(stack) > 0 {
+    node := &p.Nodes[stack[len(stack)-1]]
+    stack = stack[:len(stack)-1]
+    near, far, hit := node.Bound.Span(ray, origin)
+    if !hit || far < 0 || (b && near > raylen) {
+      continue
+    }
+    if node.Children[0] != 0 {
+      stack = append(stack, node.Children[:]...)
+      continue
+    }
+    u, v, ok := p.newton(n1, n2, d1, d2, 0.5*(node.U0 + node.U1), 0.5*(node.V0 + node.V1), tol)
+    // a solution from outside this leaf is found again by its own leaf.
+    slack := 1e-6
+    if !ok || u < node.U0 - slack || u > node.U1 + slack || v < node.V0 - slack || v > node.V1 + slack {
+      continue
+    }
+    s, _, _ := p.Eval(u, v)
+    t := s.Subtract(&origin).Dot(&ray) / ray.Dot(&ray)
+    if t > 0 && (!b || t < raylen) {
+      b, raylen, best_u, best_v = true, t, u, v
+    }
+  }
+  if b {
+    // patches have no inside, and their winding is whatever the data had.
+    normal = facing(p.Normal(best_u, best_v), ray)
+  }
+  return
+}
+
+// tolerance is how far from the ray a solution may be, in world units.
+func (p BezierPatch) tolerance() float64 {
+  root := p.Nodes[0].Bound
+  return root.Max.Subtract(&root.Min).Length() * 1e-9
+}
+
+// HitPoint bounds the hit by the tolerance newton stopped at: the surface
+// point found may lie that far off the ray on both planes.
+func (p BezierPatch) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  h := alongRay(ray, origin, raylen, 16, normal)
+  for i := range h.Error.Elem {
+    h.Error.Elem[i] += 2*p.tolerance()
+  }
+  return h
+}
+
+func (p BezierPatch) Bounds() AABB {
+  return p.Nodes[0].Bound
+}
+
+func (p BezierPatch) GetMaterial() Material {
+  return p.Mat
+}
+
+// Tessellate approximates the patch with an n by n grid of quads.
+func (p BezierPatch) Tessellate(n int) *Mesh {
+  verts := [][3]float64{}
+  faces := [][3]int{}
+  for i := 0; i <= n; i++ {
+    for j := 0; j <= n; j++ {
+      s, _, _ := p.Eval(float64(j)/float64(n), float64(i)/float64(n))
+      verts = append(verts, s.Elem)
+    }
+  }
+  for i := 0; i < n; i++ {
+    for j := 0; j < n; j++ {
+      a := i*(n+1) + j
+      faces = append(faces, [3]int{a, a+1, a+n+2}, [3]int{a, a+n+2, a+n+1})
+    }
+  }
+  return NewMesh(verts, faces, p.Mat)
+}
+
+// PATCH LOADER
+
+// ReadPatches reads the patch format of the classic Utah teapot data: a
+// patch count, one line of 16 (1-based) vertex indices per patch, a vertex
+// count and one x,y,z line per vertex. Commas and spaces both separate.
+func ReadPatches(file string, mat Material) ([]BezierPatch, error) {
+  infile, err := os.Open(file)
+  if err != nil {
+    return nil, err
+  }
+  defer infile.Close()
+  sc := bufio.NewScanner(infile)
+  lineno := 0
+  fields := func() ([]string, error) {
+    for sc.Scan() {
+      lineno++
+      f := strings.FieldsFunc(sc.Text(), func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+      if len(f) > 0 {
+        return f, nil
+      }
+    }
+    if err := sc.Err(); err != nil {
+      return nil, err
+    }
+    return nil, fmt.Errorf("%s: unexpected end of file", file)
+  }
+  count := func() (int, error) {
+    f, err := fields()
+    if err != nil {
+      return 0, err
+    }
+    n, err := strconv.Atoi(f[0])
+    if err != nil || n < 0 {
+      return 0, fmt.Errorf("%s:%d: bad count %q", file, lineno, f[0])
+    }
+    return n, nil
+  }
+
+  // entries are appended as they are read, so a bad count runs out of
+  // file rather than memory.
+  npatches, err := count()
+  if err != nil {
+    return nil, err
+  }
+  indices := [][16]int{}
+  for i := 0; i < npatches; i++ {
+    f, err := fields()
+    if err != nil {
+      return nil, err
+    }
+    if len(f) != 16 {
+      return nil, fmt.Errorf("%s:%d: patch has %d indices, want 16", file, lineno, len(f))
+    }
+    var idx [16]int
+    for j, s := range f {
+      if idx[j], err = strconv.Atoi(s); err != nil {
+        return nil, fmt.Errorf("%s:%d: %v", file, lineno, err)
+      }
+    }
+    indices = append(indices, idx)
+  }
+  nverts, err := count()
+  if err != nil {
+    return nil, err
+  }
+  verts := []glm.Vec3{}
+  for i := 0; i < nverts; i++ {
+    f, err := fields()
+    if err != nil {
+      return nil, err
+    }
+    if len(f) != 3 {
+      return nil, fmt.Errorf("%s:%d: vertex has %d coordinates, want 3", file, lineno, len(f))
+    }
+    var v glm.Vec3
+    for j, s := range f {
+      if v.Elem[j], err = strconv.ParseFloat(s, 64); err != nil {
+        return nil, fmt.Errorf("%s:%d: %v", file, lineno, err)
+      }
+    }
+    verts = append(verts, v)
+  }
+
+  patches := make([]BezierPatch, npatches)
+  for i, idx := range indices {
+    control := [16]glm.Vec3{}
+    for j, k := range idx {
+      if k < 1 || k > nverts {
+        return nil, fmt.Errorf("%s: patch %d uses vertex %d of %d", file, i+1, k, nverts)
+      }
+      control[j] = verts[k-1]
+    }
+    patches[i] = *NewBezierPatch(control, mat)
+  }
+  return patches, nil
+}
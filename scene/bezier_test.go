@@ -0,0 +1,78 @@
+package scene
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gray/glm"
+)
+
+// a patch bulging up out of the unit square in the xz plane.
+func dome() *BezierPatch {
+  var control [16]glm.Vec3
+  for i := 0; i < 4; i++ {
+    for j := 0; j < 4; j++ {
+      h := 0.0
+      if i > 0 && i < 3 && j > 0 && j < 3 {
+        h = 0.5
+      }
+      control[4*i + j] = vec(float64(j)/3, h, float64(i)/3)
+    }
+  }
+  return NewBezierPatch(control, Material{})
+}
+
+func TestBezierPatchFacing(t *testing.T) {
+  p := dome()
+  // the dome is 0.5*9/16 high in the middle, where it is flat.
+  top := 0.5 * 9.0/16
+  for _, c := range []struct {
+    name string
+    ray, origin glm.Vec3
+  }{
+    {"from above", vec(0, -1, 0), vec(0.5, 2, 0.5)},
+    {"from below", vec(0, 1, 0), vec(0.5, -2, 0.5)},
+  } {
+    hit, raylen, normal := p.Intersect(c.ray, c.origin)
+    if !hit {
+      t.Errorf("%s: missed", c.name)
+      continue
+    }
+    if h := c.origin.Elem[1] + raylen*c.ray.Elem[1]; !near(h, top) {
+      t.Errorf("%s: hit at height %g, want %g", c.name, h, top)
+    }
+    if n := unit(normal); n.Dot(&c.ray) > -1 + 1e-9 {
+      t.Errorf("%s: normal %v does not face the ray", c.name, n)
+    }
+  }
+}
+
+func TestReadPatches(t *testing.T) {
+  // the dome's control points, as one patch.
+  patch := "1\n1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16\n16\n"
+  for _, v := range dome().Control {
+    patch += fmt.Sprintf("%g, %g, %g\n", v.Elem[0], v.Elem[1], v.Elem[2])
+  }
+  patches, err := ReadPatches(writeTemp(t, "dome.patch", patch), Material{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(patches) != 1 || patches[0].Control != dome().Control {
+    t.Errorf("read %d patches, want the dome", len(patches))
+  }
+
+  for _, c := range []struct {
+    name, data string
+  }{
+    {"huge patch count", "1000000000000\n"},
+    {"huge vertex count", "1\n1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16\n1000000000000\n0 0 0\n"},
+    {"negative count", "-1\n"},
+    {"missing vertex", strings.Replace(patch, ",16\n", ",17\n", 1)},
+    {"short patch", "1\n1,2,3\n"},
+  } {
+    if _, err := ReadPatches(writeTemp(t, "bad.patch", c.data), Material{}); err == nil {
+      t.Errorf("%s: read without error", c.name)
+    }
+  }
+}
@@ -0,0 +1,42 @@
+package scene
+
+import (
+	"math"
+
+	"gray/glm"
+)
+
+// Colours in scene descriptions and textures are sRGB encoded, the renderer
+// works in linear RGB.
+
+func SRGBToLinear(c float64) float64 {
+  if c <= 0.04045 {
+    return c / 12.92
+  }
+  return math.Pow((c + 0.055)/1.055, 2.4)
+}
+
+func LinearToSRGB(c float64) float64 {
+  if c <= 0.0031308 {
+    return 12.92 * c
+  }
+  return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+func SRGBToLinearVec(v glm.Vec3) glm.Vec3 {
+  return *glm.NewVec3(SRGBToLinear(v.Elem[0]), SRGBToLinear(v.Elem[1]), SRGBToLinear(v.Elem[2]))
+}
+
+// Linear returns m with its sRGB colours converted to linear RGB.
+func (m Material) Linear() Material {
+  m.Ambient = SRGBToLinearVec(m.Ambient)
+  m.Diffuse = SRGBToLinearVec(m.Diffuse)
+  m.Specular = SRGBToLinearVec(m.Specular)
+  return m
+}
+
+// Luminance is the brightness of the linear colour v, by the Rec. 709
+// weights.
+func Luminance(v glm.Vec3) float64 {
+  return 0.2126*v.Elem[0] + 0.7152*v.Elem[1] + 0.0722*v.Elem[2]
+}
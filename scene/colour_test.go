@@ -0,0 +1,33 @@
+package scene
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSRGB(t *testing.T) {
+  for _, c := range []struct {
+    linear, srgb float64
+  }{
+    {0, 0},
+    {1, 1},
+    // either side of the linear toe.
+    {0.0031308, 0.04045},
+    {0.001, 0.01292},
+    {0.5, 0.7353570},
+    {0.2140411, 0.5},
+    {0.0508761, 0.25},
+  } {
+    if got := LinearToSRGB(c.linear); math.Abs(got - c.srgb) > 1e-5 {
+      t.Errorf("linear %g is sRGB %g, want %g", c.linear, got, c.srgb)
+    }
+    if got := SRGBToLinear(c.srgb); math.Abs(got - c.linear) > 1e-5 {
+      t.Errorf("sRGB %g is linear %g, want %g", c.srgb, got, c.linear)
+    }
+  }
+  for x := 0.0; x <= 1; x += 1.0/64 {
+    if y := SRGBToLinear(LinearToSRGB(x)); math.Abs(y - x) > 1e-12 {
+      t.Errorf("%g round trips to %g", x, y)
+    }
+  }
+}
@@ -0,0 +1,167 @@
+package scene
+
+import (
+	"math"
+	"sort"
+
+	"gray/glm"
+)
+
+// An Interval is a stretch of a ray's line inside a solid, with the outward
+// normals where the line enters and leaves. Intervals may lie behind the
+// ray's origin.
+type Interval struct {
+  Enter, Exit float64
+  EnterNormal, ExitNormal glm.Vec3
+}
+
+// A Solid is a closed primitive that reports every interval along a ray's
+// line that lies inside it, in order.
+type Solid interface {
+  Primitive
+  Intervals(ray, origin glm.Vec3) []Interval
+}
+
+// firstHit returns the first interval boundary in front of the origin.
+func firstHit(intervals []Interval) (b bool, raylen float64, normal glm.Vec3) {
+  for _, in := range intervals {
+    if in.Enter > 0 {
+      return true, in.Enter, in.EnterNormal
+    }
+    if in.Exit > 0 {
+      return true, in.Exit, in.ExitNormal
+    }
+  }
+  return false, 0, normal
+}
+
+// CSG PRIMITIVES
+
+type CSGOp int
+
+const (
+  Union CSGOp = iota
+  Intersection
+  Difference // A minus B
+)
+
+// A CSG node combines two solids. It is a solid itself, so nodes nest.
+type CSG struct {
+  Op CSGOp
+  A, B Solid
+  Mat Material
+}
+
+func (p CSG) inside(inA, inB bool) bool {
+  switch p.Op {
+    case Union:
+      return inA || inB
+    case Intersection:
+      return inA && inB
+  }
+  return inA && !inB
+}
+
+func (p CSG) Intervals(ray, origin glm.Vec3) []Interval {
+  // operands that do not overlap have no intersection.
+  if p.Op == Intersection {
+    if b := p.Bounds(); b.Empty() {
+      return nil
+    }
+  }
+  type event struct {
+    raylen float64
+    fromA, enter bool
+    normal glm.Vec3
+  }
+  events := []event{}
+  for _, in := range p.A.Intervals(ray, origin) {
+    events = append(events, event{in.Enter, true, true, in.EnterNormal}, event{in.Exit, true, false, in.ExitNormal})
+  }
+  for _, in := range p.B.Intervals(ray, origin) {
+    // what is outside B is inside a difference.
+    enter, exit := in.EnterNormal, in.ExitNormal
+    if p.Op == Difference {
+      enter, exit = *exit.Scale(-1), *enter.Scale(-1)
+      events = append(events, event{in.Enter, false, true, exit}, event{in.Exit, false, false, enter})
+    } else {
+      events = append(events, event{in.Enter, false, true, enter}, event{in.Exit, false, false, exit})
+    }
+  }
+  sort.SliceStable(events, func(i, j int) bool { return events[i].raylen < events[j].raylen })
+
+  intervals := []Interval{}
+  inA, inB, inside := false, false, false
+  for _, e := range events {
+    if e.fromA {
+      inA = e.enter
+    } else {
+      inB = e.enter
+    }
+    now := p.inside(inA, inB)
+    if now == inside {
+      continue
+    }
+    if now {
+      intervals = append(intervals, Interval{Enter: e.raylen, EnterNormal: e.normal})
+    } else {
+      last := &intervals[len(intervals)-1]
+      last.Exit, last.ExitNormal = e.raylen, e.normal
+    }
+    inside = now
+  }
+  // drop slivers left by coincident surfaces.
+  result := intervals[:0]
+  for _, in := range intervals {
+    if in.Exit - in.Enter > Epsilon {
+      result = append(result, in)
+    }
+  }
+  return result
+}
+
+func (p CSG) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  return firstHit(p.Intervals(ray, origin))
+}
+
+// HitPoint hands the hit to the operand whose boundary it lies on, which
+// Intervals passes through unchanged.
+func (p CSG) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  for _, s := range []Solid{p.A, p.B} {
+    for _, in := range s.Intervals(ray, origin) {
+      if in.Enter == raylen || in.Exit == raylen {
+        h := HitAt(s, ray, origin, raylen, normal)
+        h.Normal = normal
+        return h
+      }
+    }
+  }
+  return alongRay(ray, origin, raylen, 16, normal)
+}
+
+func (p CSG) Bounds() AABB {
+  inf := math.Inf(1)
+  a := AABB{*glm.NewVec3(-inf, -inf, -inf), *glm.NewVec3(inf, inf, inf), p.Mat}
+  b := a
+  if bounded, ok := p.A.(Bounded); ok {
+    a = bounded.Bounds()
+  }
+  if bounded, ok := p.B.(Bounded); ok {
+    b = bounded.Bounds()
+  }
+  switch p.Op {
+    case Union:
+      return a.Union(b)
+    case Intersection:
+      box := AABB{vecMax(a.Min, b.Min), vecMin(a.Max, b.Max), p.Mat}
+      if box.Empty() {
+        return emptyBox(p.Mat)
+      }
+      return box
+  }
+  return AABB{a.Min, a.Max, p.Mat}
+}
+
+func (p CSG) GetMaterial() Material {
+  return p.Mat
+}
@@ -0,0 +1,128 @@
+package scene
+
+import (
+	"testing"
+
+	"gray/glm"
+)
+
+// INTERVALS
+
+func checkIntervals(t *testing.T, name string, got, want []Interval) {
+  if len(got) != len(want) {
+    t.Errorf("%s: intervals %v, want %v", name, got, want)
+    return
+  }
+  for i := range got {
+    g, w := got[i], want[i]
+    if !near(g.Enter, w.Enter) || !near(g.Exit, w.Exit) || !nearVec(unit(g.EnterNormal), w.EnterNormal) || !nearVec(unit(g.ExitNormal), w.ExitNormal) {
+      t.Errorf("%s: interval %d is %v, want %v", name, i, g, w)
+    }
+  }
+}
+
+func TestIntervals(t *testing.T) {
+  left, right := vec(-1, 0, 0), vec(1, 0, 0)
+  cube := NewMesh(cubeVerts, Triangulate(cubeQuads), Material{})
+  for _, c := range []struct {
+    name string
+    solid Solid
+    ray, origin glm.Vec3
+    want []Interval
+  }{
+    {"sphere", Sphere{vec(0, 0, 0), 1, Material{}}, right, vec(-5, 0, 0), []Interval{{4, 6, left, right}}},
+    {"sphere, long ray", Sphere{vec(0, 0, 0), 1, Material{}}, vec(2, 0, 0), vec(-5, 0, 0), []Interval{{2, 3, left, right}}},
+    {"sphere from inside", Sphere{vec(0, 0, 0), 1, Material{}}, right, vec(0.5, 0, 0), []Interval{{-1.5, 0.5, left, right}}},
+    {"sphere missed", Sphere{vec(0, 0, 0), 1, Material{}}, right, vec(-5, 2, 0), nil},
+    {"box", Box{vec(0, 0, 0), 2, Material{}}, right, vec(-1, 1, 1), []Interval{{1, 3, left, right}}},
+    {"box missed", Box{vec(0, 0, 0), 2, Material{}}, right, vec(-1, 3, 1), nil},
+    {"mesh", *cube, right, vec(-5, 0.5, 0.25), []Interval{{4, 6, left, right}}},
+    {"mesh through an edge", *cube, right, vec(-5, 0, 0), []Interval{{4, 6, left, right}}},
+    {"mesh missed", *cube, right, vec(-5, 2, 0), nil},
+  } {
+    checkIntervals(t, c.name, c.solid.Intervals(c.ray, c.origin), c.want)
+  }
+}
+
+// CSG
+
+func TestCSG(t *testing.T) {
+  // unit spheres around x = 0 and x = 1, crossed along the x axis.
+  a, b := Sphere{vec(0, 0, 0), 1, Material{}}, Sphere{vec(1, 0, 0), 1, Material{}}
+  left, right := vec(-1, 0, 0), vec(1, 0, 0)
+  for _, c := range []struct {
+    name string
+    op CSGOp
+    ray, origin glm.Vec3
+    want []Interval
+  }{
+    {"union", Union, right, vec(-5, 0, 0), []Interval{{4, 7, left, right}}},
+    {"union from inside", Union, right, vec(0.5, 0, 0), []Interval{{-1.5, 1.5, left, right}}},
+    {"intersection", Intersection, right, vec(-5, 0, 0), []Interval{{5, 6, left, right}}},
+    {"intersection backwards", Intersection, left, vec(5, 0, 0), []Interval{{4, 5, right, left}}},
+    {"difference", Difference, right, vec(-5, 0, 0), []Interval{{4, 5, left, right}}},
+    // seen from the side B was cut from, the cut faces back towards it.
+    {"difference backwards", Difference, left, vec(5, 0, 0), []Interval{{5, 6, right, left}}},
+    {"difference missed", Difference, right, vec(-5, 0, 1.5), nil},
+  } {
+    p := CSG{c.op, a, b, Material{}}
+    got := p.Intervals(c.ray, c.origin)
+    checkIntervals(t, c.name, got, c.want)
+    if len(c.want) == 0 {
+      if hit, _, _ := p.Intersect(c.ray, c.origin); hit {
+        t.Errorf("%s: hit", c.name)
+      }
+      continue
+    }
+    want, normal := c.want[0].Enter, c.want[0].EnterNormal
+    if want <= 0 {
+      want, normal = c.want[0].Exit, c.want[0].ExitNormal
+    }
+    if hit, raylen, n := p.Intersect(c.ray, c.origin); !hit || !near(raylen, want) || !nearVec(unit(n), normal) {
+      t.Errorf("%s: hit %v at %g with normal %v, want %g with %v", c.name, hit, raylen, n, want, normal)
+    }
+  }
+  // from inside a difference the ray leaves through the cut.
+  p := CSG{Difference, a, b, Material{}}
+  if hit, raylen, n := p.Intersect(right, vec(-0.5, 0, 0)); !hit || !near(raylen, 0.5) || !nearVec(unit(n), right) {
+    t.Errorf("from inside the difference: hit %v at %g with normal %v, want 0.5 with %v", hit, raylen, n, right)
+  }
+}
+
+func TestCSGBounds(t *testing.T) {
+  a, b := Sphere{vec(0, 0, 0), 1, Material{}}, Sphere{vec(1, 0, 0), 1, Material{}}
+  for _, c := range []struct {
+    op CSGOp
+    min, max glm.Vec3
+  }{
+    {Union, vec(-1, -1, -1), vec(2, 1, 1)},
+    {Intersection, vec(0, -1, -1), vec(1, 1, 1)},
+    {Difference, vec(-1, -1, -1), vec(1, 1, 1)},
+  } {
+    if box := (CSG{c.op, a, b, Material{}}).Bounds(); !nearVec(box.Min, c.min) || !nearVec(box.Max, c.max) {
+      t.Errorf("op %d: bounds %v to %v, want %v to %v", c.op, box.Min, box.Max, c.min, c.max)
+    }
+  }
+
+  // apart, the spheres have no intersection.
+  far := Sphere{vec(5, 0, 0), 1, Material{}}
+  p := CSG{Intersection, a, far, Material{}}
+  box := p.Bounds()
+  if !box.Empty() {
+    t.Errorf("bounds %v to %v, want empty", box.Min, box.Max)
+  }
+  if hit, _, _ := box.Intersect(vec(1, 0, 0), vec(-5, 0, 0)); hit {
+    t.Error("hit the empty bounds")
+  }
+  if hit, _, _ := p.Intersect(vec(1, 0, 0), vec(-5, 0, 0)); hit {
+    t.Error("hit the intersection of spheres apart")
+  }
+  if u := box.Union(a.Bounds()); !nearVec(u.Min, vec(-1, -1, -1)) || !nearVec(u.Max, vec(1, 1, 1)) {
+    t.Errorf("union with empty bounds is %v to %v", u.Min, u.Max)
+  }
+  // nested in a union, the empty part adds nothing.
+  nested := CSG{Union, p, Sphere{vec(10, 0, 0), 1, Material{}}, Material{}}
+  if u := nested.Bounds(); !nearVec(u.Min, vec(9, -1, -1)) || !nearVec(u.Max, vec(11, 1, 1)) {
+    t.Errorf("nested bounds %v to %v, want (9,-1,-1) to (11,1,1)", u.Min, u.Max)
+  }
+}
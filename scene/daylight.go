@@ -0,0 +1,151 @@
+package scene
+
+import (
+	"math"
+	"time"
+
+	"gray/glm"
+)
+
+// DAYLIGHT
+//
+// The sun and sky of Preetham, Shirley and Smits, "A Practical Analytic Model
+// for Daylight" (1999). Directions are in the scene's frame with y up, +x
+// east and -z north.
+
+// DaylightUnit is the luminance, in kcd/m², of one unit of scene radiance,
+// chosen so that white surfaces in full sun come out near 1.
+const DaylightUnit = 30.0
+
+// SunAngle is the angle the sun's disc spans, in degrees.
+const SunAngle = 0.53
+
+// A Daylight is the sun, in the unit direction Sun, and the sky it lights
+// through air of the given turbidity: 2 is a clear day, 10 hazy.
+type Daylight struct {
+  Sun glm.Vec3
+  Turbidity float64
+}
+
+// SunPosition is the unit direction to the sun at t, seen from latitude and
+// longitude in degrees, north and east positive. It follows NOAA's
+// low-precision formulas, good to a fraction of a degree.
+func SunPosition(t time.Time, latitude, longitude float64) glm.Vec3 {
+  t = t.UTC()
+  hours := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+  // the fraction of the year, in radians.
+  g := 2*math.Pi/365 * (float64(t.YearDay() - 1) + (hours - 12)/24)
+  eqtime := 229.18 * (0.000075 + 0.001868*math.Cos(g) - 0.032077*math.Sin(g) - 0.014615*math.Cos(2*g) - 0.040849*math.Sin(2*g))
+  decl := 0.006918 - 0.399912*math.Cos(g) + 0.070257*math.Sin(g) - 0.006758*math.Cos(2*g) + 0.000907*math.Sin(2*g) - 0.002697*math.Cos(3*g) + 0.00148*math.Sin(3*g)
+  // true solar time in minutes gives the hour angle, 0 at solar noon.
+  solar := hours*60 + eqtime + 4*longitude
+  ha := (solar/4 - 180) * math.Pi/180
+  lat := latitude * math.Pi/180
+  up := math.Sin(lat)*math.Sin(decl) + math.Cos(lat)*math.Cos(decl)*math.Cos(ha)
+  east := -math.Cos(decl) * math.Sin(ha)
+  north := math.Cos(lat)*math.Sin(decl) - math.Sin(lat)*math.Cos(decl)*math.Cos(ha)
+  return unit(*glm.NewVec3(east, up, -north))
+}
+
+// SetDaylight lights sc with d: the sky becomes its environment and the sun
+// joins its lights, unless it has set.
+func (sc *Scene) SetDaylight(d Daylight) {
+  d.Sun = unit(d.Sun)
+  sc.Environment = &Environment{NewPreetham(d), 0, 1}
+  if sun, ok := d.SunLight(); ok {
+    sc.Lights = append(sc.Lights, sun)
+  }
+}
+
+// SunLight is the sun as a light far away in its direction, dimmed and
+// reddened by the air its light passes through. There is none once the sun
+// has set.
+func (d Daylight) SunLight() (Light, bool) {
+  sun := unit(d.Sun)
+  if sun.Elem[1] <= 0 {
+    return Light{}, false
+  }
+  theta := math.Acos(sun.Elem[1])
+  // the relative optical mass of the air along the sun's rays, from Kasten.
+  mass := 1 / (sun.Elem[1] + 0.15*math.Pow(93.885 - theta*180/math.Pi, -1.253))
+  // Angstrom's turbidity coefficient for aerosols.
+  beta := 0.04608*d.Turbidity - 0.04586
+  colour := glm.Vec3{}
+  // wavelengths in micrometres standing in for red, green and blue.
+  for i, lambda := range [3]float64{0.61, 0.55, 0.465} {
+    rayleigh := math.Exp(-0.008735 * math.Pow(lambda, -4.08) * mass)
+    aerosol := math.Exp(-beta * math.Pow(lambda, -1.3) * mass)
+    colour.Elem[i] = rayleigh * aerosol
+  }
+  // point lights give irradiance over pi; the sun outside the atmosphere
+  // gives some 128 klx.
+  colour.Iscale(128 / math.Pi / DaylightUnit)
+  return Light{*sun.Scale(1e6), colour, *glm.NewVec3(1, 0, 0), SunAngle}, true
+}
+
+// A Preetham sky is the light scattered by the air, without the sun's disc,
+// which is a light of its own. It is dark below the horizon.
+type Preetham struct {
+  Sun glm.Vec3
+  // the Perez coefficients for luminance Y and chromaticity x, y.
+  PerezLum, PerezX, PerezY [5]float64
+  // the luminance and chromaticity at the zenith.
+  ZenithLum, ZenithX, ZenithY float64
+}
+
+// NewPreetham works out the sky's coefficients for d.
+func NewPreetham(d Daylight) Preetham {
+  sun := unit(d.Sun)
+  t := d.Turbidity
+  p := Preetham{Sun: sun}
+  p.PerezLum = [5]float64{0.1787*t - 1.4630, -0.3554*t + 0.4275, -0.0227*t + 5.3251, 0.1206*t - 2.5771, -0.0670*t + 0.3703}
+  p.PerezX = [5]float64{-0.0193*t - 0.2592, -0.0665*t + 0.0008, -0.0004*t + 0.2125, -0.0641*t - 0.8989, -0.0033*t + 0.0452}
+  p.PerezY = [5]float64{-0.0167*t - 0.2608, -0.0950*t + 0.0092, -0.0079*t + 0.2102, -0.0441*t - 1.6537, -0.0109*t + 0.0529}
+  // the fit holds for the sun above the horizon; below it the sky stays as
+  // at sunset.
+  ts := math.Acos(math.Max(0, math.Min(1, sun.Elem[1])))
+  chi := (4.0/9 - t/120) * (math.Pi - 2*ts)
+  p.ZenithLum = (4.0453*t - 4.9710)*math.Tan(chi) - 0.2155*t + 2.4192
+  ts2, ts3 := ts*ts, ts*ts*ts
+  p.ZenithX = t*t*(0.00166*ts3 - 0.00375*ts2 + 0.00209*ts) +
+    t*(-0.02903*ts3 + 0.06377*ts2 - 0.03202*ts + 0.00394) +
+    (0.11693*ts3 - 0.21196*ts2 + 0.06052*ts + 0.25886)
+  p.ZenithY = t*t*(0.00275*ts3 - 0.00610*ts2 + 0.00317*ts) +
+    t*(-0.04214*ts3 + 0.08970*ts2 - 0.04153*ts + 0.00516) +
+    (0.15346*ts3 - 0.26756*ts2 + 0.06670*ts + 0.26688)
+  return p
+}
+
+// perez is the Perez distribution for a direction at theta from the zenith
+// and gamma from the sun.
+func perez(c [5]float64, cos_theta, gamma float64) float64 {
+  cos_gamma := math.Cos(gamma)
+  return (1 + c[0]*math.Exp(c[1]/cos_theta)) * (1 + c[2]*math.Exp(c[3]*gamma) + c[4]*cos_gamma*cos_gamma)
+}
+
+func (p Preetham) Radiance(dir glm.Vec3) glm.Vec3 {
+  if dir.Elem[1] <= 0 {
+    return glm.Vec3{}
+  }
+  // stay off the horizon, where the distribution's 1/cos blows up.
+  cos_theta := math.Max(dir.Elem[1], 0.01)
+  gamma := math.Acos(math.Max(-1, math.Min(1, dir.Dot(&p.Sun))))
+  ts := math.Acos(math.Max(0, math.Min(1, p.Sun.Elem[1])))
+  // each quantity is its zenith value scaled by the distribution relative
+  // to the zenith.
+  rel := func(c [5]float64, zenith float64) float64 {
+    return zenith * perez(c, cos_theta, gamma) / perez(c, 1, ts)
+  }
+  Y, x, y := rel(p.PerezLum, p.ZenithLum), rel(p.PerezX, p.ZenithX), rel(p.PerezY, p.ZenithY)
+  // xyY to XYZ, then to linear sRGB.
+  X := x / y * Y
+  Z := (1 - x - y) / y * Y
+  rgb := glm.NewVec3(
+    3.2406*X - 1.5372*Y - 0.4986*Z,
+    -0.9689*X + 1.8758*Y + 0.0415*Z,
+    0.0557*X - 0.2040*Y + 1.0570*Z)
+  for i := range rgb.Elem {
+    rgb.Elem[i] = math.Max(0, rgb.Elem[i]) / DaylightUnit
+  }
+  return *rgb
+}
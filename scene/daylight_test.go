@@ -0,0 +1,116 @@
+package scene
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gray/glm"
+)
+
+// sunAt is the direction to the sun at an angle from the zenith, in degrees,
+// due east.
+func sunAt(deg float64) glm.Vec3 {
+  ts := deg * math.Pi/180
+  return vec(math.Sin(ts), math.Cos(ts), 0)
+}
+
+func nearRel(a, b, tol float64) bool {
+  return math.Abs(a - b) <= tol*math.Abs(b)
+}
+
+// the zenith luminance and chromaticity, worked by hand from appendix A.2
+// of the paper.
+func TestPreethamZenith(t *testing.T) {
+  for _, c := range []struct {
+    turbidity, deg float64
+    lum, x, y float64
+  }{
+    {2, 0, 15.5007, 0.26674, 0.2772},
+    {2, 30, 5.88674, 0.251421, 0.255924},
+    {3, 45, 7.32036, 0.245678, 0.251476},
+    {6, 60, 9.58527, 0.265468, 0.281789},
+    {10, 80, 4.7606, 0.306356, 0.331475},
+  } {
+    p := NewPreetham(Daylight{sunAt(c.deg), c.turbidity})
+    if !nearRel(p.ZenithLum, c.lum, 1e-5) || !nearRel(p.ZenithX, c.x, 1e-5) || !nearRel(p.ZenithY, c.y, 1e-5) {
+      t.Errorf("turbidity %g, sun %g from the zenith: xyY %g %g %g, want %g %g %g", c.turbidity, c.deg, p.ZenithX, p.ZenithY, p.ZenithLum, c.x, c.y, c.lum)
+    }
+  }
+  // once set, the sun leaves the sky as at sunset.
+  set, sunset := NewPreetham(Daylight{vec(1, -0.2, 0), 3}), NewPreetham(Daylight{vec(1, 0, 0), 3})
+  if set.ZenithLum != sunset.ZenithLum || set.ZenithX != sunset.ZenithX {
+    t.Errorf("zenith after sunset %g, at sunset %g", set.ZenithLum, sunset.ZenithLum)
+  }
+}
+
+func TestPreethamRadiance(t *testing.T) {
+  p := NewPreetham(Daylight{sunAt(45), 3})
+  for _, c := range []struct {
+    name string
+    dir, want glm.Vec3
+  }{
+    {"zenith", vec(0, 1, 0), vec(0.154142, 0.246994, 0.47923)},
+    // 60 degrees from the zenith and 150 round from the sun.
+    {"sky", vec(-0.75, 0.5, 0.4330127), vec(0.109795, 0.204974, 0.371678)},
+  } {
+    got := p.Radiance(c.dir)
+    for i := range got.Elem {
+      if !nearRel(got.Elem[i], c.want.Elem[i], 1e-4) {
+        t.Errorf("%s: radiance %v, want %v", c.name, got, c.want)
+        break
+      }
+    }
+  }
+  if got := p.Radiance(vec(0, -0.1, 1)); got != (glm.Vec3{}) {
+    t.Errorf("below the horizon: radiance %v", got)
+  }
+  // the sky is brightest round the sun, and blue overhead on a clear day.
+  near, far := p.Radiance(sunAt(40)), p.Radiance(sunAt(-40))
+  if near.Elem[1] <= far.Elem[1] {
+    t.Errorf("by the sun %v, away from it %v", near, far)
+  }
+  if zenith := p.Radiance(vec(0, 1, 0)); zenith.Elem[2] <= zenith.Elem[0] {
+    t.Errorf("zenith %v is not blue", zenith)
+  }
+}
+
+func TestSunPosition(t *testing.T) {
+  elevation := func(sun glm.Vec3) float64 {
+    return math.Asin(sun.Elem[1]) * 180/math.Pi
+  }
+  // at the March equinox the noon sun stands over the equator, and at the
+  // June solstice 23.44 degrees north of it.
+  if e := elevation(SunPosition(time.Date(2024, 3, 20, 12, 7, 0, 0, time.UTC), 0, 0)); e < 89 {
+    t.Errorf("equinox noon at the equator: elevation %g, want 90", e)
+  }
+  greenwich := SunPosition(time.Date(2024, 6, 20, 12, 2, 0, 0, time.UTC), 51.48, 0)
+  if e := elevation(greenwich); math.Abs(e - 61.96) > 0.3 {
+    t.Errorf("solstice noon at Greenwich: elevation %g, want 61.96", e)
+  }
+  if greenwich.Elem[2] <= 0 || math.Abs(greenwich.Elem[0]) > 0.02 {
+    t.Errorf("solstice noon at Greenwich: sun %v is not due south", greenwich)
+  }
+  // 1pm in London is 8am by the sun in New York.
+  morning := SunPosition(time.Date(2024, 6, 20, 13, 0, 0, 0, time.UTC), 40.7, -74)
+  if morning.Elem[0] <= 0 {
+    t.Errorf("morning sun %v is not in the east", morning)
+  }
+  if e := elevation(SunPosition(time.Date(2024, 6, 20, 0, 0, 0, 0, time.UTC), 51.48, 0)); e > 0 {
+    t.Errorf("midnight at Greenwich: elevation %g", e)
+  }
+}
+
+func TestSunLight(t *testing.T) {
+  high, ok := Daylight{sunAt(20), 3}.SunLight()
+  if !ok {
+    t.Fatal("no sun at 20 degrees from the zenith")
+  }
+  low, _ := Daylight{sunAt(85), 3}.SunLight()
+  if low.Colour.Elem[1] >= high.Colour.Elem[1] || low.Colour.Elem[2]/low.Colour.Elem[0] >= high.Colour.Elem[2]/high.Colour.Elem[0] {
+    t.Errorf("low sun %v is not dimmer and redder than high sun %v", low.Colour, high.Colour)
+  }
+  if _, ok := (Daylight{vec(1, -0.1, 0), 3}).SunLight(); ok {
+    t.Error("a sun below the horizon lights the scene")
+  }
+}
@@ -0,0 +1,124 @@
+package scene
+
+import (
+	"fmt"
+
+	"gray/glm"
+)
+
+// DISPLACEMENT
+
+// A Displacement gives how far to move a surface point along its normal.
+type Displacement interface {
+  Displace(point, normal glm.Vec3) float64
+}
+
+// A DisplaceFunc lets a plain function be used as a Displacement.
+type DisplaceFunc func(point, normal glm.Vec3) float64
+
+func (f DisplaceFunc) Displace(point, normal glm.Vec3) float64 {
+  return f(point, normal)
+}
+
+// A DisplacementMap reads offsets from the red channel of a texture, which
+// should be loaded with srgb=false. The texture is projected along Axis and
+// repeats every Size units from Origin; full red moves Height units.
+type DisplacementMap struct {
+  Texture *Texture
+  Origin glm.Vec3
+  Size, Height float64
+  Axis int
+}
+
+func (d DisplacementMap) Displace(point, normal glm.Vec3) float64 {
+  a, b := (d.Axis + 1)%3, (d.Axis + 2)%3
+  u := (point.Elem[a] - d.Origin.Elem[a]) / d.Size
+  v := (point.Elem[b] - d.Origin.Elem[b]) / d.Size
+  return d.Height * d.Texture.Lookup(u, v).Elem[0]
+}
+
+// Displace splits faces until no edge is longer than edge, then moves every
+// vertex along its smoothed normal by d. The result is built with NewMesh, so
+// its bounds cover the displaced surface. edge must be positive.
+func (p Mesh) Displace(edge float64, d Displacement) (*Mesh, error) {
+  // no edge is ever shorter than a target of zero or NaN.
+  if !(edge > 0) {
+    return nil, fmt.Errorf("displacement edge length %g is not positive", edge)
+  }
+  verts := append([]glm.Vec3{}, p.Verts...)
+  faces := p.Faces
+  for split := true; split; {
+    verts, faces, split = refine(verts, faces, edge)
+  }
+
+  normals := make([]glm.Vec3, len(verts))
+  for _, f := range faces {
+    e1 := verts[f[1]].Subtract(&verts[f[0]])
+    e2 := verts[f[2]].Subtract(&verts[f[0]])
+    n := e1.Cross(e2)
+    for _, v := range f {
+      normals[v].Iadd(n)
+    }
+  }
+  out := make([][3]float64, len(verts))
+  for i, v := range verts {
+    n := glm.Vec3{}
+    if normals[i].Dot(&normals[i]) > 0 {
+      n = unit(normals[i])
+    }
+    out[i] = v.Add(n.Scale(d.Displace(v, n))).Elem
+  }
+  mesh := NewMesh(out, faces, p.Mat)
+  mesh.smooth(nil)
+  return mesh, nil
+}
+
+// refine halves every edge longer than edge. Faces sharing an edge split it
+// at the same new vertex, so no cracks open up. It reports whether anything
+// was split.
+func refine(verts []glm.Vec3, faces [][3]int, edge float64) ([]glm.Vec3, [][3]int, bool) {
+  mid := map[[2]int]int{}
+  for _, f := range faces {
+    for k := 0; k < 3; k++ {
+      e := edgeKey(f[k], f[(k+1)%3])
+      if _, ok := mid[e]; !ok && verts[e[0]].Subtract(&verts[e[1]]).Length() > edge {
+        mid[e] = len(verts)
+        verts = append(verts, *verts[e[0]].Add(&verts[e[1]]).Scale(0.5))
+      }
+    }
+  }
+  if len(mid) == 0 {
+    return verts, faces, false
+  }
+  out := make([][3]int, 0, 4*len(faces))
+  for _, f := range faces {
+    // rotate the face so its split edges come first.
+    m := [3]int{-1, -1, -1}
+    split := 0
+    for k := 0; k < 3; k++ {
+      if i, ok := mid[edgeKey(f[k], f[(k+1)%3])]; ok {
+        m[k] = i
+        split++
+      }
+    }
+    r := 0
+    for r < 3 && !(m[r] >= 0 && (split == 3 || m[(r+2)%3] < 0)) {
+      r++
+    }
+    if r == 3 {
+      out = append(out, f)
+      continue
+    }
+    a, b, c := f[r], f[(r+1)%3], f[(r+2)%3]
+    ab, bc, ca := m[r], m[(r+1)%3], m[(r+2)%3]
+    switch {
+      case split == 3:
+        out = append(out, [3]int{a, ab, ca}, [3]int{ab, b, bc}, [3]int{ca, bc, c}, [3]int{ab, bc, ca})
+      case bc >= 0:
+        out = append(out, [3]int{a, ab, c}, [3]int{ab, b, bc}, [3]int{ab, bc, c})
+      default:
+        out = append(out, [3]int{a, ab, c}, [3]int{ab, b, c})
+    }
+  }
+  return verts, out, true
+}
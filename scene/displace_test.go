@@ -0,0 +1,34 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+func TestDisplaceEdge(t *testing.T) {
+  square := NewMesh([][3]float64{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}, [][3]int{{0, 1, 2}, {0, 2, 3}}, Material{})
+  lift := DisplaceFunc(func(point, normal glm.Vec3) float64 { return 0.1 })
+  for _, edge := range []float64{0, -1, math.NaN()} {
+    if _, err := square.Displace(edge, lift); err == nil {
+      t.Errorf("edge %g: no error", edge)
+    }
+  }
+  m, err := square.Displace(0.3, lift)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, f := range m.Faces {
+    for k := 0; k < 3; k++ {
+      if l := m.Verts[f[k]].Subtract(&m.Verts[f[(k+1)%3]]).Length(); l > 0.3 {
+        t.Errorf("edge of length %g left", l)
+      }
+    }
+  }
+  for _, v := range m.Verts {
+    if !near(math.Abs(v.Elem[2]), 0.1) {
+      t.Errorf("vertex %v not moved 0.1 off the square", v)
+    }
+  }
+}
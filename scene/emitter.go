@@ -0,0 +1,181 @@
+package scene
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gray/glm"
+)
+
+// AREA LIGHTS
+
+// An Emitter is a light with extent, which lights a point from samples on
+// its surface. Samples are drawn from uniform numbers u1, u2 in [0,1), so
+// that any integrator can drive it with its own random numbers.
+type Emitter interface {
+  // Sample picks a point on the emitter to light from with, returning the
+  // hit there and the probability density of the choice per unit solid
+  // angle seen from from. A zero density means the point gives no light.
+  Sample(from glm.Vec3, u1, u2 float64) (Hit, float64)
+  // Radiance is the light given off, the same from every point and in
+  // every direction.
+  Radiance() glm.Vec3
+}
+
+// An AreaLight is an emissive primitive, by its index in the scene's
+// Primitives, with the emitter that samples it.
+type AreaLight struct {
+  Node int
+  Emitter Emitter
+}
+
+// AreaLights finds the emissive spheres and meshes among prims. Other
+// emissive primitives glow where they are seen but light nothing.
+func AreaLights(prims []Primitive, sampling SphereSampling) []AreaLight {
+  lights := []AreaLight{}
+  for i, p := range prims {
+    if e := p.GetMaterial().Emission; e.Dot(&e) == 0 {
+      continue
+    }
+    switch p := p.(type) {
+      case Sphere:
+        lights = append(lights, AreaLight{i, SphereEmitter{p, sampling}})
+      case Mesh:
+        if len(p.Faces) > 0 {
+          lights = append(lights, AreaLight{i, NewMeshEmitter(p)})
+        }
+      case *Mesh:
+        if len(p.Faces) > 0 {
+          lights = append(lights, AreaLight{i, NewMeshEmitter(*p)})
+        }
+    }
+  }
+  return lights
+}
+
+// solidAngle converts a density per unit area at h, over a light of the
+// given area, to one per unit solid angle seen from from.
+func solidAngle(from glm.Vec3, h Hit, area float64, two_sided bool) float64 {
+  w := h.Point.Subtract(&from)
+  dist2 := w.Dot(w)
+  n := unit(h.Normal)
+  cos := -n.Dot(w) / math.Sqrt(dist2)
+  if two_sided {
+    cos = math.Abs(cos)
+  }
+  if cos <= 0 {
+    return 0
+  }
+  return dist2 / (area * cos)
+}
+
+// SphereSampling chooses how points on spherical lights are picked.
+type SphereSampling int
+
+const (
+  // SolidAngle picks directions within the cone the sphere fills, so every
+  // sample lands on the side facing the point lit.
+  SolidAngle SphereSampling = iota
+  // UniformArea picks points evenly over the whole sphere.
+  UniformArea
+)
+
+var sphereSamplingNames = [...]string{"solid-angle", "area"}
+
+func (s SphereSampling) String() string {
+  if s < 0 || int(s) >= len(sphereSamplingNames) {
+    return fmt.Sprintf("SphereSampling(%d)", int(s))
+  }
+  return sphereSamplingNames[s]
+}
+
+func ParseSphereSampling(name string) (SphereSampling, error) {
+  for i, n := range sphereSamplingNames {
+    if n == name {
+      return SphereSampling(i), nil
+    }
+  }
+  return 0, fmt.Errorf("unknown sphere sampling %q", name)
+}
+
+// A SphereEmitter lights from the outside of a sphere.
+type SphereEmitter struct {
+  Sphere Sphere
+  Sampling SphereSampling
+}
+
+func (e SphereEmitter) Radiance() glm.Vec3 {
+  return e.Sphere.Mat.Emission
+}
+
+func (e SphereEmitter) Sample(from glm.Vec3, u1, u2 float64) (Hit, float64) {
+  s := e.Sphere
+  axis := *s.Pos.Subtract(&from)
+  dist2 := axis.Dot(&axis)
+  // points inside see the whole sphere, so have no cone to sample.
+  if e.Sampling == UniformArea || dist2 <= s.Rad*s.Rad {
+    z := 1 - 2*u1
+    r := math.Sqrt(math.Max(0, 1 - z*z))
+    phi := 2*math.Pi*u2
+    n := glm.NewVec3(r*math.Cos(phi), r*math.Sin(phi), z)
+    w := s.Pos.Add(n.Scale(s.Rad)).Subtract(&from)
+    h := s.HitPoint(*w, from, 1, glm.Vec3{})
+    return h, solidAngle(from, h, 4*math.Pi*s.Rad*s.Rad, false)
+  }
+  dist := math.Sqrt(dist2)
+  axis.Iscale(1 / dist)
+  // 1 - cos of the cone's half angle, without cancellation for far spheres.
+  sin2 := s.Rad*s.Rad / dist2
+  spread := sin2 / (1 + math.Sqrt(1 - sin2))
+  cos := 1 - u1*spread
+  sin := math.Sqrt(math.Max(0, 1 - cos*cos))
+  phi := 2*math.Pi*u2
+  t, b := orthonormal(axis)
+  w := t.Scale(sin*math.Cos(phi))
+  w.Iadd(b.Scale(sin*math.Sin(phi))).Iadd(axis.Scale(cos))
+  // the near side of the sphere along w.
+  raylen := dist*cos - math.Sqrt(math.Max(0, s.Rad*s.Rad - dist2*sin*sin))
+  return s.HitPoint(*w, from, raylen, glm.Vec3{}), 1 / (2*math.Pi*spread)
+}
+
+// A MeshEmitter lights from a mesh's faces, picked in proportion to their
+// area. Meshes light from both sides, as loaded meshes are not always wound
+// consistently.
+type MeshEmitter struct {
+  Mesh Mesh
+  // the running total of face areas.
+  Areas []float64
+}
+
+func NewMeshEmitter(m Mesh) *MeshEmitter {
+  e := &MeshEmitter{Mesh: m, Areas: make([]float64, len(m.Faces))}
+  total := 0.0
+  for i := range m.Faces {
+    total += m.Normals[i].Length() / 2
+    e.Areas[i] = total
+  }
+  return e
+}
+
+func (e *MeshEmitter) Radiance() glm.Vec3 {
+  return e.Mesh.Mat.Emission
+}
+
+func (e *MeshEmitter) Sample(from glm.Vec3, u1, u2 float64) (Hit, float64) {
+  total := e.Areas[len(e.Areas)-1]
+  i := min(sort.SearchFloat64s(e.Areas, u1*total), len(e.Areas) - 1)
+  lo := 0.0
+  if i > 0 {
+    lo = e.Areas[i-1]
+  }
+  // reuse u1 within the face chosen.
+  if e.Areas[i] > lo {
+    u1 = (u1*total - lo) / (e.Areas[i] - lo)
+  }
+  su := math.Sqrt(math.Min(u1, 1))
+  f := e.Mesh.Faces[i]
+  v := [3]glm.Vec3{e.Mesh.Verts[f[0]], e.Mesh.Verts[f[1]], e.Mesh.Verts[f[2]]}
+  h := weighted(v, [3]float64{1 - su, u2*su, (1 - u2)*su})
+  return h, solidAngle(from, h, total, true)
+}
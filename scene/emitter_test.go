@@ -0,0 +1,89 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+// solidAngleOf estimates the solid angle e fills seen from from, as the mean
+// of 1/pdf over a grid of n by n samples. Samples that give no light count
+// nothing. Each must lie on the emitter, as onEmitter says.
+func solidAngleOf(t *testing.T, name string, e Emitter, from glm.Vec3, n int, onEmitter func(p glm.Vec3) bool) float64 {
+  sum := 0.0
+  for i := 0; i < n; i++ {
+    for j := 0; j < n; j++ {
+      h, pdf := e.Sample(from, (float64(i) + 0.5)/float64(n), (float64(j) + 0.5)/float64(n))
+      if !onEmitter(h.Point) {
+        t.Fatalf("%s: sample %v is not on the emitter", name, h.Point)
+      }
+      if pdf > 0 {
+        sum += 1 / pdf
+      }
+    }
+  }
+  return sum / float64(n*n)
+}
+
+func TestSphereEmitter(t *testing.T) {
+  s := Sphere{vec(1, 2, 3), 0.5, Material{Emission: vec(2, 2, 2)}}
+  on := func(p glm.Vec3) bool {
+    return math.Abs(p.Subtract(&s.Pos).Length() - s.Rad) < 1e-9
+  }
+  for _, d := range []float64{0.75, 2, 50} {
+    from := *s.Pos.Add(glm.NewVec3(0, 0, d))
+    want := 2*math.Pi*(1 - math.Sqrt(1 - s.Rad*s.Rad/(d*d)))
+    for _, c := range []struct {
+      sampling SphereSampling
+      tol float64
+    }{{SolidAngle, 1e-9}, {UniformArea, 1e-3}} {
+      e := SphereEmitter{s, c.sampling}
+      if got := solidAngleOf(t, c.sampling.String(), e, from, 512, on); math.Abs(got - want) > c.tol*want {
+        t.Errorf("%v sampling from %g away: solid angle %g, want %g", c.sampling, d, got, want)
+      }
+    }
+  }
+  // from inside, the outside of the sphere faces away.
+  if got := solidAngleOf(t, "inside", SphereEmitter{s, SolidAngle}, s.Pos, 16, on); got != 0 {
+    t.Errorf("from inside: solid angle %g, want 0", got)
+  }
+  if r := (SphereEmitter{s, SolidAngle}).Radiance(); r != s.Mat.Emission {
+    t.Errorf("radiance %v, want %v", r, s.Mat.Emission)
+  }
+}
+
+func TestMeshEmitter(t *testing.T) {
+  // the square from -1 to 1 at z = 0, as a fan of three faces of different
+  // areas.
+  verts := [][3]float64{{-0.5, -1, 0}, {1, -1, 0}, {1, 1, 0}, {-1, 1, 0}, {-1, -1, 0}}
+  m := NewMesh(verts, [][3]int{{0, 1, 2}, {0, 2, 3}, {0, 3, 4}}, Material{})
+  e := NewMeshEmitter(*m)
+  on := func(p glm.Vec3) bool {
+    return p.Elem[2] == 0 && math.Abs(p.Elem[0]) <= 1 && math.Abs(p.Elem[1]) <= 1
+  }
+  for _, d := range []float64{0.5, 3, -3} {
+    // a square of side 2 seen from d along its axis.
+    want := 4*math.Asin(1 / (1 + d*d))
+    if got := solidAngleOf(t, "mesh", e, vec(0, 0, d), 512, on); math.Abs(got - want) > 1e-2*want {
+      t.Errorf("from %g away: solid angle %g, want %g", d, got, want)
+    }
+  }
+  // seen edge on, the square fills no solid angle.
+  if got := solidAngleOf(t, "edge on", e, vec(3, 0, 0), 16, on); got != 0 {
+    t.Errorf("edge on: solid angle %g, want 0", got)
+  }
+}
+
+func TestSphereSamplingString(t *testing.T) {
+  for _, s := range []SphereSampling{SolidAngle, UniformArea} {
+    if p, err := ParseSphereSampling(s.String()); err != nil || p != s {
+      t.Errorf("%v parsed as %v, %v", s, p, err)
+    }
+  }
+  for _, s := range []SphereSampling{-1, UniformArea + 1} {
+    if s.String() == "" {
+      t.Errorf("sampling %d has no name", int(s))
+    }
+  }
+}
@@ -0,0 +1,38 @@
+package scene
+
+import (
+	"encoding/gob"
+)
+
+// Scenes are sent to render workers with gob, which needs to know every
+// concrete type that can sit behind a Primitive, a Field or a Sky.
+func init() {
+  gob.Register(Sphere{})
+  gob.Register(Box{})
+  gob.Register(AABB{})
+  gob.Register(Mesh{})
+  gob.Register(Plane{})
+  gob.Register(Disk{})
+  gob.Register(Triangle{})
+  gob.Register(Cylinder{})
+  gob.Register(Cone{})
+  gob.Register(Torus{})
+  gob.Register(Quartic{})
+  gob.Register(CSG{})
+  gob.Register(SDF{})
+  gob.Register(SDFSphere{})
+  gob.Register(SDFBox{})
+  gob.Register(SDFTorus{})
+  gob.Register(SDFCapsule{})
+  gob.Register(Mandelbulb{})
+  gob.Register(Translate{})
+  gob.Register(Scale{})
+  gob.Register(SmoothUnion{})
+  gob.Register(Repeat{})
+  gob.Register(Twist{})
+  gob.Register(Heightfield{})
+  gob.Register(BezierPatch{})
+  gob.Register(EnvMap{})
+  gob.Register(Gradient{})
+  gob.Register(Preetham{})
+}
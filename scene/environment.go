@@ -0,0 +1,174 @@
+package scene
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+
+	"gray/glm"
+)
+
+// ENVIRONMENT
+
+// A Sky gives the light arriving from each unit direction, from infinitely
+// far away, with y up.
+type Sky interface {
+  Radiance(dir glm.Vec3) glm.Vec3
+}
+
+// An Environment surrounds the scene with a sky, which rays that escape the
+// scene see and which lights it.
+type Environment struct {
+  Sky Sky
+  // turns the sky about the y axis, in degrees.
+  Rotation float64
+  // scales the sky's radiance.
+  Intensity float64
+}
+
+// LoadEnvironment returns the gradient sky for "sky", or else reads an
+// equirectangular image: Radiance HDR, or sRGB PNG or JPEG.
+func LoadEnvironment(name string) (*Environment, error) {
+  if name == "sky" {
+    return &Environment{DefaultGradient, 0, 1}, nil
+  }
+  var t *Texture
+  var err error
+  if strings.ToLower(filepath.Ext(name)) == ".hdr" {
+    t, err = LoadHDR(name)
+  } else {
+    t, err = LoadTexture(name, true)
+  }
+  if err != nil {
+    return nil, err
+  }
+  return &Environment{EnvMap{t}, 0, 1}, nil
+}
+
+// Radiance is the light arriving along the unit direction dir, in the
+// scene's frame.
+func (e Environment) Radiance(dir glm.Vec3) glm.Vec3 {
+  c := e.Sky.Radiance(rotateY(dir, -e.Rotation))
+  return *c.Scale(e.Intensity)
+}
+
+// rotateY turns v about the y axis by degrees, anticlockwise looking down it.
+func rotateY(v glm.Vec3, degrees float64) glm.Vec3 {
+  if degrees == 0 {
+    return v
+  }
+  sin, cos := math.Sincos(degrees * math.Pi / 180)
+  x, z := v.Elem[0], v.Elem[2]
+  return *glm.NewVec3(cos*x + sin*z, v.Elem[1], cos*z - sin*x)
+}
+
+// equirect maps the unit direction dir to u, v in [0,1): u goes once around
+// the horizon starting behind -z, and v from the zenith (0) down to the nadir.
+func equirect(dir glm.Vec3) (u, v float64) {
+  u = 0.5 + math.Atan2(dir.Elem[0], -dir.Elem[2])/(2*math.Pi)
+  v = math.Acos(math.Max(-1, math.Min(1, dir.Elem[1]))) / math.Pi
+  return
+}
+
+// fromEquirect is the inverse of equirect.
+func fromEquirect(u, v float64) glm.Vec3 {
+  sinphi, cosphi := math.Sincos(2*math.Pi*(u - 0.5))
+  sintheta, costheta := math.Sincos(math.Pi*v)
+  return *glm.NewVec3(sintheta*sinphi, costheta, -sintheta*cosphi)
+}
+
+// An EnvMap is a sky from an equirectangular (latitude-longitude) image, with
+// -z at its centre.
+type EnvMap struct {
+  Map *Texture
+}
+
+func (m EnvMap) Radiance(dir glm.Vec3) glm.Vec3 {
+  u, v := equirect(dir)
+  // texels wrap around the horizon but not over the poles.
+  half := 0.5 / float64(m.Map.Height)
+  return m.Map.Lookup(u, math.Max(half, math.Min(1 - half, 1 - v)))
+}
+
+// A Gradient is a sky that blends from Horizon up to Zenith, over flat Ground.
+type Gradient struct {
+  Zenith, Horizon, Ground glm.Vec3
+}
+
+// DefaultGradient is a clear daytime sky, in linear RGB.
+var DefaultGradient = Gradient{
+  *glm.NewVec3(0.15, 0.3, 0.8), *glm.NewVec3(0.8, 0.85, 0.9), *glm.NewVec3(0.2, 0.18, 0.15),
+}
+
+func (g Gradient) Radiance(dir glm.Vec3) glm.Vec3 {
+  y := dir.Elem[1]
+  if y < 0 {
+    return g.Ground
+  }
+  return *g.Horizon.Scale(1 - y).Add(g.Zenith.Scale(y))
+}
+
+// An EnvironmentLight samples directions to light from in proportion to the
+// environment's brightness, from a table over its equirectangular image.
+type EnvironmentLight struct {
+  Env Environment
+  // the density over rows (v), and over u within each row.
+  rows piecewise
+  cols []piecewise
+}
+
+// NewEnvironmentLight tabulates env at the resolution of its image, or a
+// coarser fixed one for skies without an image.
+func NewEnvironmentLight(env Environment) *EnvironmentLight {
+  width, height := 256, 128
+  if m, ok := env.Sky.(EnvMap); ok {
+    width, height = m.Map.Width, m.Map.Height
+  }
+  l := &EnvironmentLight{Env: env, cols: make([]piecewise, height)}
+  lum := make([]float64, width*height)
+  for y := 0; y < height; y++ {
+    v := (float64(y) + 0.5) / float64(height)
+    for x := 0; x < width; x++ {
+      c := env.Sky.Radiance(fromEquirect((float64(x) + 0.5)/float64(width), v))
+      lum[y*width + x] = math.Max(0, Luminance(c))
+    }
+  }
+  rows := make([]float64, height)
+  for y := range l.cols {
+    // rows shrink towards the poles.
+    sin := math.Sin(math.Pi * (float64(y) + 0.5) / float64(height))
+    weights := make([]float64, width)
+    for x := range weights {
+      // filtered lookups blend in the neighbouring texels, so weigh each
+      // by the brightest of them, wrapping around the horizon.
+      for dy := max(y-1, 0); dy <= min(y+1, height-1); dy++ {
+        for dx := x-1; dx <= x+1; dx++ {
+          weights[x] = math.Max(weights[x], lum[dy*width + (dx + width)%width])
+        }
+      }
+      weights[x] *= sin
+    }
+    l.cols[y] = newPiecewise(weights)
+    rows[y] = l.cols[y].total
+  }
+  l.rows = newPiecewise(rows)
+  return l
+}
+
+// Sample picks a unit direction to light from, in the scene's frame, and
+// returns it with the radiance along it and its density per unit solid
+// angle. A zero density means no light.
+func (l *EnvironmentLight) Sample(u1, u2 float64) (glm.Vec3, glm.Vec3, float64) {
+  v, row, pdf_v := l.rows.sample(u1)
+  if pdf_v == 0 {
+    return glm.Vec3{}, glm.Vec3{}, 0
+  }
+  u, _, pdf_u := l.cols[row].sample(u2)
+  sin := math.Sin(math.Pi * v)
+  if sin == 0 {
+    return glm.Vec3{}, glm.Vec3{}, 0
+  }
+  dir := rotateY(fromEquirect(u, v), l.Env.Rotation)
+  // the image spans 2pi by pi radians, and a texel sin(theta) steradians.
+  return dir, l.Env.Radiance(dir), pdf_u * pdf_v / (2*math.Pi*math.Pi*sin)
+}
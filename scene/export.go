@@ -0,0 +1,347 @@
+package scene
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gray/glm"
+)
+
+// OBJ EXPORT
+
+// ExportOptions controls what WriteObj writes besides meshes.
+type ExportOptions struct {
+  // Tessellate turns spheres and boxes into triangles; Detail is the number
+  // of stacks per sphere and the grid size for Bezier patches.
+  Tessellate bool
+  Detail int
+  // Bounds adds a wireframe of each primitive's bounding box and of the
+  // quadtrees of Bezier patches. There is no acceleration structure over
+  // the scene as a whole: rays are tested against every primitive in turn,
+  // and meshes, heightfields, quartics and distance fields try their box
+  // first.
+  Bounds bool
+}
+
+type objWriter struct {
+  w *bufio.Writer
+  mtl *bufio.Writer
+  verts, normals, uvs int
+  mats map[Material]string
+  // textures are written as PNGs named from base.
+  base string
+  textures map[*Texture]string
+  // the first texture that could not be written.
+  err error
+}
+
+// WriteObj writes the mesh, and its material, as OBJ and MTL files.
+func (p Mesh) WriteObj(file string) error {
+  return WriteObj(file, &Scene{Primitives: []Primitive{p}}, ExportOptions{})
+}
+
+// WriteObj writes the scene's geometry as an OBJ file, with its materials in
+// an MTL file alongside. Primitives with no triangle form, such as planes
+// and distance fields, are listed in comments and otherwise skipped.
+func WriteObj(file string, sc *Scene, opts ExportOptions) error {
+  if opts.Detail <= 0 {
+    opts.Detail = 16
+  }
+  base := strings.TrimSuffix(file, filepath.Ext(file))
+  mtlfile := base + ".mtl"
+  out, err := os.Create(file)
+  if err != nil {
+    return err
+  }
+  defer out.Close()
+  mtlout, err := os.Create(mtlfile)
+  if err != nil {
+    return err
+  }
+  defer mtlout.Close()
+
+  o := &objWriter{w: bufio.NewWriter(out), mtl: bufio.NewWriter(mtlout), mats: map[Material]string{},
+    base: base, textures: map[*Texture]string{}}
+  fmt.Fprintf(o.w, "mtllib %s\n", filepath.Base(mtlfile))
+  for i, p := range sc.Primitives {
+    name := fmt.Sprintf("%T_%d", p, i)
+    name = name[strings.LastIndex(name, ".")+1:]
+    if m := tessellate(p, opts); m != nil {
+      o.mesh(name, *m)
+    } else {
+      fmt.Fprintf(o.w, "# skipped %s\n", name)
+    }
+  }
+  if opts.Bounds {
+    o.bounds(sc)
+  }
+  if o.err != nil {
+    return o.err
+  }
+  if err := o.w.Flush(); err != nil {
+    return err
+  }
+  if err := o.mtl.Flush(); err != nil {
+    return err
+  }
+  if err := out.Close(); err != nil {
+    return err
+  }
+  return mtlout.Close()
+}
+
+// tessellate returns p as a triangle mesh, or nil if it has none.
+func tessellate(p Primitive, opts ExportOptions) *Mesh {
+  switch p := p.(type) {
+    case Mesh:
+      return &p
+    case *Mesh:
+      return p
+    case Triangle:
+      return NewMesh([][3]float64{p.Verts[0].Elem, p.Verts[1].Elem, p.Verts[2].Elem}, [][3]int{{0, 1, 2}}, p.Mat)
+    case Heightfield:
+      verts := make([][3]float64, 0, p.Width*p.Depth)
+      faces := [][3]int{}
+      for z := 0; z < p.Depth; z++ {
+        for x := 0; x < p.Width; x++ {
+          verts = append(verts, p.vertex(x, z).Elem)
+        }
+      }
+      for z := 0; z < p.Depth-1; z++ {
+        for x := 0; x < p.Width-1; x++ {
+          a := z*p.Width + x
+          // the same split as cellHit, wound to face up.
+          faces = append(faces, [3]int{a, a+p.Width+1, a+1}, [3]int{a, a+p.Width, a+p.Width+1})
+        }
+      }
+      m := NewMesh(verts, faces, p.Mat)
+      m.VertNormals = make([][3]glm.Vec3, len(faces))
+      for i, f := range faces {
+        for k, v := range f {
+          m.VertNormals[i][k] = p.Normals[v]
+        }
+      }
+      return m
+    case BezierPatch:
+      return p.Tessellate(opts.Detail)
+  }
+  if !opts.Tessellate {
+    return nil
+  }
+  switch p := p.(type) {
+    case Sphere:
+      return sphereMesh(p, opts.Detail)
+    case Box:
+      return boxMesh(p.aabb())
+    case AABB:
+      return boxMesh(p)
+  }
+  return nil
+}
+
+func sphereMesh(s Sphere, stacks int) *Mesh {
+  slices := 2*stacks
+  point := func(theta, phi float64) [3]float64 {
+    n := glm.NewVec3(math.Sin(theta)*math.Cos(phi), math.Cos(theta), -math.Sin(theta)*math.Sin(phi))
+    return s.Pos.Add(n.Scale(s.Rad)).Elem
+  }
+  // one vertex per pole and no repeated seam, so the surface is closed.
+  verts := [][3]float64{point(0, 0), point(math.Pi, 0)}
+  for i := 1; i < stacks; i++ {
+    for j := 0; j < slices; j++ {
+      verts = append(verts, point(math.Pi*float64(i)/float64(stacks), 2*math.Pi*float64(j)/float64(slices)))
+    }
+  }
+  ring := func(i, j int) int {
+    switch i {
+      case 0:
+        return 0
+      case stacks:
+        return 1
+    }
+    return 2 + (i-1)*slices + j%slices
+  }
+  faces := [][3]int{}
+  for i := 0; i < stacks; i++ {
+    for j := 0; j < slices; j++ {
+      a, b := ring(i, j), ring(i+1, j)
+      if i > 0 {
+        faces = append(faces, [3]int{a, b, ring(i, j+1)})
+      }
+      if i < stacks-1 {
+        faces = append(faces, [3]int{ring(i, j+1), b, ring(i+1, j+1)})
+      }
+    }
+  }
+  m := NewMesh(verts, faces, s.Mat)
+  m.VertNormals = make([][3]glm.Vec3, len(faces))
+  for i, f := range faces {
+    for k, v := range f {
+      m.VertNormals[i][k] = unit(*m.Verts[v].Subtract(&s.Pos))
+    }
+  }
+  return m
+}
+
+// boxCorners lists the corners of b, with bit i of the index choosing Max
+// on axis i.
+func boxCorners(b AABB) [8][3]float64 {
+  c := [8][3]float64{}
+  for i := range c {
+    for axis := 0; axis < 3; axis++ {
+      c[i][axis] = b.Min.Elem[axis]
+      if i&(1<<uint(axis)) != 0 {
+        c[i][axis] = b.Max.Elem[axis]
+      }
+    }
+  }
+  return c
+}
+
+func boxMesh(b AABB) *Mesh {
+  c := boxCorners(b)
+  faces := [][3]int{
+    {0, 4, 6}, {0, 6, 2}, {1, 3, 7}, {1, 7, 5}, // -x, +x
+    {0, 1, 5}, {0, 5, 4}, {2, 6, 7}, {2, 7, 3}, // -y, +y
+    {0, 2, 3}, {0, 3, 1}, {4, 5, 7}, {4, 7, 6}, // -z, +z
+  }
+  return NewMesh(c[:], faces, b.Mat)
+}
+
+func (o *objWriter) material(m Material) string {
+  if name, ok := o.mats[m]; ok {
+    return name
+  }
+  name := fmt.Sprintf("mat%d", len(o.mats))
+  o.mats[m] = name
+  srgb := func(v glm.Vec3) string {
+    return fmt.Sprintf("%g %g %g", LinearToSRGB(v.Elem[0]), LinearToSRGB(v.Elem[1]), LinearToSRGB(v.Elem[2]))
+  }
+  illum := 2
+  if m.Mirror > 0 {
+    illum = 3
+  }
+  fmt.Fprintf(o.mtl, "newmtl %s\nKa %s\nKd %s\nKs %s\nNs %g\nillum %d\n",
+    name, srgb(m.Ambient), srgb(m.Diffuse), srgb(m.Specular), m.Shininess, illum)
+  if m.Emission.Dot(&m.Emission) > 0 {
+    // Ke is not in the original MTL spec, but is widely read.
+    fmt.Fprintf(o.mtl, "Ke %g %g %g\n", m.Emission.Elem[0], m.Emission.Elem[1], m.Emission.Elem[2])
+  }
+  if m.Texture != nil {
+    fmt.Fprintf(o.mtl, "map_Kd %s\n", o.texture(m.Texture))
+  }
+  fmt.Fprintln(o.mtl)
+  return name
+}
+
+// texture writes t as an sRGB PNG beside the OBJ file, once, and returns
+// the name the MTL file knows it by.
+func (o *objWriter) texture(t *Texture) string {
+  if name, ok := o.textures[t]; ok {
+    return name
+  }
+  file := fmt.Sprintf("%s_tex%d.png", o.base, len(o.textures))
+  name := filepath.Base(file)
+  o.textures[t] = name
+  img := image.NewRGBA(image.Rect(0, 0, t.Width, t.Height))
+  for y := 0; y < t.Height; y++ {
+    for x := 0; x < t.Width; x++ {
+      c := t.Texels[y*t.Width + x]
+      channel := func(v float64) uint8 {
+        return uint8(math.Round(255 * math.Max(0, math.Min(1, LinearToSRGB(v)))))
+      }
+      img.Set(x, y, color.RGBA{channel(c.Elem[0]), channel(c.Elem[1]), channel(c.Elem[2]), 255})
+    }
+  }
+  out, err := os.Create(file)
+  if err == nil {
+    err = png.Encode(out, img)
+    if cerr := out.Close(); err == nil {
+      err = cerr
+    }
+  }
+  if err != nil && o.err == nil {
+    o.err = err
+  }
+  return name
+}
+
+func (o *objWriter) mesh(name string, m Mesh) {
+  fmt.Fprintf(o.w, "o %s\nusemtl %s\n", name, o.material(m.Mat))
+  for _, v := range m.Verts {
+    fmt.Fprintf(o.w, "v %g %g %g\n", v.Elem[0], v.Elem[1], v.Elem[2])
+  }
+  for _, uv := range m.UVs {
+    fmt.Fprintf(o.w, "vt %g %g\n", uv[0], uv[1])
+  }
+  for _, f := range m.VertNormals {
+    for _, n := range f {
+      fmt.Fprintf(o.w, "vn %g %g %g\n", n.Elem[0], n.Elem[1], n.Elem[2])
+    }
+  }
+  for i, f := range m.Faces {
+    fmt.Fprint(o.w, "f")
+    for k, v := range f {
+      // OBJ indices are 1-based and count from the start of the file.
+      fmt.Fprintf(o.w, " %d", o.verts + v + 1)
+      switch {
+        case m.UVs != nil && m.VertNormals != nil:
+          fmt.Fprintf(o.w, "/%d/%d", o.uvs + v + 1, o.normals + 3*i + k + 1)
+        case m.UVs != nil:
+          fmt.Fprintf(o.w, "/%d", o.uvs + v + 1)
+        case m.VertNormals != nil:
+          fmt.Fprintf(o.w, "//%d", o.normals + 3*i + k + 1)
+      }
+    }
+    fmt.Fprintln(o.w)
+  }
+  o.verts += len(m.Verts)
+  o.uvs += len(m.UVs)
+  o.normals += 3*len(m.VertNormals)
+}
+
+// bounds draws every finite primitive bounding box as twelve lines,
+// including the subdivision boxes of Bezier patches. These are all the boxes
+// there are: the scene has no hierarchy of its own over the primitives.
+func (o *objWriter) bounds(sc *Scene) {
+  boxes := []AABB{}
+  for _, p := range sc.Primitives {
+    if b, ok := p.(Bounded); ok {
+      boxes = append(boxes, b.Bounds())
+    }
+    if patch, ok := p.(BezierPatch); ok {
+      for _, n := range patch.Nodes[1:] {
+        boxes = append(boxes, n.Bound)
+      }
+    }
+  }
+  fmt.Fprintln(o.w, "o bounds")
+  for _, b := range boxes {
+    finite := true
+    for i := 0; i < 3; i++ {
+      finite = finite && !math.IsInf(b.Min.Elem[i], 0) && !math.IsInf(b.Max.Elem[i], 0)
+    }
+    if !finite {
+      continue
+    }
+    for _, c := range boxCorners(b) {
+      fmt.Fprintf(o.w, "v %g %g %g\n", c[0], c[1], c[2])
+    }
+    for i := 0; i < 8; i++ {
+      for axis := 0; axis < 3; axis++ {
+        // each edge joins a corner to its neighbour along a larger axis.
+        if j := i | 1<<uint(axis); j != i {
+          fmt.Fprintf(o.w, "l %d %d\n", o.verts + i + 1, o.verts + j + 1)
+        }
+      }
+    }
+    o.verts += 8
+  }
+}
@@ -0,0 +1,50 @@
+package scene
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gray/glm"
+)
+
+func TestWriteObjTextures(t *testing.T) {
+  tex := &Texture{2, 1, []glm.Vec3{vec(1, 0, 0), vec(0.2, 0.5, 1)}}
+  mat := Material{Diffuse: vec(1, 1, 1), Texture: tex}
+  sc := &Scene{Primitives: []Primitive{
+    Sphere{vec(0, 0, 0), 1, mat},
+    Sphere{vec(3, 0, 0), 1, mat},
+  }}
+  file := filepath.Join(t.TempDir(), "out.obj")
+  if err := WriteObj(file, sc, ExportOptions{Tessellate: true}); err != nil {
+    t.Fatal(err)
+  }
+  mtl, err := os.ReadFile(strings.TrimSuffix(file, ".obj") + ".mtl")
+  if err != nil {
+    t.Fatal(err)
+  }
+  // both spheres share one material and so one texture file.
+  if n := strings.Count(string(mtl), "map_Kd out_tex0.png\n"); n != 1 {
+    t.Fatalf("mtl names the texture %d times:\n%s", n, mtl)
+  }
+  got, err := LoadTexture(filepath.Join(filepath.Dir(file), "out_tex0.png"), true)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got.Width != tex.Width || got.Height != tex.Height {
+    t.Fatalf("texture is %dx%d, want %dx%d", got.Width, got.Height, tex.Width, tex.Height)
+  }
+  for i, c := range got.Texels {
+    d := c.Subtract(&tex.Texels[i])
+    if d.Length() > 0.01 {
+      t.Errorf("texel %d is %v, want %v", i, c, tex.Texels[i])
+    }
+  }
+}
+
+func TestWriteObjBadPath(t *testing.T) {
+  if err := WriteObj(filepath.Join(t.TempDir(), "missing", "out.obj"), &Scene{}, ExportOptions{}); err == nil {
+    t.Fatal("wrote into a missing directory")
+  }
+}
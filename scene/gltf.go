@@ -0,0 +1,641 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gray/glm"
+)
+
+// GLTF LOADER
+
+type gltfTextureRef struct {
+  Index int
+  TexCoord int
+}
+
+type gltfNode struct {
+  Children []int
+  Mesh, Camera *int
+  Matrix []float64
+  Translation, Rotation, Scale []float64
+  Extensions struct {
+    Light *struct {
+      Light int
+    } `json:"KHR_lights_punctual"`
+  }
+}
+
+type gltfPrimitive struct {
+  Attributes map[string]int
+  Indices, Material, Mode *int
+}
+
+type gltfAccessor struct {
+  BufferView *int
+  ByteOffset int
+  ComponentType int
+  Normalized bool
+  Count int
+  Type string
+}
+
+type gltfBufferView struct {
+  Buffer, ByteOffset, ByteLength, ByteStride int
+}
+
+type gltfMaterial struct {
+  PbrMetallicRoughness struct {
+    BaseColorFactor []float64
+    BaseColorTexture *gltfTextureRef
+    MetallicFactor, RoughnessFactor *float64
+  }
+  EmissiveFactor []float64
+  Extensions struct {
+    Strength *struct {
+      EmissiveStrength float64
+    } `json:"KHR_materials_emissive_strength"`
+  }
+}
+
+type gltfCamera struct {
+  Type string
+  Perspective struct {
+    AspectRatio, Yfov float64
+  }
+}
+
+type gltfLight struct {
+  Type string
+  Color []float64
+  Intensity *float64
+}
+
+type gltfDoc struct {
+  Scene *int
+  Scenes []struct {
+    Nodes []int
+  }
+  Nodes []gltfNode
+  Meshes []struct {
+    Primitives []gltfPrimitive
+  }
+  Accessors []gltfAccessor
+  BufferViews []gltfBufferView
+  Buffers []struct {
+    URI string
+    ByteLength int
+  }
+  Materials []gltfMaterial
+  Textures []struct {
+    Source *int
+  }
+  Images []struct {
+    URI string
+    BufferView *int
+  }
+  Cameras []gltfCamera
+  Extensions struct {
+    Lights struct {
+      Lights []gltfLight
+    } `json:"KHR_lights_punctual"`
+  }
+}
+
+// gltfLoader holds a document's decoded buffers and textures while its
+// nodes are walked.
+type gltfLoader struct {
+  file string
+  doc gltfDoc
+  buffers [][]byte
+  textures map[int]*Texture
+  scene *Scene
+  camera bool
+}
+
+// A column-major 4x4 transform, as glTF stores them.
+type gltfMatrix [16]float64
+
+var gltfIdentity = gltfMatrix{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+
+func (a gltfMatrix) mul(b gltfMatrix) (m gltfMatrix) {
+  for c := 0; c < 4; c++ {
+    for r := 0; r < 4; r++ {
+      for k := 0; k < 4; k++ {
+        m[4*c + r] += a[4*k + r] * b[4*c + k]
+      }
+    }
+  }
+  return
+}
+
+// apply transforms a point (w=1) or a direction (w=0).
+func (m gltfMatrix) apply(v [3]float64, w float64) glm.Vec3 {
+  out := glm.Vec3{}
+  for r := 0; r < 3; r++ {
+    out.Elem[r] = m[r]*v[0] + m[4 + r]*v[1] + m[8 + r]*v[2] + m[12 + r]*w
+  }
+  return out
+}
+
+// normal returns the cofactor matrix of the upper 3x3, which maps normals,
+// and the determinant, whose sign says whether winding flips.
+func (m gltfMatrix) normal() (n gltfMatrix, det float64) {
+  at := func(r, c int) float64 { return m[4*c + r] }
+  for r := 0; r < 3; r++ {
+    for c := 0; c < 3; c++ {
+      r1, r2, c1, c2 := (r+1)%3, (r+2)%3, (c+1)%3, (c+2)%3
+      n[4*c + r] = at(r1, c1)*at(r2, c2) - at(r1, c2)*at(r2, c1)
+    }
+  }
+  det = at(0, 0)*n[0] + at(0, 1)*n[4] + at(0, 2)*n[8]
+  return
+}
+
+func (n gltfNode) local() gltfMatrix {
+  if len(n.Matrix) == 16 {
+    m := gltfMatrix{}
+    copy(m[:], n.Matrix)
+    return m
+  }
+  t, s := [3]float64{}, [3]float64{1, 1, 1}
+  q := [4]float64{0, 0, 0, 1}
+  copy(t[:], n.Translation)
+  copy(s[:], n.Scale)
+  copy(q[:], n.Rotation)
+  x, y, z, w := q[0], q[1], q[2], q[3]
+  rot := [3][3]float64{
+    {1 - 2*(y*y + z*z), 2*(x*y + z*w), 2*(x*z - y*w)},
+    {2*(x*y - z*w), 1 - 2*(x*x + z*z), 2*(y*z + x*w)},
+    {2*(x*z + y*w), 2*(y*z - x*w), 1 - 2*(x*x + y*y)},
+  }
+  m := gltfIdentity
+  for c := 0; c < 3; c++ {
+    for r := 0; r < 3; r++ {
+      m[4*c + r] = rot[c][r] * s[c]
+    }
+    m[12 + c] = t[c]
+  }
+  return m
+}
+
+// ReadGLTF reads a .gltf or .glb file into a scene. Node transforms are
+// baked into mesh vertices, each mesh primitive becomes a Mesh, and the
+// first perspective camera sets the view. Punctual lights become point
+// lights with inverse square falloff; directional lights are placed far
+// away. A file with no camera or lights gets a default view or headlight.
+func ReadGLTF(file string) (*Scene, error) {
+  data, err := os.ReadFile(file)
+  if err != nil {
+    return nil, err
+  }
+  l := &gltfLoader{file: file, textures: map[int]*Texture{}, scene: &Scene{}}
+  var bin []byte
+  if bytes.HasPrefix(data, []byte("glTF")) {
+    if data, bin, err = splitGLB(data); err != nil {
+      return nil, fmt.Errorf("%s: %v", file, err)
+    }
+  }
+  if err := json.Unmarshal(data, &l.doc); err != nil {
+    return nil, fmt.Errorf("%s: %v", file, err)
+  }
+  for i, b := range l.doc.Buffers {
+    var buf []byte
+    switch {
+      case b.URI == "" && i == 0 && bin != nil:
+        buf = bin
+      case b.URI == "":
+        return nil, fmt.Errorf("%s: buffer %d has no data", file, i)
+      default:
+        if buf, err = l.readURI(b.URI); err != nil {
+          return nil, fmt.Errorf("%s: buffer %d: %v", file, i, err)
+        }
+    }
+    if len(buf) < b.ByteLength {
+      return nil, fmt.Errorf("%s: buffer %d is %d bytes, want %d", file, i, len(buf), b.ByteLength)
+    }
+    l.buffers = append(l.buffers, buf)
+  }
+
+  roots := []int{}
+  switch {
+    case l.doc.Scene != nil && *l.doc.Scene < len(l.doc.Scenes):
+      roots = l.doc.Scenes[*l.doc.Scene].Nodes
+    case len(l.doc.Scenes) > 0:
+      roots = l.doc.Scenes[0].Nodes
+    default:
+      // without scenes, render every node that is nobody's child.
+      child := map[int]bool{}
+      for _, n := range l.doc.Nodes {
+        for _, c := range n.Children {
+          child[c] = true
+        }
+      }
+      for i := range l.doc.Nodes {
+        if !child[i] {
+          roots = append(roots, i)
+        }
+      }
+  }
+  for _, n := range roots {
+    if err := l.node(n, gltfIdentity, 0); err != nil {
+      return nil, fmt.Errorf("%s: %v", file, err)
+    }
+  }
+  l.defaults()
+  return l.scene, nil
+}
+
+// splitGLB returns the JSON and binary chunks of a binary glTF file.
+func splitGLB(data []byte) (js, bin []byte, err error) {
+  if len(data) < 12 || binary.LittleEndian.Uint32(data[4:8]) != 2 {
+    return nil, nil, fmt.Errorf("unsupported GLB version")
+  }
+  for off := 12; off + 8 <= len(data); {
+    n := int(binary.LittleEndian.Uint32(data[off:]))
+    kind := binary.LittleEndian.Uint32(data[off+4:])
+    if off + 8 + n > len(data) {
+      return nil, nil, fmt.Errorf("truncated GLB chunk")
+    }
+    chunk := data[off+8 : off+8+n]
+    switch kind {
+      case 0x4e4f534a: // JSON
+        js = chunk
+      case 0x004e4942: // BIN
+        bin = chunk
+    }
+    off += 8 + (n+3)/4*4
+  }
+  if js == nil {
+    return nil, nil, fmt.Errorf("GLB has no JSON chunk")
+  }
+  return js, bin, nil
+}
+
+func (l *gltfLoader) readURI(uri string) ([]byte, error) {
+  if strings.HasPrefix(uri, "data:") {
+    comma := strings.IndexByte(uri, ',')
+    if comma < 0 || !strings.HasSuffix(uri[:comma], ";base64") {
+      return nil, fmt.Errorf("unsupported data URI")
+    }
+    return base64.StdEncoding.DecodeString(uri[comma+1:])
+  }
+  path, err := url.PathUnescape(uri)
+  if err != nil {
+    return nil, err
+  }
+  return os.ReadFile(filepath.Join(filepath.Dir(l.file), path))
+}
+
+func (l *gltfLoader) node(i int, parent gltfMatrix, depth int) error {
+  if i < 0 || i >= len(l.doc.Nodes) || depth > len(l.doc.Nodes) {
+    return fmt.Errorf("bad node %d", i)
+  }
+  n := l.doc.Nodes[i]
+  world := parent.mul(n.local())
+  if n.Mesh != nil {
+    if *n.Mesh < 0 || *n.Mesh >= len(l.doc.Meshes) {
+      return fmt.Errorf("node %d: bad mesh %d", i, *n.Mesh)
+    }
+    for j, p := range l.doc.Meshes[*n.Mesh].Primitives {
+      if err := l.primitive(p, world); err != nil {
+        return fmt.Errorf("mesh %d primitive %d: %v", *n.Mesh, j, err)
+      }
+    }
+  }
+  if n.Camera != nil && !l.camera && *n.Camera >= 0 && *n.Camera < len(l.doc.Cameras) {
+    if c := l.doc.Cameras[*n.Camera]; c.Type == "perspective" {
+      l.camera = true
+      l.scene.Eye = world.apply([3]float64{}, 1)
+      l.scene.View = unit(world.apply([3]float64{0, 0, -1}, 0))
+      l.scene.Up = unit(world.apply([3]float64{0, 1, 0}, 0))
+      l.scene.FOV = c.Perspective.Yfov * 180 / math.Pi
+      l.scene.Height = 512
+      l.scene.Width = 512
+      if c.Perspective.AspectRatio > 0 {
+        l.scene.Width = int(math.Round(512 * c.Perspective.AspectRatio))
+      }
+    }
+  }
+  if n.Extensions.Light != nil {
+    lights := l.doc.Extensions.Lights.Lights
+    if n.Extensions.Light.Light < 0 || n.Extensions.Light.Light >= len(lights) {
+      return fmt.Errorf("node %d: bad light %d", i, n.Extensions.Light.Light)
+    }
+    l.light(lights[n.Extensions.Light.Light], world)
+  }
+  for _, c := range n.Children {
+    if err := l.node(c, world, depth+1); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func (l *gltfLoader) light(g gltfLight, world gltfMatrix) {
+  colour := *glm.NewVec3(1, 1, 1)
+  copy(colour.Elem[:], g.Color)
+  if g.Intensity != nil {
+    colour.Iscale(*g.Intensity)
+  }
+  pos := world.apply([3]float64{}, 1)
+  falloff := *glm.NewVec3(0, 0, 1)
+  // spot lights are lit all round, without their cone.
+  if g.Type == "directional" {
+    dir := unit(world.apply([3]float64{0, 0, -1}, 0))
+    pos = *pos.Subtract(dir.Scale(1e6))
+    falloff = *glm.NewVec3(1, 0, 0)
+  }
+  l.scene.Lights = append(l.scene.Lights, Light{pos, colour, falloff, 0})
+}
+
+// MAX_GLTF_ZEROS bounds accessors without a buffer view, which have no data
+// to be checked against.
+const MAX_GLTF_ZEROS = 1 << 24
+
+// accessor reads the elements of accessor i as float64s, n per element.
+func (l *gltfLoader) accessor(i int) ([][]float64, error) {
+  if i < 0 || i >= len(l.doc.Accessors) {
+    return nil, fmt.Errorf("bad accessor %d", i)
+  }
+  a := l.doc.Accessors[i]
+  n := map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4}[a.Type]
+  size := map[int]int{5120: 1, 5121: 1, 5122: 2, 5123: 2, 5125: 4, 5126: 4}[a.ComponentType]
+  if n == 0 || size == 0 {
+    return nil, fmt.Errorf("accessor %d: unsupported %s of type %d", i, a.Type, a.ComponentType)
+  }
+  if a.Count < 0 {
+    return nil, fmt.Errorf("accessor %d: bad count %d", i, a.Count)
+  }
+  if a.BufferView == nil {
+    // all zeros, unless sparse, which is not supported.
+    if a.Count > MAX_GLTF_ZEROS {
+      return nil, fmt.Errorf("accessor %d: bad count %d", i, a.Count)
+    }
+    out := make([][]float64, a.Count)
+    for j := range out {
+      out[j] = make([]float64, n)
+    }
+    return out, nil
+  }
+  if *a.BufferView < 0 || *a.BufferView >= len(l.doc.BufferViews) {
+    return nil, fmt.Errorf("accessor %d: bad buffer view", i)
+  }
+  v := l.doc.BufferViews[*a.BufferView]
+  if v.Buffer < 0 || v.Buffer >= len(l.buffers) {
+    return nil, fmt.Errorf("accessor %d: bad buffer", i)
+  }
+  stride := v.ByteStride
+  if stride == 0 {
+    stride = n * size
+  }
+  if stride < 0 {
+    return nil, fmt.Errorf("accessor %d: bad byte stride %d", i, stride)
+  }
+  // the room after the first element, in both the buffer and the view,
+  // bounds the count without multiplying it out.
+  start := v.ByteOffset + a.ByteOffset
+  buffer := len(l.buffers[v.Buffer]) - start - n*size
+  view := v.ByteLength - a.ByteOffset - n*size
+  if a.Count > 0 && (v.ByteOffset < 0 || a.ByteOffset < 0 || buffer < 0 || view < 0 ||
+      a.Count > buffer/stride + 1 || a.Count > view/stride + 1) {
+    return nil, fmt.Errorf("accessor %d overruns its buffer", i)
+  }
+  out := make([][]float64, a.Count)
+  data := l.buffers[v.Buffer][start:]
+  for j := range out {
+    out[j] = make([]float64, n)
+    for k := range out[j] {
+      b := data[j*stride + k*size:]
+      var x, scale float64
+      switch a.ComponentType {
+        case 5120:
+          x, scale = float64(int8(b[0])), 127
+        case 5121:
+          x, scale = float64(b[0]), 255
+        case 5122:
+          x, scale = float64(int16(binary.LittleEndian.Uint16(b))), 32767
+        case 5123:
+          x, scale = float64(binary.LittleEndian.Uint16(b)), 65535
+        case 5125:
+          x, scale = float64(binary.LittleEndian.Uint32(b)), 1
+        case 5126:
+          x, scale = float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 1
+      }
+      if a.Normalized {
+        x = math.Max(x/scale, -1)
+      }
+      out[j][k] = x
+    }
+  }
+  return out, nil
+}
+
+func (l *gltfLoader) primitive(p gltfPrimitive, world gltfMatrix) error {
+  // only triangle lists; points and lines have no surface.
+  if p.Mode != nil && *p.Mode != 4 {
+    return nil
+  }
+  pos, ok := p.Attributes["POSITION"]
+  if !ok {
+    return fmt.Errorf("no POSITION attribute")
+  }
+  positions, err := l.accessor(pos)
+  if err != nil {
+    return err
+  }
+  if len(positions) == 0 {
+    return nil
+  }
+  normalm, det := world.normal()
+  verts := make([][3]float64, len(positions))
+  for i, v := range positions {
+    verts[i] = world.apply([3]float64{v[0], v[1], v[2]}, 1).Elem
+  }
+
+  indices := [][]float64{}
+  if p.Indices != nil {
+    if indices, err = l.accessor(*p.Indices); err != nil {
+      return err
+    }
+  } else {
+    for i := range positions {
+      indices = append(indices, []float64{float64(i)})
+    }
+  }
+  faces := make([][3]int, 0, len(indices)/3)
+  for i := 0; i + 2 < len(indices); i += 3 {
+    f := [3]int{int(indices[i][0]), int(indices[i+1][0]), int(indices[i+2][0])}
+    for _, v := range f {
+      if v < 0 || v >= len(verts) {
+        return fmt.Errorf("index %d out of range", v)
+      }
+    }
+    // mirroring transforms turn the winding inside out.
+    if det < 0 {
+      f[1], f[2] = f[2], f[1]
+    }
+    faces = append(faces, f)
+  }
+  if len(faces) == 0 {
+    return nil
+  }
+  mat, texcoord, err := l.material(p.Material)
+  if err != nil {
+    return err
+  }
+  m := NewMesh(verts, faces, mat)
+
+  if i, ok := p.Attributes["NORMAL"]; ok {
+    normals, err := l.accessor(i)
+    if err != nil {
+      return err
+    }
+    if len(normals) != len(verts) {
+      return fmt.Errorf("%d normals for %d vertices", len(normals), len(verts))
+    }
+    sign := math.Copysign(1, det)
+    m.VertNormals = make([][3]glm.Vec3, len(faces))
+    for j, f := range faces {
+      for k, v := range f {
+        n := normalm.apply([3]float64{normals[v][0], normals[v][1], normals[v][2]}, 0)
+        m.VertNormals[j][k] = unit(*n.Scale(sign))
+      }
+    }
+  }
+  if i, ok := p.Attributes[fmt.Sprintf("TEXCOORD_%d", texcoord)]; ok {
+    uvs, err := l.accessor(i)
+    if err != nil {
+      return err
+    }
+    if len(uvs) != len(verts) {
+      return fmt.Errorf("%d texture coordinates for %d vertices", len(uvs), len(verts))
+    }
+    m.UVs = make([][2]float64, len(uvs))
+    for j, uv := range uvs {
+      // glTF puts v=0 at the top of the image.
+      m.UVs[j] = [2]float64{uv[0], 1 - uv[1]}
+    }
+  }
+  l.scene.Primitives = append(l.scene.Primitives, *m)
+  return nil
+}
+
+// material maps a metallic-roughness material onto the Phong model: metals
+// lose their diffuse colour and tint their specular and mirror terms, and
+// rougher surfaces get broader highlights. The emissive factor, scaled by
+// any emissive strength, becomes the emission. It also returns which
+// texture coordinate set the base colour texture uses.
+func (l *gltfLoader) material(i *int) (Material, int, error) {
+  base := [4]float64{1, 1, 1, 1}
+  metal, rough := 1.0, 1.0
+  emission := glm.Vec3{}
+  var tex *gltfTextureRef
+  if i != nil {
+    if *i < 0 || *i >= len(l.doc.Materials) {
+      return Material{}, 0, fmt.Errorf("bad material %d", *i)
+    }
+    pbr := l.doc.Materials[*i].PbrMetallicRoughness
+    copy(base[:], pbr.BaseColorFactor)
+    if pbr.MetallicFactor != nil {
+      metal = *pbr.MetallicFactor
+    }
+    if pbr.RoughnessFactor != nil {
+      rough = *pbr.RoughnessFactor
+    }
+    tex = pbr.BaseColorTexture
+    m := l.doc.Materials[*i]
+    copy(emission.Elem[:], m.EmissiveFactor)
+    if m.Extensions.Strength != nil {
+      emission.Iscale(m.Extensions.Strength.EmissiveStrength)
+    }
+  }
+  colour := *glm.NewVec3(base[0], base[1], base[2])
+  specular := *glm.NewVec3(0.04, 0.04, 0.04)
+  specular.Iscale(1 - metal).Iadd(colour.Scale(metal))
+  alpha := math.Max(rough*rough, 0.01)
+  mat := Material{
+    colour, *colour.Scale(1 - metal), specular, math.Max(2/(alpha*alpha) - 2, 1), metal * (1 - rough), rough, emission, nil, nil,
+  }
+  if tex == nil {
+    return mat, 0, nil
+  }
+  t, err := l.texture(tex.Index)
+  if err != nil {
+    return mat, 0, err
+  }
+  mat.Texture = t
+  return mat, tex.TexCoord, nil
+}
+
+func (l *gltfLoader) texture(i int) (*Texture, error) {
+  if t, ok := l.textures[i]; ok {
+    return t, nil
+  }
+  if i < 0 || i >= len(l.doc.Textures) || l.doc.Textures[i].Source == nil {
+    return nil, fmt.Errorf("bad texture %d", i)
+  }
+  src := *l.doc.Textures[i].Source
+  if src < 0 || src >= len(l.doc.Images) {
+    return nil, fmt.Errorf("texture %d: bad image %d", i, src)
+  }
+  im := l.doc.Images[src]
+  var data []byte
+  var err error
+  if im.BufferView != nil {
+    if *im.BufferView < 0 || *im.BufferView >= len(l.doc.BufferViews) {
+      return nil, fmt.Errorf("image %d: bad buffer view", src)
+    }
+    v := l.doc.BufferViews[*im.BufferView]
+    if v.Buffer < 0 || v.Buffer >= len(l.buffers) || v.ByteOffset + v.ByteLength > len(l.buffers[v.Buffer]) {
+      return nil, fmt.Errorf("image %d overruns its buffer", src)
+    }
+    data = l.buffers[v.Buffer][v.ByteOffset : v.ByteOffset+v.ByteLength]
+  } else if data, err = l.readURI(im.URI); err != nil {
+    return nil, fmt.Errorf("image %d: %v", src, err)
+  }
+  img, _, err := image.Decode(bytes.NewReader(data))
+  if err != nil {
+    return nil, fmt.Errorf("image %d: %v", src, err)
+  }
+  l.textures[i] = NewTexture(img, true)
+  return l.textures[i], nil
+}
+
+// defaults frames the scene and lights it when the file does not.
+func (l *gltfLoader) defaults() {
+  sc := l.scene
+  sc.Ambient = *glm.NewVec3(0.05, 0.05, 0.05)
+  if !l.camera {
+    bound, ok := AABB{}, false
+    for _, p := range sc.Primitives {
+      if b, isb := p.(Bounded); isb {
+        if !ok {
+          bound, ok = b.Bounds(), true
+        }
+        bound = bound.Union(b.Bounds())
+      }
+    }
+    centre := bound.Min.Add(&bound.Max).Scale(0.5)
+    radius := math.Max(bound.Max.Subtract(&bound.Min).Length()/2, Epsilon)
+    sc.FOV = 45
+    // back off until the bounding sphere fills the view.
+    dist := radius / math.Sin(sc.FOV/2*math.Pi/180)
+    sc.Eye = *centre.Add(glm.NewVec3(0, 0, dist))
+    sc.View = *glm.NewVec3(0, 0, -1)
+    sc.Up = *glm.NewVec3(0, 1, 0)
+    sc.Width, sc.Height = 512, 512
+  }
+  if len(sc.Lights) == 0 {
+    sc.Lights = []Light{Light{sc.Eye, *glm.NewVec3(1, 1, 1), *glm.NewVec3(1, 0, 0), 0}}
+  }
+}
@@ -0,0 +1,44 @@
+package scene
+
+import (
+	"strings"
+	"testing"
+)
+
+// a single triangle, its corners as floats in a data URI.
+const gltfTriangle = `{
+  "asset": {"version": "2.0"},
+  "scene": 0,
+  "scenes": [{"nodes": [0]}],
+  "nodes": [{"mesh": 0}],
+  "meshes": [{"primitives": [{"attributes": {"POSITION": 0}}]}],
+  "accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}],
+  "bufferViews": [{"buffer": 0, "byteLength": 36, "byteStride": 12}],
+  "buffers": [{"byteLength": 36, "uri": "data:application/octet-stream;base64,AAAAAAAAAAAAAAAAAACAPwAAAAAAAAAAAAAAAAAAgD8AAAAA"}]
+}`
+
+func TestReadGLTF(t *testing.T) {
+  sc, err := ReadGLTF(writeTemp(t, "tri.gltf", gltfTriangle))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(sc.Primitives) != 1 {
+    t.Errorf("read %d primitives, want 1", len(sc.Primitives))
+  }
+  for _, c := range []struct {
+    name, from, to string
+  }{
+    {"negative count", `"count": 3`, `"count": -3`},
+    {"negative stride", `"byteStride": 12`, `"byteStride": -12`},
+    {"overrun", `"count": 3`, `"count": 4`},
+    {"huge count", `"count": 3`, `"count": 100000000000000`},
+    {"overflowing count", `"count": 3`, `"count": 768614336404564651`},
+    {"offset past the buffer", `"bufferView": 0,`, `"bufferView": 0, "byteOffset": 40,`},
+    {"negative offset", `"bufferView": 0,`, `"bufferView": 0, "byteOffset": -12,`},
+  } {
+    data := strings.Replace(gltfTriangle, c.from, c.to, 1)
+    if _, err := ReadGLTF(writeTemp(t, "bad.gltf", data)); err == nil {
+      t.Errorf("%s: no error", c.name)
+    }
+  }
+}
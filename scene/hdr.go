@@ -0,0 +1,127 @@
+package scene
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"gray/glm"
+)
+
+// RADIANCE HDR
+
+// MAX_HDR_PIXELS bounds the images read, so a corrupt size fails cleanly
+// rather than asking for all of memory.
+const MAX_HDR_PIXELS = 1 << 28
+
+// LoadHDR reads a Radiance RGBE (.hdr) image, which holds linear RGB with a
+// shared exponent and so needs no conversion.
+func LoadHDR(file string) (*Texture, error) {
+  infile, err := os.Open(file)
+  if err != nil {
+    return nil, err
+  }
+  defer infile.Close()
+  t, err := readHDR(bufio.NewReader(infile))
+  if err != nil {
+    return nil, fmt.Errorf("%s: %v", file, err)
+  }
+  return t, nil
+}
+
+func readHDR(r *bufio.Reader) (*Texture, error) {
+  magic, err := r.ReadString('\n')
+  if err != nil || !strings.HasPrefix(magic, "#?") {
+    return nil, fmt.Errorf("not a Radiance HDR file")
+  }
+  // header lines run up to a blank line.
+  for {
+    line, err := r.ReadString('\n')
+    if err != nil {
+      return nil, err
+    }
+    line = strings.TrimSpace(line)
+    if line == "" {
+      break
+    }
+    if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+      return nil, fmt.Errorf("unsupported format %s", line[7:])
+    }
+  }
+  res, err := r.ReadString('\n')
+  if err != nil {
+    return nil, err
+  }
+  t := &Texture{}
+  // only the usual top to bottom, left to right orientation.
+  if _, err := fmt.Sscanf(res, "-Y %d +X %d", &t.Height, &t.Width); err != nil {
+    return nil, fmt.Errorf("unsupported resolution %q", strings.TrimSpace(res))
+  }
+  if t.Width <= 0 || t.Height <= 0 || t.Width > MAX_HDR_PIXELS/t.Height {
+    return nil, fmt.Errorf("bad resolution %dx%d", t.Width, t.Height)
+  }
+  t.Texels = make([]glm.Vec3, t.Width*t.Height)
+  line := make([]byte, 4*t.Width)
+  for y := 0; y < t.Height; y++ {
+    if err := readScanline(r, line); err != nil {
+      return nil, err
+    }
+    for x := 0; x < t.Width; x++ {
+      rgbe := line[4*x : 4*x+4]
+      if rgbe[3] == 0 {
+        continue
+      }
+      f := math.Ldexp(1, int(rgbe[3]) - (128 + 8))
+      t.Texels[y*t.Width + x] = *glm.NewVec3((float64(rgbe[0]) + 0.5)*f, (float64(rgbe[1]) + 0.5)*f, (float64(rgbe[2]) + 0.5)*f)
+    }
+  }
+  return t, nil
+}
+
+// readScanline fills line with one row of RGBE pixels, either stored flat
+// or run length encoded one component at a time.
+func readScanline(r *bufio.Reader, line []byte) error {
+  width := len(line) / 4
+  start, err := r.Peek(4)
+  if err != nil {
+    return err
+  }
+  if width < 8 || width > 0x7fff || start[0] != 2 || start[1] != 2 || start[2]&0x80 != 0 {
+    _, err := io.ReadFull(r, line)
+    return err
+  }
+  if int(start[2])<<8 | int(start[3]) != width {
+    return fmt.Errorf("bad scanline width")
+  }
+  r.Discard(4)
+  for c := 0; c < 4; c++ {
+    for x := 0; x < width; {
+      n, err := r.ReadByte()
+      if err != nil {
+        return err
+      }
+      // counts over 128 repeat the next byte, others are literal bytes.
+      run := n > 128
+      if run {
+        n -= 128
+      }
+      if n == 0 || x + int(n) > width {
+        return fmt.Errorf("bad scanline run")
+      }
+      var b byte
+      for i := 0; i < int(n); i++ {
+        if !run || i == 0 {
+          if b, err = r.ReadByte(); err != nil {
+            return err
+          }
+        }
+        line[4*x + c] = b
+        x++
+      }
+    }
+  }
+  return nil
+}
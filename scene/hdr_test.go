@@ -0,0 +1,31 @@
+package scene
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadHDR(t *testing.T) {
+  header := "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n"
+  // two flat pixels: 1,0.5,0 and 0,0,0.
+  tex, err := readHDR(bufio.NewReader(strings.NewReader(header + "-Y 1 +X 2\n" + "\x80\x40\x00\x81" + "\x00\x00\x00\x00")))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if tex.Width != 2 || tex.Height != 1 {
+    t.Fatalf("size %dx%d, want 2x1", tex.Width, tex.Height)
+  }
+  if c := tex.Texels[0]; !near(c.Elem[0], 1.00390625) || !near(c.Elem[1], 0.50390625) || !near(c.Elem[2], 0.00390625) {
+    t.Errorf("first pixel %v", c)
+  }
+  if c := tex.Texels[1]; c.Elem != [3]float64{} {
+    t.Errorf("second pixel %v, want black", c)
+  }
+
+  for _, res := range []string{"-Y -4 +X 4", "-Y 4 +X -4", "-Y 0 +X 4", "-Y 4 +X 0", "-Y 1000000 +X 1000000", "-Y 2147483647 +X 2147483647"} {
+    if _, err := readHDR(bufio.NewReader(strings.NewReader(header + res + "\n"))); err == nil {
+      t.Errorf("%s: read without error", res)
+    }
+  }
+}
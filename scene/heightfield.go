@@ -0,0 +1,231 @@
+package scene
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"math"
+	"os"
+
+	"gray/glm"
+)
+
+// HEIGHTFIELD PRIMITIVES
+
+// A Heightfield is a grid of Width by Depth height samples over the xz
+// plane, starting at Pos and Spacing apart. Each cell is split into two
+// triangles whose normals are interpolated from the vertices. Rays walk the
+// grid cell by cell (2D DDA), skipping cells whose height range they miss.
+type Heightfield struct {
+  Pos glm.Vec3
+  Width, Depth int
+  Spacing float64
+  Heights []float64
+  Normals []glm.Vec3
+  CellMin, CellMax []float64
+  Bound AABB
+  Mat Material
+}
+
+// LoadHeightfield reads a grayscale image, 8 or 16 bit, as a heightfield.
+// Pixels are spacing apart and full white is height units high.
+func LoadHeightfield(file string, pos glm.Vec3, spacing, height float64, mat Material) (*Heightfield, error) {
+  infile, err := os.Open(file)
+  if err != nil {
+    return nil, err
+  }
+  defer infile.Close()
+  img, _, err := image.Decode(infile)
+  if err != nil {
+    return nil, fmt.Errorf("%s: %v", file, err)
+  }
+  b := img.Bounds()
+  if b.Dx() < 2 || b.Dy() < 2 {
+    return nil, fmt.Errorf("%s: heightfield needs at least 2x2 pixels", file)
+  }
+  heights := make([]float64, b.Dx()*b.Dy())
+  for y := 0; y < b.Dy(); y++ {
+    for x := 0; x < b.Dx(); x++ {
+      g := color.Gray16Model.Convert(img.At(b.Min.X + x, b.Min.Y + y)).(color.Gray16)
+      heights[y*b.Dx() + x] = height * float64(g.Y) / 0xffff
+    }
+  }
+  return NewHeightfield(heights, b.Dx(), b.Dy(), pos, spacing, mat), nil
+}
+
+// NewHeightfield builds a heightfield from width*depth heights, stored a row
+// of constant z at a time.
+func NewHeightfield(heights []float64, width, depth int, pos glm.Vec3, spacing float64, mat Material) *Heightfield {
+  p := &Heightfield{Pos: pos, Width: width, Depth: depth, Spacing: spacing, Heights: heights, Mat: mat}
+  p.Normals = make([]glm.Vec3, width*depth)
+  for z := 0; z < depth; z++ {
+    for x := 0; x < width; x++ {
+      // central differences, one sided at the edges.
+      x0, x1 := max(x-1, 0), min(x+1, width-1)
+      z0, z1 := max(z-1, 0), min(z+1, depth-1)
+      dx := (p.height(x1, z) - p.height(x0, z)) / (float64(x1 - x0) * spacing)
+      dz := (p.height(x, z1) - p.height(x, z0)) / (float64(z1 - z0) * spacing)
+      p.Normals[z*width + x] = unit(*glm.NewVec3(-dx, 1, -dz))
+    }
+  }
+  lo, hi := math.Inf(1), math.Inf(-1)
+  p.CellMin = make([]float64, (width-1)*(depth-1))
+  p.CellMax = make([]float64, (width-1)*(depth-1))
+  for z := 0; z < depth-1; z++ {
+    for x := 0; x < width-1; x++ {
+      a, b, c, d := p.height(x, z), p.height(x+1, z), p.height(x, z+1), p.height(x+1, z+1)
+      p.CellMin[z*(width-1) + x] = math.Min(math.Min(a, b), math.Min(c, d))
+      p.CellMax[z*(width-1) + x] = math.Max(math.Max(a, b), math.Max(c, d))
+      lo = math.Min(lo, p.CellMin[z*(width-1) + x])
+      hi = math.Max(hi, p.CellMax[z*(width-1) + x])
+    }
+  }
+  p.Bound = AABB{*pos.Add(glm.NewVec3(0, lo, 0)),
+    *pos.Add(glm.NewVec3(float64(width-1)*spacing, hi, float64(depth-1)*spacing)), mat}
+  return p
+}
+
+func (p Heightfield) height(x, z int) float64 {
+  return p.Heights[z*p.Width + x]
+}
+
+func (p Heightfield) vertex(x, z int) glm.Vec3 {
+  return *p.Pos.Add(glm.NewVec3(float64(x)*p.Spacing, p.height(x, z), float64(z)*p.Spacing))
+}
+
+// cellHit intersects the ray with the two triangles of cell x,z.
+func (p Heightfield) cellHit(x, z int, ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  corners := [4][2]int{{x, z}, {x+1, z}, {x+1, z+1}, {x, z+1}}
+  for _, tri := range [2][3]int{{0, 1, 2}, {0, 2, 3}} {
+    t := Triangle{}
+    for i, c := range tri {
+      t.Verts[i] = p.vertex(corners[c][0], corners[c][1])
+    }
+    if hit, l, u, v := t.barycentric(ray, origin); hit && (!b || l < raylen) {
+      b, raylen = true, l
+      normal = glm.Vec3{}
+      for i, w := range [3]float64{1 - u - v, u, v} {
+        c := corners[tri[i]]
+        normal.Iadd(p.Normals[c[1]*p.Width + c[0]].Scale(w))
+      }
+    }
+  }
+  return
+}
+
+func (p Heightfield) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  near, far, ok := p.Bound.Span(ray, origin)
+  if !ok || far < 0 {
+    return false, 0, normal
+  }
+  t := math.Max(near, 0)
+  start := origin.Add(ray.Scale(t)).Subtract(&p.Pos)
+  cells := [2]int{p.Width - 1, p.Depth - 1}
+  cell, step := [2]int{}, [2]int{}
+  next, delta := [2]float64{}, [2]float64{}
+  for i, axis := range [2]int{0, 2} {
+    cell[i] = max(0, min(int(math.Floor(start.Elem[axis] / p.Spacing)), cells[i] - 1))
+    switch d := ray.Elem[axis]; {
+      case d > 0:
+        step[i], delta[i] = 1, p.Spacing/d
+        next[i] = t + (float64(cell[i]+1)*p.Spacing - start.Elem[axis]) / d
+      case d < 0:
+        step[i], delta[i] = -1, -p.Spacing/d
+        next[i] = t + (float64(cell[i])*p.Spacing - start.Elem[axis]) / d
+      default:
+        step[i], next[i] = 0, math.Inf(1)
+    }
+  }
+  for t <= far {
+    exit := math.Min(far, math.Min(next[0], next[1]))
+    // only test cells whose height range the ray passes through.
+    y0 := origin.Elem[1] + t*ray.Elem[1] - p.Pos.Elem[1]
+    y1 := origin.Elem[1] + exit*ray.Elem[1] - p.Pos.Elem[1]
+    c := cell[1]*cells[0] + cell[0]
+    if math.Min(y0, y1) <= p.CellMax[c] + Epsilon && math.Max(y0, y1) >= p.CellMin[c] - Epsilon {
+      if b, raylen, normal = p.cellHit(cell[0], cell[1], ray, origin); b {
+        return
+      }
+    }
+    i := 0
+    if next[1] < next[0] {
+      i = 1
+    }
+    t = next[i]
+    next[i] += delta[i]
+    if cell[i] += step[i]; step[i] == 0 || cell[i] < 0 || cell[i] >= cells[i] {
+      break
+    }
+  }
+  return false, 0, normal
+}
+
+// HitPoint rebuilds the hit from the corners of the triangle under it.
+func (p Heightfield) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  q := origin.Add(ray.Scale(raylen)).Subtract(&p.Pos)
+  fx, fz := q.Elem[0] / p.Spacing, q.Elem[2] / p.Spacing
+  x := max(0, min(int(math.Floor(fx)), p.Width - 2))
+  z := max(0, min(int(math.Floor(fz)), p.Depth - 2))
+  fx, fz = fx - float64(x), fz - float64(z)
+  // the cell is split along the same diagonal as in cellHit.
+  corners, w := [3][2]int{{x, z}, {x+1, z}, {x+1, z+1}}, [3]float64{1 - fx, fx - fz, fz}
+  if fz > fx {
+    corners, w = [3][2]int{{x, z}, {x+1, z+1}, {x, z+1}}, [3]float64{1 - fz, fx, fz - fx}
+  }
+  v := [3]glm.Vec3{}
+  for i, c := range corners {
+    v[i] = p.vertex(c[0], c[1])
+  }
+  return weighted(v, w)
+}
+
+func (p Heightfield) Bounds() AABB {
+  return p.Bound
+}
+
+func (p Heightfield) UV(point glm.Vec3) (u, v float64) {
+  d := point.Subtract(&p.Pos)
+  return d.Elem[0] / (float64(p.Width-1)*p.Spacing), d.Elem[2] / (float64(p.Depth-1)*p.Spacing)
+}
+
+func (p Heightfield) GetMaterial() Material {
+  return p.Mat
+}
+
+// CreateTerrainScene shows rolling hills, a heightfield of summed waves,
+// from above with the sun low.
+func CreateTerrainScene() (scene *Scene, err error) {
+  scene = &Scene{}
+  scene.Lights = []Light{
+    Light{*glm.NewVec3(-800.0, 600.0, 200.0), *glm.NewVec3(0.9, 0.85, 0.7), *glm.NewVec3(1.0, 0.0, 0.0), 0},
+  }
+  grass := Material{
+    *glm.NewVec3(0.35, 0.55, 0.25), *glm.NewVec3(0.35, 0.55, 0.25), *glm.NewVec3(0.1, 0.1, 0.1), 10.0, 0.0, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
+
+  const n = 128
+  const spacing = 8.0
+  heights := make([]float64, n*n)
+  for z := 0; z < n; z++ {
+    for x := 0; x < n; x++ {
+      fx, fz := float64(x)/n, float64(z)/n
+      heights[z*n + x] = 60*math.Sin(2*math.Pi*(fx + 0.3*fz)) * math.Cos(2*math.Pi*fz) +
+        25*math.Sin(6*math.Pi*fx + 1) * math.Sin(5*math.Pi*fz) + 8*math.Cos(14*math.Pi*(fx - fz))
+    }
+  }
+  half := spacing*(n - 1)/2
+  scene.Primitives = []Primitive{
+    *NewHeightfield(heights, n, n, *glm.NewVec3(-half, -200, -half), spacing, grass),
+  }
+
+  // looking down at the hills, with up square to the view.
+  scene.Eye = *glm.NewVec3(0.0, 150.0, 800.0)
+  scene.View = unit(*glm.NewVec3(0.0, -0.4, -1.0))
+  scene.Up = unit(*glm.NewVec3(0.0, 1.0, -0.4))
+  scene.Ambient = *glm.NewVec3(0.2, 0.2, 0.25)
+  scene.FOV = 50
+  scene.Width = 512
+  scene.Height = 512
+  return scene, nil
+}
@@ -0,0 +1,78 @@
+package scene
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gray/glm"
+)
+
+// bruteHit intersects the ray with every triangle of the heightfield, split
+// as cellHit splits cells.
+func bruteHit(p *Heightfield, ray, origin glm.Vec3) (b bool, raylen float64) {
+  for z := 0; z < p.Depth-1; z++ {
+    for x := 0; x < p.Width-1; x++ {
+      a, c, d, e := p.vertex(x, z), p.vertex(x+1, z), p.vertex(x+1, z+1), p.vertex(x, z+1)
+      for _, t := range []Triangle{{[3]glm.Vec3{a, c, d}, Material{}}, {[3]glm.Vec3{a, d, e}, Material{}}} {
+        if hit, l, _ := t.Intersect(ray, origin); hit && (!b || l < raylen) {
+          b, raylen = true, l
+        }
+      }
+    }
+  }
+  return
+}
+
+func TestHeightfieldDDA(t *testing.T) {
+  r := rand.New(rand.NewSource(1))
+  const w, d = 6, 5
+  heights := make([]float64, w*d)
+  for i := range heights {
+    heights[i] = 3*r.Float64()
+  }
+  p := NewHeightfield(heights, w, d, vec(-2, 1, 3), 1.5, Material{})
+  size := p.Bound.Max.Subtract(&p.Bound.Min)
+  random := func(grow float64) glm.Vec3 {
+    q := glm.Vec3{}
+    for i := range q.Elem {
+      q.Elem[i] = p.Bound.Min.Elem[i] + (r.Float64()*(1 + 2*grow) - grow)*size.Elem[i]
+    }
+    return q
+  }
+  hits := 0
+  for i := 0; i < 5000; i++ {
+    origin := random(1)
+    // some rays start over the field, some run along an axis.
+    if i%5 == 0 {
+      origin.Elem[1] = p.Bound.Max.Elem[1] + 1
+    }
+    target := random(0)
+    ray := *target.Subtract(&origin)
+    if i%7 == 0 {
+      ray.Elem[0] = 0
+    }
+    want, want_len := bruteHit(p, ray, origin)
+    got, got_len, _ := p.Intersect(ray, origin)
+    if got != want || got && math.Abs(got_len - want_len) > 1e-9*math.Max(1, want_len) {
+      t.Errorf("ray %v from %v: hit %v at %g, want %v at %g", ray, origin, got, got_len, want, want_len)
+    }
+    if want {
+      hits++
+    }
+  }
+  if hits < 1000 {
+    t.Errorf("only %d of the rays hit", hits)
+  }
+}
+
+func TestTerrainScene(t *testing.T) {
+  sc, err := LoadScene("terrain", 0)
+  if err != nil {
+    t.Fatal(err)
+  }
+  p := sc.Primitives[0].(Heightfield)
+  if hit, _, _ := p.Intersect(sc.View, sc.Eye); !hit {
+    t.Error("the camera looks past the terrain")
+  }
+}
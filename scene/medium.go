@@ -0,0 +1,193 @@
+package scene
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"gray/glm"
+)
+
+// PARTICIPATING MEDIA
+
+// A Medium fills space with particles that absorb and scatter light, by the
+// given fractions per unit length on each channel. G shapes the scattering
+// after Henyey and Greenstein: 0 scatters evenly, towards 1 forwards and
+// towards -1 back.
+type Medium struct {
+  Absorption, Scattering glm.Vec3
+  G float64
+}
+
+// Extinction is the fraction of light lost per unit length, to absorption
+// and scattering both.
+func (m Medium) Extinction() glm.Vec3 {
+  return *m.Absorption.Add(&m.Scattering)
+}
+
+// HenyeyGreenstein is the density per unit solid angle of light scattering
+// by an angle with the given cosine from its direction of travel.
+func HenyeyGreenstein(cos, g float64) float64 {
+  d := 1 + g*g - 2*g*cos
+  return (1 - g*g) / (4*math.Pi * d * math.Sqrt(d))
+}
+
+// A Volume is a medium within the box from Min to Max, which may be
+// unbounded. Its density varies over Grid, if it has one, where 1 gives the
+// Medium's coefficients.
+type Volume struct {
+  Medium Medium
+  Min, Max glm.Vec3
+  Grid *DensityGrid
+}
+
+// A DensityGrid holds densities at the centres of cells spanning a volume's
+// box, x varying fastest.
+type DensityGrid struct {
+  Size [3]int
+  Density []float64
+}
+
+// NewFog fills space below the height top, which may be infinite, with m.
+func NewFog(m Medium, top float64) Volume {
+  inf := math.Inf(1)
+  return Volume{m, *glm.NewVec3(-inf, -inf, -inf), *glm.NewVec3(inf, top, inf), nil}
+}
+
+// Span returns the range of ray lengths for which the ray is inside the
+// volume's box.
+func (v Volume) Span(ray, origin glm.Vec3) (near, far float64, b bool) {
+  return AABB{v.Min, v.Max, Material{}}.Span(ray, origin)
+}
+
+// Step is the side of the grid's smallest cell, or 0 without a grid.
+func (v Volume) Step() float64 {
+  if v.Grid == nil {
+    return 0
+  }
+  step := math.Inf(1)
+  for i, n := range v.Grid.Size {
+    step = math.Min(step, (v.Max.Elem[i] - v.Min.Elem[i]) / float64(n))
+  }
+  return step
+}
+
+// Density is the trilinearly filtered density at p, 1 everywhere in a volume
+// without a grid and 0 outside the box.
+func (v Volume) Density(p glm.Vec3) float64 {
+  for i := range p.Elem {
+    if p.Elem[i] < v.Min.Elem[i] || p.Elem[i] > v.Max.Elem[i] {
+      return 0
+    }
+  }
+  g := v.Grid
+  if g == nil {
+    return 1
+  }
+  var cell [3]int
+  var frac [3]float64
+  for i, n := range g.Size {
+    x := (p.Elem[i] - v.Min.Elem[i]) / (v.Max.Elem[i] - v.Min.Elem[i]) * float64(n) - 0.5
+    x = math.Max(0, math.Min(float64(n - 1), x))
+    cell[i] = min(int(x), max(n - 2, 0))
+    frac[i] = x - float64(cell[i])
+  }
+  d := 0.0
+  for corner := 0; corner < 8; corner++ {
+    w := 1.0
+    idx, stride := 0, 1
+    for i, n := range g.Size {
+      c := cell[i]
+      if corner&(1<<uint(i)) != 0 {
+        w *= frac[i]
+        c = min(c + 1, n - 1)
+      } else {
+        w *= 1 - frac[i]
+      }
+      idx += c * stride
+      stride *= n
+    }
+    if w > 0 {
+      d += w * g.Density[idx]
+    }
+  }
+  return d
+}
+
+// MAX_VOLUME_VALUES bounds the values in a density grid file, so a corrupt
+// size fails cleanly rather than asking for all of memory.
+const MAX_VOLUME_VALUES = 1 << 28
+
+// LoadVolume reads a density grid in Mitsuba's .vol format, of float32
+// densities with the box they span, to be filled with m. Grids of several
+// channels are averaged.
+func LoadVolume(file string, m Medium) (Volume, error) {
+  infile, err := os.Open(file)
+  if err != nil {
+    return Volume{}, err
+  }
+  defer infile.Close()
+  v, err := readVolume(infile, m)
+  if err != nil {
+    return Volume{}, fmt.Errorf("%s: %v", file, err)
+  }
+  return v, nil
+}
+
+func readVolume(r io.Reader, m Medium) (Volume, error) {
+  var header struct {
+    Magic [3]byte
+    Version uint8
+    Encoding int32
+    Size [3]int32
+    Channels int32
+    Box [6]float32
+  }
+  if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+    return Volume{}, err
+  }
+  if string(header.Magic[:]) != "VOL" || header.Version != 3 {
+    return Volume{}, fmt.Errorf("not a version 3 .vol file")
+  }
+  // only float32 data.
+  if header.Encoding != 1 {
+    return Volume{}, fmt.Errorf("unsupported encoding %d", header.Encoding)
+  }
+  channels := int(header.Channels)
+  if channels <= 0 || channels > MAX_VOLUME_VALUES {
+    return Volume{}, fmt.Errorf("bad channel count %d", channels)
+  }
+  g := &DensityGrid{}
+  cells := 1
+  for i, n := range header.Size {
+    // multiplied in turn, so the product never overflows.
+    if n <= 0 || int(n) > MAX_VOLUME_VALUES/channels/cells {
+      return Volume{}, fmt.Errorf("bad grid size %v", header.Size)
+    }
+    g.Size[i] = int(n)
+    cells *= int(n)
+  }
+  data := make([]float32, cells*channels)
+  if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+    if err == io.ErrUnexpectedEOF || err == io.EOF {
+      return Volume{}, fmt.Errorf("fewer than the %d values of a %v grid", len(data), header.Size)
+    }
+    return Volume{}, err
+  }
+  g.Density = make([]float64, cells)
+  for i := range g.Density {
+    for c := 0; c < channels; c++ {
+      g.Density[i] += float64(data[i*channels + c]) / float64(channels)
+    }
+  }
+  b := header.Box
+  v := Volume{m, *glm.NewVec3(float64(b[0]), float64(b[1]), float64(b[2])), *glm.NewVec3(float64(b[3]), float64(b[4]), float64(b[5])), g}
+  for i := range v.Min.Elem {
+    if !(v.Max.Elem[i] > v.Min.Elem[i]) {
+      return Volume{}, fmt.Errorf("empty box")
+    }
+  }
+  return v, nil
+}
@@ -0,0 +1,57 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// volFile encodes a version 3 .vol file of float32 data over the unit box.
+func volFile(size [3]int32, channels int32, data []float32) []byte {
+  var b bytes.Buffer
+  binary.Write(&b, binary.LittleEndian, struct {
+    Magic [3]byte
+    Version uint8
+    Encoding int32
+    Size [3]int32
+    Channels int32
+    Box [6]float32
+  }{[3]byte{'V', 'O', 'L'}, 3, 1, size, channels, [6]float32{0, 0, 0, 1, 1, 1}})
+  binary.Write(&b, binary.LittleEndian, data)
+  return b.Bytes()
+}
+
+func TestReadVolume(t *testing.T) {
+  // two cells along x, of two channels averaged.
+  v, err := readVolume(bytes.NewReader(volFile([3]int32{2, 1, 1}, 2, []float32{0, 1, 2, 4})), Medium{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if v.Grid.Size != [3]int{2, 1, 1} || len(v.Grid.Density) != 2 {
+    t.Fatalf("grid %v of %d densities", v.Grid.Size, len(v.Grid.Density))
+  }
+  if d := v.Density(vec(0.25, 0.5, 0.5)); !near(d, 0.5) {
+    t.Errorf("density %g at the first cell's centre, want 0.5", d)
+  }
+  if d := v.Density(vec(0.75, 0.5, 0.5)); !near(d, 3) {
+    t.Errorf("density %g at the second cell's centre, want 3", d)
+  }
+
+  for _, c := range []struct {
+    name string
+    data []byte
+  }{
+    {"overflowing size", volFile([3]int32{1 << 21, 1 << 21, 1 << 22}, 1, nil)},
+    {"huge size", volFile([3]int32{1 << 15, 1 << 15, 1}, 1, nil)},
+    {"zero size", volFile([3]int32{2, 0, 1}, 1, nil)},
+    {"negative size", volFile([3]int32{2, -1, 1}, 1, nil)},
+    {"no channels", volFile([3]int32{2, 1, 1}, 0, nil)},
+    {"huge channels", volFile([3]int32{2, 1, 1}, 1 << 30, nil)},
+    {"short data", volFile([3]int32{2, 2, 1}, 1, []float32{1, 2, 3})},
+    {"not a volume", []byte("not a volume file at all, but long enough to read a header from")},
+  } {
+    if _, err := readVolume(bytes.NewReader(c.data), Medium{}); err == nil {
+      t.Errorf("%s: read without error", c.name)
+    }
+  }
+}
@@ -0,0 +1,95 @@
+package scene
+
+import (
+	"math"
+
+	"gray/glm"
+)
+
+// ERROR BOUNDS
+
+// MachineEpsilon bounds the relative rounding error of one float64 operation.
+const MachineEpsilon = 1.0 / (1 << 53)
+
+// Gamma bounds the relative error built up by n float64 operations in turn.
+func Gamma(n int) float64 {
+  e := float64(n) * MachineEpsilon
+  return e / (1 - e)
+}
+
+// A Hit is where a ray meets a primitive: the point, a bound on its error on
+// each axis, and the geometric normal there, which may differ from the
+// shading normal Intersect returns.
+type Hit struct {
+  Point, Error, Normal glm.Vec3
+}
+
+// An ErrorBounded primitive works out its own hit points, from its surface
+// where it can rather than along the ray, and bounds their error. normal is
+// the one Intersect returned.
+type ErrorBounded interface {
+  HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit
+}
+
+// HitAt returns the hit for a ray that met p at raylen with the given normal.
+// Every primitive here bounds its own errors; others are taken to have solved
+// for raylen in closed form.
+func HitAt(p Primitive, ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  if e, ok := p.(ErrorBounded); ok {
+    return e.HitPoint(ray, origin, raylen, normal)
+  }
+  return alongRay(ray, origin, raylen, 16, normal)
+}
+
+// alongRay is the hit at origin + raylen*ray for a ray length good to n
+// roundings of the ray's extent.
+func alongRay(ray, origin glm.Vec3, raylen float64, n int, normal glm.Vec3) Hit {
+  h := Hit{Point: *origin.Add(ray.Scale(raylen)), Normal: normal}
+  for i := range h.Error.Elem {
+    h.Error.Elem[i] = Gamma(n) * (math.Abs(origin.Elem[i]) + math.Abs(raylen*ray.Elem[i]))
+  }
+  return h
+}
+
+// weighted is the hit at the point with barycentric weights w in triangle v,
+// which lies on the triangle's plane whatever the error in w.
+func weighted(v [3]glm.Vec3, w [3]float64) Hit {
+  h := Hit{}
+  for k := range v {
+    for i := range h.Point.Elem {
+      h.Point.Elem[i] += w[k] * v[k].Elem[i]
+      h.Error.Elem[i] += math.Abs(w[k] * v[k].Elem[i])
+    }
+  }
+  h.Error.Iscale(Gamma(7))
+  e1, e2 := v[1].Subtract(&v[0]), v[2].Subtract(&v[0])
+  h.Normal = *e1.Cross(e2)
+  return h
+}
+
+// Offset returns the origin for a ray leaving the hit along dir: the point
+// moved along the normal, to dir's side, just past its error bound. Every
+// coordinate is then rounded away from the surface, so rays never start
+// behind it and primitives need only accept hits in front of the origin.
+func (h Hit) Offset(dir glm.Vec3) glm.Vec3 {
+  n := unit(h.Normal)
+  d := 0.0
+  for i := range n.Elem {
+    d += math.Abs(n.Elem[i]) * h.Error.Elem[i]
+  }
+  // the side is taken from dir, so that exact points are still rounded off.
+  side := 1.0
+  if n.Dot(&dir) < 0 {
+    side = -1
+  }
+  o := *h.Point.Add(n.Scale(side*d))
+  for i := range o.Elem {
+    switch off := n.Elem[i] * side; {
+      case off > 0:
+        o.Elem[i] = math.Nextafter(o.Elem[i], math.Inf(1))
+      case off < 0:
+        o.Elem[i] = math.Nextafter(o.Elem[i], math.Inf(-1))
+    }
+  }
+  return o
+}
@@ -0,0 +1,172 @@
+package scene
+
+import (
+	"math/rand"
+	"testing"
+
+	"gray/glm"
+)
+
+// OFFSETS
+
+// the scales tested at, each placed far enough from the origin that the
+// position's rounding outweighs the primitive's own.
+var offsetScales = []float64{1e-3, 1, 1e5}
+
+func offsetCentre(s float64) glm.Vec3 {
+  c := vec(7.3, -3.1, 5.7)
+  return *c.Scale(100*s)
+}
+
+type offsetCase struct {
+  name string
+  prim Primitive
+  // convex primitives are not hit again by rays reflected off them, and
+  // flat ones by rays passing through.
+  convex, flat bool
+}
+
+// offsetPrims are primitives of size s around c.
+func offsetPrims(c glm.Vec3, s float64) []offsetCase {
+  at := func(x, y, z float64) glm.Vec3 {
+    d := vec(x, y, z)
+    return *c.Add(d.Scale(s))
+  }
+  tilted := vec(1, 2, 3)
+  s4 := s*s*s*s
+  bound := AABB{vec(-2*s, -2*s, -2*s), vec(2*s, 2*s, 2*s), Material{}}
+  tet := [][3]float64{}
+  for _, v := range []glm.Vec3{at(1, 1, 1), at(1, -1, -1), at(-1, 1, -1), at(-1, -1, 1)} {
+    tet = append(tet, v.Elem)
+  }
+  return []offsetCase{
+    {"sphere", Sphere{c, s, Material{}}, true, false},
+    {"plane", Plane{c, tilted, Material{}}, true, true},
+    {"disk", Disk{c, tilted, s, Material{}}, true, true},
+    {"triangle", Triangle{[3]glm.Vec3{at(-1, -1, 0), at(1, -1, 0.5), at(0, 1, -0.5)}, Material{}}, true, true},
+    {"box", Box{at(-0.5, -0.5, -0.5), s, Material{}}, true, false},
+    {"cylinder", Cylinder{at(0, -1, 0), *tilted.Scale(s/2), s, true, Material{}}, true, false},
+    {"cone", Cone{at(0, -1, 0), *tilted.Scale(s/2), s, true, Material{}}, true, false},
+    {"torus", Torus{c, tilted, s, s/3, Material{}}, false, false},
+    {"quartic", Quartic{c, []QuarticTerm{{1, 4, 0, 0}, {1, 0, 4, 0}, {1, 0, 0, 4}, {-s4, 0, 0, 0}}, bound, Material{}}, true, false},
+    {"csg", CSG{Difference, Sphere{c, s, Material{}}, Sphere{at(1, 0, 0), 0.6*s, Material{}}, Material{}}, false, false},
+    {"mesh", *NewMesh(tet, [][3]int{{0, 1, 2}, {0, 3, 1}, {0, 2, 3}, {1, 3, 2}}, Material{}), true, false},
+  }
+}
+
+// randomOn is a point on the sphere of radius s around c.
+func randomOn(r *rand.Rand, c glm.Vec3, s float64) glm.Vec3 {
+  d := vec(r.NormFloat64(), r.NormFloat64(), r.NormFloat64())
+  return *c.Add(d.Scale(s / d.Length()))
+}
+
+func randomIn(r *rand.Rand, c glm.Vec3, s float64) glm.Vec3 {
+  d := vec(r.Float64() - 0.5, r.Float64() - 0.5, r.Float64() - 0.5)
+  return *c.Add(d.Scale(2*s))
+}
+
+// rays leaving a hit, back the way they came, reflected off it or through a
+// flat primitive, must not find it again.
+func TestOffsetNoAcne(t *testing.T) {
+  r := rand.New(rand.NewSource(1))
+  for _, s := range offsetScales {
+    c := offsetCentre(s)
+    for _, p := range offsetPrims(c, s) {
+      hits, acne := 0, 0
+      for i := 0; i < 500; i++ {
+        origin := randomOn(r, c, 4*s)
+        target := randomIn(r, c, 0.8*s)
+        ray := *target.Subtract(&origin)
+        hit, raylen, normal := p.prim.Intersect(ray, origin)
+        if !hit {
+          continue
+        }
+        hits++
+        h := HitAt(p.prim, ray, origin, raylen, normal)
+        back := h.Offset(*ray.Scale(-1))
+        if hit, l, _ := p.prim.Intersect(*origin.Subtract(&back), back); hit && l < 1 {
+          acne++
+          continue
+        }
+        n := unit(h.Normal)
+        reflected := *ray.Subtract(n.Scale(2*n.Dot(&ray)))
+        if hit, _, _ := p.prim.Intersect(reflected, h.Offset(reflected)); hit && p.convex {
+          acne++
+          continue
+        }
+        if hit, _, _ := p.prim.Intersect(ray, h.Offset(ray)); hit && p.flat {
+          acne++
+        }
+      }
+      if hits < 50 {
+        t.Errorf("%s at scale %g: only %d hits", p.name, s, hits)
+      }
+      if acne > 0 {
+        t.Errorf("%s at scale %g: %d of %d hits found again", p.name, s, acne, hits)
+      }
+    }
+  }
+}
+
+// a sphere resting on a plane shadows the plane right up to where they
+// touch, and the plane the sphere.
+func TestOffsetContactShadow(t *testing.T) {
+  for _, s := range offsetScales {
+    c := offsetCentre(s)
+    up := vec(0, 1, 0)
+    down := vec(0, -1, 0)
+    plane := Plane{c, up, Material{}}
+    sphere := Sphere{*c.Add(up.Scale(s)), s, Material{}}
+    // the sphere is 1e-10*s above the plane this far from the contact.
+    for _, d := range []float64{1e-2, 1e-4, 1.4e-5} {
+      beside := c
+      beside.Elem[0] += d*s
+      origin := *beside.Add(down.Scale(s))
+      _, raylen, normal := plane.Intersect(up, origin)
+      h := HitAt(plane, up, origin, raylen, normal)
+      if hit, _, _ := sphere.Intersect(up, h.Offset(up)); !hit {
+        t.Errorf("scale %g, %g from the contact: plane not shadowed by the sphere", s, d)
+      }
+      origin = sphere.Pos
+      ray := *beside.Subtract(&origin)
+      _, raylen, normal = sphere.Intersect(ray, origin)
+      h = HitAt(sphere, ray, origin, raylen, normal)
+      if hit, _, _ := plane.Intersect(ray, h.Offset(ray)); !hit {
+        t.Errorf("scale %g, %g from the contact: sphere not shadowed by the plane", s, d)
+      }
+    }
+  }
+}
+
+// shadow rays aimed at the lit side of a light's point reach it without
+// hitting the light itself.
+func TestOffsetLightSamples(t *testing.T) {
+  r := rand.New(rand.NewSource(2))
+  for _, s := range offsetScales {
+    c := offsetCentre(s)
+    sphere := Sphere{c, s, Material{}}
+    tri := *NewMesh([][3]float64{{c.Elem[0], c.Elem[1], c.Elem[2]}, {c.Elem[0] + s, c.Elem[1], c.Elem[2]}, {c.Elem[0], c.Elem[1] + s, c.Elem[2] + s}}, [][3]int{{0, 1, 2}}, Material{})
+    for _, l := range []struct {
+      name string
+      prim Primitive
+      e Emitter
+    }{
+      {"sphere", sphere, SphereEmitter{sphere, SolidAngle}},
+      {"sphere by area", sphere, SphereEmitter{sphere, UniformArea}},
+      {"mesh", tri, NewMeshEmitter(tri)},
+    } {
+      for i := 0; i < 500; i++ {
+        from := randomOn(r, c, 4*s)
+        lit, pdf := l.e.Sample(from, r.Float64(), r.Float64())
+        if pdf == 0 {
+          continue
+        }
+        target := lit.Offset(*from.Subtract(&lit.Point))
+        if hit, raylen, _ := l.prim.Intersect(*target.Subtract(&from), from); hit && raylen < 1 {
+          t.Errorf("%s light at scale %g: shadowed by itself at %g", l.name, s, raylen)
+          break
+        }
+      }
+    }
+  }
+}
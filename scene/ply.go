@@ -0,0 +1,250 @@
+package scene
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"gray/glm"
+)
+
+// PLY LOADER
+
+type plyProperty struct {
+  Name, Type string
+  // the count type of a list property, empty for scalars.
+  CountType string
+}
+
+type plyElement struct {
+  Name string
+  Count int
+  Props []plyProperty
+}
+
+// plyReader reads values one at a time from an ASCII or binary body.
+type plyReader struct {
+  rd *bufio.Reader
+  order binary.ByteOrder // nil for ASCII
+  words []string
+}
+
+var plySizes = map[string]int{
+  "char": 1, "uchar": 1, "int8": 1, "uint8": 1,
+  "short": 2, "ushort": 2, "int16": 2, "uint16": 2,
+  "int": 4, "uint": 4, "int32": 4, "uint32": 4,
+  "float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+func (r *plyReader) value(typ string) (float64, error) {
+  if r.order == nil {
+    for len(r.words) == 0 {
+      line, err := r.rd.ReadString('\n')
+      if line == "" && err != nil {
+        if err == io.EOF {
+          err = io.ErrUnexpectedEOF
+        }
+        return 0, err
+      }
+      r.words = strings.Fields(line)
+    }
+    w := r.words[0]
+    r.words = r.words[1:]
+    return strconv.ParseFloat(w, 64)
+  }
+  buf := make([]byte, plySizes[typ])
+  if _, err := io.ReadFull(r.rd, buf); err != nil {
+    return 0, err
+  }
+  switch typ {
+    case "char", "int8":
+      return float64(int8(buf[0])), nil
+    case "uchar", "uint8":
+      return float64(buf[0]), nil
+    case "short", "int16":
+      return float64(int16(r.order.Uint16(buf))), nil
+    case "ushort", "uint16":
+      return float64(r.order.Uint16(buf)), nil
+    case "int", "int32":
+      return float64(int32(r.order.Uint32(buf))), nil
+    case "uint", "uint32":
+      return float64(r.order.Uint32(buf)), nil
+    case "float", "float32":
+      return float64(math.Float32frombits(r.order.Uint32(buf))), nil
+  }
+  return math.Float64frombits(r.order.Uint64(buf)), nil
+}
+
+func ReadPly(file string) *Mesh {
+  m, err := parsePly(file)
+  if err != nil {
+    fmt.Println(err)
+    fmt.Println()
+    return &Mesh{}
+  }
+  return m
+}
+
+// parsePly reads the vertex and face elements of a PLY file, ASCII or binary
+// of either byte order. Vertex normals and colours are kept when present;
+// other elements and properties are skipped.
+func parsePly(file string) (*Mesh, error) {
+  infile, err := os.Open(file)
+  if err != nil {
+    return nil, err
+  }
+  defer infile.Close()
+  r := &plyReader{rd: bufio.NewReader(infile)}
+
+  elements := []*plyElement{}
+  format := ""
+header:
+  for lineno := 1; ; lineno++ {
+    line, err := r.rd.ReadString('\n')
+    if err != nil {
+      return nil, fmt.Errorf("%s: header: %v", file, err)
+    }
+    s := strings.Fields(line)
+    if lineno == 1 {
+      if len(s) != 1 || s[0] != "ply" {
+        return nil, fmt.Errorf("%s: not a PLY file", file)
+      }
+      continue
+    }
+    if len(s) == 0 {
+      continue
+    }
+    bad := fmt.Errorf("%s:%d: bad header line %q", file, lineno, strings.TrimSpace(line))
+    switch s[0] {
+      case "format":
+        if len(s) < 2 {
+          return nil, bad
+        }
+        format = s[1]
+      case "element":
+        if len(s) != 3 {
+          return nil, bad
+        }
+        n, err := strconv.Atoi(s[2])
+        if err != nil || n < 0 {
+          return nil, bad
+        }
+        elements = append(elements, &plyElement{Name: s[1], Count: n})
+      case "property":
+        if len(elements) == 0 {
+          return nil, bad
+        }
+        e := elements[len(elements)-1]
+        switch {
+          case len(s) == 5 && s[1] == "list" && plySizes[s[2]] > 0 && plySizes[s[3]] > 0:
+            e.Props = append(e.Props, plyProperty{s[4], s[3], s[2]})
+          case len(s) == 3 && plySizes[s[1]] > 0:
+            e.Props = append(e.Props, plyProperty{s[2], s[1], ""})
+          default:
+            return nil, bad
+        }
+      case "end_header":
+        break header
+    }
+  }
+  switch format {
+    case "ascii":
+    case "binary_little_endian":
+      r.order = binary.LittleEndian
+    case "binary_big_endian":
+      r.order = binary.BigEndian
+    default:
+      return nil, fmt.Errorf("%s: unknown format %q", file, format)
+  }
+
+  verts := [][3]float64{}
+  normals, colours := []glm.Vec3{}, []glm.Vec3{}
+  polys := [][]int{}
+  for _, e := range elements {
+    for i := 0; i < e.Count; i++ {
+      values := map[string]float64{}
+      var list []int
+      for _, p := range e.Props {
+        if p.CountType == "" {
+          v, err := r.value(p.Type)
+          if err != nil {
+            return nil, fmt.Errorf("%s: %s %d: %v", file, e.Name, i, err)
+          }
+          values[p.Name] = v
+          continue
+        }
+        n, err := r.value(p.CountType)
+        if err != nil {
+          return nil, fmt.Errorf("%s: %s %d: %v", file, e.Name, i, err)
+        }
+        if n < 0 || n != math.Trunc(n) {
+          return nil, fmt.Errorf("%s: %s %d: bad list length %g", file, e.Name, i, n)
+        }
+        // grown as read, so that a huge count runs out of file, not memory.
+        items := []int{}
+        for j := 0; j < int(n); j++ {
+          v, err := r.value(p.Type)
+          if err != nil {
+            return nil, fmt.Errorf("%s: %s %d: %v", file, e.Name, i, err)
+          }
+          items = append(items, int(v))
+        }
+        if e.Name == "face" && (p.Name == "vertex_indices" || p.Name == "vertex_index") {
+          list = items
+        }
+      }
+      switch e.Name {
+        case "vertex":
+          verts = append(verts, [3]float64{values["x"], values["y"], values["z"]})
+          if _, ok := values["nx"]; ok {
+            normals = append(normals, *glm.NewVec3(values["nx"], values["ny"], values["nz"]))
+          }
+          if _, ok := values["red"]; ok {
+            colours = append(colours, plyColour(e, values))
+          }
+        case "face":
+          if len(list) < 3 {
+            return nil, fmt.Errorf("%s: face %d has %d vertices", file, i, len(list))
+          }
+          polys = append(polys, list)
+      }
+    }
+  }
+  // scans keep coincident vertices apart for their colours and normals.
+  fixed, err := repairLoaded(file, verts, Triangulate(polys), -1)
+  if err != nil {
+    return nil, err
+  }
+  m := NewMesh(fixed.Verts, fixed.Faces, Material{})
+  if len(normals) == len(verts) {
+    m.VertNormals = make([][3]glm.Vec3, len(m.Faces))
+    for i, f := range m.Faces {
+      for k, v := range f {
+        m.VertNormals[i][k] = normals[fixed.VertFrom[v]]
+      }
+    }
+  }
+  if len(colours) == len(verts) {
+    m.Colours = make([]glm.Vec3, len(fixed.VertFrom))
+    for i, v := range fixed.VertFrom {
+      m.Colours[i] = colours[v]
+    }
+  }
+  return m, nil
+}
+
+// plyColour reads an sRGB vertex colour, stored as bytes or as 0-1 floats.
+func plyColour(e *plyElement, values map[string]float64) glm.Vec3 {
+  c := *glm.NewVec3(values["red"], values["green"], values["blue"])
+  for _, p := range e.Props {
+    if p.Name == "red" && p.Type != "float" && p.Type != "float32" && p.Type != "double" && p.Type != "float64" {
+      c.Iscale(1.0/255)
+    }
+  }
+  return SRGBToLinearVec(c)
+}
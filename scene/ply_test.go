@@ -0,0 +1,64 @@
+package scene
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const plyTetrahedron = `ply
+format ascii 1.0
+element vertex 4
+property float x
+property float y
+property float z
+element face 4
+property list uchar int vertex_indices
+end_header
+0 0 0
+1 0 0
+0 1 0
+0 0 1
+3 0 2 1
+3 0 1 3
+3 0 3 2
+3 1 2 3
+`
+
+func writeTemp(t *testing.T, name, data string) string {
+  file := filepath.Join(t.TempDir(), name)
+  if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+    t.Fatal(err)
+  }
+  return file
+}
+
+func TestReadPly(t *testing.T) {
+  m, err := ReadMesh(writeTemp(t, "tet.ply", plyTetrahedron))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(m.Verts) != 4 || len(m.Faces) != 4 {
+    t.Errorf("read %d vertices and %d faces, want 4 and 4", len(m.Verts), len(m.Faces))
+  }
+  for _, bad := range []string{"-3 0 2 1", "2.5 0 2 1", "4000000000 0 2 1"} {
+    data := strings.Replace(plyTetrahedron, "3 0 2 1", bad, 1)
+    if _, err := ReadMesh(writeTemp(t, "bad.ply", data)); err == nil {
+      t.Errorf("face %q: no error", bad)
+    }
+  }
+}
+
+func TestLoadMeshScene(t *testing.T) {
+  sc, err := LoadScene(writeTemp(t, "tet.PLY", plyTetrahedron), 0)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if m, ok := sc.Primitives[0].(Mesh); !ok || len(m.Faces) != 4 {
+    t.Errorf("scene does not hold the mesh: %v", sc.Primitives[0])
+  }
+  if _, err := LoadScene(writeTemp(t, "tet.off", plyTetrahedron), 0); err == nil {
+    t.Errorf("unknown extension: no error")
+  }
+}
@@ -0,0 +1,193 @@
+package scene
+
+import (
+	"math"
+	"sort"
+)
+
+// POLYNOMIAL ROOTS
+//
+// Polynomials are stored lowest power first: c[i] multiplies x^i.
+
+const (
+  ROOT_ITERATIONS = 100
+  // relative size below which a leading coefficient is treated as zero.
+  DEGENERATE = 1e-12
+  // relative residual under which a turning point counts as a double root.
+  TANGENT = 1e-10
+)
+
+/**
+ * Real quadratic roots of Ax^2 + Bx + C. A tangent (double) root is reported
+ * once, and a vanishing A falls back to the linear root.
+ */
+func quadraticRoots(A,B,C float64) []float64 {
+  if A == 0 {
+    if B == 0 {
+      return nil
+    }
+    return []float64{-C/B}
+  }
+  t := B*B - 4*A*C
+  if t < 0 {
+    return nil
+  }
+  if t == 0 {
+    return []float64{-B/(2*A)}
+  }
+  // avoid cancellation between -B and the square root.
+  q := -0.5*(B + math.Copysign(math.Sqrt(t), B))
+  if q == 0 {
+    return []float64{0, 0}
+  }
+  return []float64{q/A, C/q}
+}
+
+/**
+ * Real roots of Ax^3 + Bx^2 + Cx + D in ascending order.
+ */
+func cubicRoots(A, B, C, D float64) []float64 {
+  return polyRoots([]float64{D, C, B, A})
+}
+
+/**
+ * Real roots of Ax^4 + Bx^3 + Cx^2 + Dx + E in ascending order.
+ */
+func quarticRoots(A, B, C, D, E float64) []float64 {
+  return polyRoots([]float64{E, D, C, B, A})
+}
+
+func polyEval(c []float64, x float64) float64 {
+  r := 0.0
+  for i := len(c) - 1; i >= 0; i-- {
+    r = r*x + c[i]
+  }
+  return r
+}
+
+// polyScale is the size of the terms of c at x, used to judge residuals.
+func polyScale(c []float64, x float64) float64 {
+  r := 0.0
+  for i := len(c) - 1; i >= 0; i-- {
+    r = r*math.Abs(x) + math.Abs(c[i])
+  }
+  return r
+}
+
+func polyDerivative(c []float64) []float64 {
+  d := make([]float64, len(c) - 1)
+  for i := range d {
+    d[i] = float64(i+1) * c[i+1]
+  }
+  return d
+}
+
+func polyMul(a, b []float64) []float64 {
+  r := make([]float64, len(a) + len(b) - 1)
+  for i, x := range a {
+    for j, y := range b {
+      r[i+j] += x*y
+    }
+  }
+  return r
+}
+
+// polyTrim drops leading coefficients that are negligible next to the rest.
+func polyTrim(c []float64) []float64 {
+  biggest := 0.0
+  for _, x := range c {
+    biggest = math.Max(biggest, math.Abs(x))
+  }
+  for len(c) > 1 && math.Abs(c[len(c)-1]) <= DEGENERATE*biggest {
+    c = c[:len(c)-1]
+  }
+  return c
+}
+
+/**
+ * Real roots of c in ascending order. The turning points (the roots of the
+ * derivative) split the real line into monotonic pieces which hold at most
+ * one root each; each root is then refined by safeguarded Newton iteration.
+ * Turning points that touch zero are tangent roots, as for grazing rays.
+ */
+func polyRoots(c []float64) []float64 {
+  c = polyTrim(c)
+  n := len(c) - 1
+  switch {
+    case n <= 0:
+      return nil
+    case n <= 2:
+      roots := quadraticRoots(0, c[1], c[0])
+      if n == 2 {
+        roots = quadraticRoots(c[2], c[1], c[0])
+      }
+      sort.Float64s(roots)
+      return roots
+  }
+  // Cauchy's bound on the magnitude of the roots.
+  bound := 0.0
+  for _, x := range c[:n] {
+    bound = math.Max(bound, math.Abs(x/c[n]))
+  }
+  bound += 1
+  points := []float64{-bound}
+  for _, x := range polyRoots(polyDerivative(c)) {
+    if x > -bound && x < bound {
+      points = append(points, x)
+    }
+  }
+  points = append(points, bound)
+  // judge every turning point by the terms at the furthest, so that grazes
+  // alike are alike tangent wherever they fall along the ray.
+  reach := 0.0
+  for _, x := range points[1:len(points)-1] {
+    reach = math.Max(reach, math.Abs(x))
+  }
+  tangent := TANGENT*polyScale(c, reach)
+
+  roots := []float64{}
+  add := func(x float64) {
+    if len(roots) == 0 || x - roots[len(roots)-1] > TANGENT*(1 + math.Abs(x)) {
+      roots = append(roots, x)
+    }
+  }
+  for i := 0; i + 1 < len(points); i++ {
+    lo, hi := points[i], points[i+1]
+    flo, fhi := polyEval(c, lo), polyEval(c, hi)
+    if i > 0 && math.Abs(flo) <= tangent {
+      add(lo)
+      continue
+    }
+    if (flo < 0) != (fhi < 0) && fhi != 0 {
+      add(bracketRoot(c, lo, hi, flo))
+    }
+  }
+  return roots
+}
+
+// bracketRoot finds the root of c in [lo,hi], where c changes sign.
+func bracketRoot(c []float64, lo, hi, flo float64) float64 {
+  d := polyDerivative(c)
+  x := 0.5*(lo + hi)
+  for i := 0; i < ROOT_ITERATIONS; i++ {
+    fx := polyEval(c, x)
+    if fx == 0 {
+      return x
+    }
+    if (fx < 0) == (flo < 0) {
+      lo, flo = x, fx
+    } else {
+      hi = x
+    }
+    // take the Newton step when it stays in the bracket, else bisect.
+    next := x - fx/polyEval(d, x)
+    if !(next > lo && next < hi) {
+      next = 0.5*(lo + hi)
+    }
+    if math.Abs(next - x) <= 1e-15*(1 + math.Abs(x)) {
+      return next
+    }
+    x = next
+  }
+  return x
+}
@@ -0,0 +1,64 @@
+package scene
+
+import (
+	"math"
+	"testing"
+)
+
+// withRoots returns the monic polynomial with the given roots.
+func withRoots(roots ...float64) []float64 {
+  c := []float64{1}
+  for _, r := range roots {
+    c = polyMul(c, []float64{-r, 1})
+  }
+  return c
+}
+
+func TestPolyRoots(t *testing.T) {
+  for _, c := range []struct {
+    name string
+    poly []float64
+    want []float64
+    // how far roots may be off: near-double roots closer than the tangent
+    // tolerance may come back as their turning point.
+    tol float64
+  }{
+    {"simple", withRoots(-2, 1, 3, 4), []float64{-2, 1, 3, 4}, 1e-9},
+    {"double", withRoots(-2, 1, 1, 3), []float64{-2, 1, 3}, 1e-9},
+    {"two doubles", withRoots(-1, -1, 2, 2), []float64{-1, 2}, 1e-9},
+    {"triple", withRoots(-1, 1, 1, 1), []float64{-1, 1}, 1e-6},
+    {"quadruple", withRoots(1, 1, 1, 1), []float64{1}, 1e-3},
+    {"double quadratic", withRoots(0.5, 0.5), []float64{0.5}, 1e-12},
+    {"double without others", polyMul(withRoots(1, 1), []float64{1, 0, 1}), []float64{1}, 1e-9},
+    {"near double", withRoots(-2, 1, 1.001, 3), []float64{-2, 1, 1.001, 3}, 1e-9},
+    {"nearer double", withRoots(-2, 1, 1.000001, 3), []float64{-2, 1, 1.000001, 3}, 1e-6},
+    {"no real roots", []float64{1, 0, 2, 0, 1}, nil, 0},
+    {"leading zero", []float64{-2, 1, 0, 0}, []float64{2}, 1e-12},
+  } {
+    roots := polyRoots(c.poly)
+    for i := 1; i < len(roots); i++ {
+      if roots[i] <= roots[i-1] {
+        t.Errorf("%s: roots %v not ascending", c.name, roots)
+      }
+    }
+    // every root is found, and nothing else.
+    closeTo := func(x float64, set []float64) bool {
+      for _, y := range set {
+        if math.Abs(x - y) <= c.tol {
+          return true
+        }
+      }
+      return false
+    }
+    for _, r := range c.want {
+      if !closeTo(r, roots) {
+        t.Errorf("%s: roots %v miss %g", c.name, roots, r)
+      }
+    }
+    for _, r := range roots {
+      if !closeTo(r, c.want) {
+        t.Errorf("%s: root %g is not one of %v", c.name, r, c.want)
+      }
+    }
+  }
+}
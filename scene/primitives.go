@@ -0,0 +1,455 @@
+package scene
+
+import (
+	"math"
+	"sort"
+
+	"gray/glm"
+)
+
+// Primitives that can be bounded report their axis-aligned extent. Infinite
+// primitives report infinite bounds.
+type Bounded interface {
+  Bounds() AABB
+}
+
+// A Surface maps points on a primitive to texture coordinates.
+type Surface interface {
+  UV(point glm.Vec3) (u, v float64)
+}
+
+// A Shaded primitive's material varies over its surface.
+type Shaded interface {
+  MaterialAt(point glm.Vec3) Material
+}
+
+// MaterialAt returns the material of p at a point on its surface, with any
+// texture applied.
+func MaterialAt(p Primitive, point glm.Vec3) Material {
+  mat := p.GetMaterial()
+  if s, ok := p.(Shaded); ok {
+    mat = s.MaterialAt(point)
+  }
+  if s, ok := p.(Surface); ok && mat.Texture != nil {
+    c := mat.Texture.Lookup(s.UV(point))
+    for i, t := range c.Elem {
+      mat.Ambient.Elem[i] *= t
+      mat.Diffuse.Elem[i] *= t
+    }
+  }
+  return mat
+}
+
+/**
+ * Orthonormal tangent vectors for a unit normal n.
+ */
+func orthonormal(n glm.Vec3) (t, b glm.Vec3) {
+  if math.Abs(n.Elem[0]) > 0.9 {
+    t = *glm.NewVec3(0, 1, 0).Cross(&n)
+  } else {
+    t = *glm.NewVec3(1, 0, 0).Cross(&n)
+  }
+  t.Normalize()
+  b = *n.Cross(&t)
+  return
+}
+
+func unit(v glm.Vec3) glm.Vec3 {
+  v.Normalize()
+  return v
+}
+
+// facing flips normal to face against ray, for primitives with no inside.
+func facing(normal, ray glm.Vec3) glm.Vec3 {
+  if normal.Dot(&ray) > 0 {
+    return *normal.Scale(-1)
+  }
+  return normal
+}
+
+// ascendingRoots returns the real roots of At^2 + Bt + C in ascending order.
+// A vanishing quadratic term leaves the one root of Bt + C, as for rays
+// parallel to a cone's slant; one that is merely small, as for short rays,
+// does not.
+func ascendingRoots(A, B, C float64) []float64 {
+  roots := quadraticRoots(A, B, C)
+  sort.Float64s(roots)
+  return roots
+}
+
+func vecMin(a, b glm.Vec3) glm.Vec3 {
+  return *glm.NewVec3(math.Min(a.Elem[0], b.Elem[0]), math.Min(a.Elem[1], b.Elem[1]), math.Min(a.Elem[2], b.Elem[2]))
+}
+
+func vecMax(a, b glm.Vec3) glm.Vec3 {
+  return *glm.NewVec3(math.Max(a.Elem[0], b.Elem[0]), math.Max(a.Elem[1], b.Elem[1]), math.Max(a.Elem[2], b.Elem[2]))
+}
+
+// PLANE PRIMITIVES
+
+// A Plane is infinite, passing through Pos with the given Normal. Its UVs are
+// world distances along two tangents.
+type Plane struct {
+  Pos glm.Vec3
+  Normal glm.Vec3
+  Mat Material
+}
+
+func (p Plane) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  denom := p.Normal.Dot(&ray)
+  if math.Abs(denom) < Epsilon {
+    return false, 0, normal
+  }
+  raylen = p.Pos.Subtract(&origin).Dot(&p.Normal) / denom
+  if raylen <= 0 {
+    return false, 0, normal
+  }
+  return true, raylen, facing(p.Normal, ray)
+}
+
+// HitPoint projects the hit back onto the plane, so that its error is that of
+// the projection rather than of raylen.
+func (p Plane) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  n := unit(p.Normal)
+  q := *origin.Add(ray.Scale(raylen))
+  h := Hit{Point: *q.Subtract(n.Scale(q.Subtract(&p.Pos).Dot(&n))), Normal: normal}
+  for i := range h.Error.Elem {
+    h.Error.Elem[i] = Gamma(8) * (math.Abs(p.Pos.Elem[i]) + math.Abs(q.Elem[i]))
+  }
+  return h
+}
+
+func (p Plane) Bounds() AABB {
+  inf := math.Inf(1)
+  return AABB{*glm.NewVec3(-inf, -inf, -inf), *glm.NewVec3(inf, inf, inf), p.Mat}
+}
+
+func (p Plane) UV(point glm.Vec3) (u, v float64) {
+  t, b := orthonormal(unit(p.Normal))
+  d := point.Subtract(&p.Pos)
+  return d.Dot(&t), d.Dot(&b)
+}
+
+func (p Plane) GetMaterial() Material {
+  return p.Mat
+}
+
+// DISK PRIMITIVES
+
+// A Disk is a circle of radius Rad around Pos. u is the angle around Normal,
+// v the distance from the centre, both in [0,1].
+type Disk struct {
+  Pos glm.Vec3
+  Normal glm.Vec3
+  Rad float64
+  Mat Material
+}
+
+func (p Disk) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  if b, raylen, normal = (Plane{p.Pos, p.Normal, p.Mat}).Intersect(ray, origin); !b {
+    return
+  }
+  if d := origin.Add(ray.Scale(raylen)).Subtract(&p.Pos); d.Dot(d) > p.Rad*p.Rad {
+    return false, 0, glm.Vec3{}
+  }
+  return
+}
+
+func (p Disk) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  return Plane{p.Pos, p.Normal, p.Mat}.HitPoint(ray, origin, raylen, normal)
+}
+
+func (p Disk) Bounds() AABB {
+  // extent of a disk along each axis is Rad*sqrt(1 - n_i^2).
+  n := unit(p.Normal)
+  e := glm.Vec3{}
+  for i := range e.Elem {
+    e.Elem[i] = p.Rad * math.Sqrt(math.Max(0, 1 - n.Elem[i]*n.Elem[i]))
+  }
+  return AABB{*p.Pos.Subtract(&e), *p.Pos.Add(&e), p.Mat}
+}
+
+func (p Disk) UV(point glm.Vec3) (u, v float64) {
+  t, b := orthonormal(unit(p.Normal))
+  d := point.Subtract(&p.Pos)
+  u = (math.Atan2(d.Dot(&b), d.Dot(&t)) + math.Pi) / (2*math.Pi)
+  return u, d.Length() / p.Rad
+}
+
+func (p Disk) GetMaterial() Material {
+  return p.Mat
+}
+
+// TRIANGLE PRIMITIVES
+
+// A Triangle's UVs are the barycentric weights of its second and third
+// vertices.
+type Triangle struct {
+  Verts [3]glm.Vec3
+  Mat Material
+}
+
+// A shearedRay is a ray set up for the watertight triangle test of Woop,
+// Benthin and Wald: axes are permuted so z is the ray's largest component,
+// then sheared so the ray points along +z. Rays through a shared edge or
+// vertex then hit at least one of the triangles around it, never slipping
+// between them. Build one per ray and test each triangle with hit.
+type shearedRay struct {
+  origin glm.Vec3
+  kx, ky, kz int
+  sx, sy, sz float64
+}
+
+func newShearedRay(ray, origin glm.Vec3) *shearedRay {
+  r := &shearedRay{origin: origin, kz: 2}
+  for i := 0; i < 2; i++ {
+    if math.Abs(ray.Elem[i]) > math.Abs(ray.Elem[r.kz]) {
+      r.kz = i
+    }
+  }
+  r.kx, r.ky = (r.kz+1)%3, (r.kz+2)%3
+  // keep the winding of the triangles.
+  if ray.Elem[r.kz] < 0 {
+    r.kx, r.ky = r.ky, r.kx
+  }
+  r.sz = 1 / ray.Elem[r.kz]
+  r.sx = ray.Elem[r.kx] * r.sz
+  r.sy = ray.Elem[r.ky] * r.sz
+  return r
+}
+
+// hit finds where the ray's line crosses triangle abc, as a ray length and
+// the barycentric weights of a, b and c.
+func (r *shearedRay) hit(a, b, c glm.Vec3) (bool, float64, [3]float64) {
+  var x, y, z [3]float64
+  for i, v := range [3]*glm.Vec3{&a, &b, &c} {
+    d := v.Subtract(&r.origin)
+    x[i] = d.Elem[r.kx] - r.sx*d.Elem[r.kz]
+    y[i] = d.Elem[r.ky] - r.sy*d.Elem[r.kz]
+    z[i] = r.sz * d.Elem[r.kz]
+  }
+  // scaled barycentrics: each edge's side of the ray.
+  u := x[2]*y[1] - y[2]*x[1]
+  v := x[0]*y[2] - y[0]*x[2]
+  w := x[1]*y[0] - y[1]*x[0]
+  if (u < 0 || v < 0 || w < 0) && (u > 0 || v > 0 || w > 0) {
+    return false, 0, [3]float64{}
+  }
+  det := u + v + w
+  if det == 0 {
+    return false, 0, [3]float64{}
+  }
+  return true, (u*z[0] + v*z[1] + w*z[2]) / det, [3]float64{u / det, v / det, w / det}
+}
+
+// barycentric intersects a ray with the triangle, returning the weights of
+// its second and third vertices.
+func (p Triangle) barycentric(ray, origin glm.Vec3) (b bool, raylen, u, v float64) {
+  b, raylen, w := newShearedRay(ray, origin).hit(p.Verts[0], p.Verts[1], p.Verts[2])
+  return b && raylen > 0, raylen, w[1], w[2]
+}
+
+func (p Triangle) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  if b, raylen, _, _ = p.barycentric(ray, origin); !b {
+    return false, 0, normal
+  }
+  e1 := p.Verts[1].Subtract(&p.Verts[0])
+  e2 := p.Verts[2].Subtract(&p.Verts[0])
+  return true, raylen, facing(*e1.Cross(e2), ray)
+}
+
+// HitPoint rebuilds the hit from the corners rather than the ray.
+func (p Triangle) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  _, _, w := newShearedRay(ray, origin).hit(p.Verts[0], p.Verts[1], p.Verts[2])
+  return weighted(p.Verts, w)
+}
+
+func (p Triangle) Bounds() AABB {
+  return AABB{p.Verts[0], p.Verts[0], p.Mat}.Grow(p.Verts[1]).Grow(p.Verts[2])
+}
+
+func (p Triangle) UV(point glm.Vec3) (u, v float64) {
+  e1 := p.Verts[1].Subtract(&p.Verts[0])
+  e2 := p.Verts[2].Subtract(&p.Verts[0])
+  d := point.Subtract(&p.Verts[0])
+  d11, d12, d22 := e1.Dot(e1), e1.Dot(e2), e2.Dot(e2)
+  d1, d2 := d.Dot(e1), d.Dot(e2)
+  denom := d11*d22 - d12*d12
+  return (d22*d1 - d12*d2) / denom, (d11*d2 - d12*d1) / denom
+}
+
+func (p Triangle) GetMaterial() Material {
+  return p.Mat
+}
+
+// CYLINDER AND CONE PRIMITIVES
+
+// A Cylinder runs from the centre of its base, Base, along Axis, whose length
+// is the height. u is the angle around the axis and v the height, in [0,1].
+type Cylinder struct {
+  Base glm.Vec3
+  Axis glm.Vec3
+  Rad float64
+  Capped bool
+  Mat Material
+}
+
+// A Cone has a base of radius Rad centred on Base and its apex at Base+Axis.
+type Cone struct {
+  Base glm.Vec3
+  Axis glm.Vec3
+  Rad float64
+  Capped bool
+  Mat Material
+}
+
+// axisFrame splits ray and origin (relative to base) into their components
+// along and across the unit axis a.
+func axisFrame(ray, origin, base, a glm.Vec3) (d_perp, o_perp glm.Vec3, d_a, o_a float64) {
+  o := origin.Subtract(&base)
+  d_a, o_a = ray.Dot(&a), o.Dot(&a)
+  d_perp = *ray.Subtract(a.Scale(d_a))
+  o_perp = *o.Subtract(a.Scale(o_a))
+  return
+}
+
+// onAxis rebuilds a hit on a cylinder or cone running from base along unit
+// axis a for height h: side hits are moved out to the radius the surface has
+// at their height, and cap hits onto the cap.
+func onAxis(point, base, a glm.Vec3, h float64, radius func(z float64) float64, normal glm.Vec3) Hit {
+  q := *point.Subtract(&base)
+  z := q.Dot(&a)
+  perp := *q.Subtract(a.Scale(z))
+  n := unit(normal)
+  if c := n.Dot(&a); math.Abs(c) > 1 - 1e-9 {
+    z = 0
+    if c > 0 {
+      z = h
+    }
+  } else if l := perp.Length(); l > 0 {
+    perp.Iscale(radius(z) / l)
+  }
+  hit := Hit{Point: *base.Add(a.Scale(z)).Add(&perp), Normal: normal}
+  size := q.Length()
+  for i := range hit.Error.Elem {
+    hit.Error.Elem[i] = Gamma(8)*size + Gamma(2)*math.Abs(base.Elem[i])
+  }
+  return hit
+}
+
+// closest keeps the nearest valid hit seen so far.
+func closest(b bool, raylen float64, normal glm.Vec3, t float64, n glm.Vec3) (bool, float64, glm.Vec3) {
+  if t > 0 && (!b || t < raylen) {
+    return true, t, n
+  }
+  return b, raylen, normal
+}
+
+// capHit intersects the ray with the disk of radius rad at height h along a.
+func capHit(d_perp, o_perp glm.Vec3, d_a, o_a, h, rad float64) (float64, bool) {
+  if d_a == 0 {
+    return 0, false
+  }
+  t := (h - o_a) / d_a
+  q := o_perp.Add(d_perp.Scale(t))
+  return t, q.Dot(q) <= rad*rad
+}
+
+func (p Cylinder) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  h := p.Axis.Length()
+  a := *p.Axis.Scale(1/h)
+  d_perp, o_perp, d_a, o_a := axisFrame(ray, origin, p.Base, a)
+  for _, t := range ascendingRoots(d_perp.Dot(&d_perp), 2*o_perp.Dot(&d_perp), o_perp.Dot(&o_perp) - p.Rad*p.Rad) {
+    if z := o_a + t*d_a; z >= 0 && z <= h {
+      n := *o_perp.Add(d_perp.Scale(t))
+      if !p.Capped {
+        n = facing(n, ray)
+      }
+      b, raylen, normal = closest(b, raylen, normal, t, n)
+    }
+  }
+  if p.Capped {
+    if t, ok := capHit(d_perp, o_perp, d_a, o_a, 0, p.Rad); ok {
+      b, raylen, normal = closest(b, raylen, normal, t, *a.Scale(-1))
+    }
+    if t, ok := capHit(d_perp, o_perp, d_a, o_a, h, p.Rad); ok {
+      b, raylen, normal = closest(b, raylen, normal, t, a)
+    }
+  }
+  return
+}
+
+func (p Cylinder) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  h := p.Axis.Length()
+  radius := func(z float64) float64 { return p.Rad }
+  return onAxis(*origin.Add(ray.Scale(raylen)), p.Base, *p.Axis.Scale(1/h), h, radius, normal)
+}
+
+func (p Cylinder) Bounds() AABB {
+  base := Disk{p.Base, p.Axis, p.Rad, p.Mat}
+  top := Disk{*p.Base.Add(&p.Axis), p.Axis, p.Rad, p.Mat}
+  return base.Bounds().Union(top.Bounds())
+}
+
+func (p Cylinder) UV(point glm.Vec3) (u, v float64) {
+  return axisUV(point, p.Base, p.Axis)
+}
+
+func (p Cylinder) GetMaterial() Material {
+  return p.Mat
+}
+
+// axisUV parameterises point by its angle around, and height along, axis.
+func axisUV(point, base, axis glm.Vec3) (u, v float64) {
+  h := axis.Length()
+  a := *axis.Scale(1/h)
+  t, b := orthonormal(a)
+  d := point.Subtract(&base)
+  u = (math.Atan2(d.Dot(&b), d.Dot(&t)) + math.Pi) / (2*math.Pi)
+  return u, d.Dot(&a) / h
+}
+
+func (p Cone) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  h := p.Axis.Length()
+  a := *p.Axis.Scale(1/h)
+  k := p.Rad / h
+  d_perp, o_perp, d_a, o_a := axisFrame(ray, origin, p.Base, a)
+  // |q_perp|^2 = k^2 (h - q.a)^2 along q = o + td.
+  A := d_perp.Dot(&d_perp) - k*k*d_a*d_a
+  B := 2*(o_perp.Dot(&d_perp) + k*k*(h - o_a)*d_a)
+  C := o_perp.Dot(&o_perp) - k*k*(h - o_a)*(h - o_a)
+  for _, t := range ascendingRoots(A, B, C) {
+    if z := o_a + t*d_a; z >= 0 && z <= h {
+      radial := unit(*o_perp.Add(d_perp.Scale(t)))
+      n := *radial.Add(a.Scale(k))
+      if !p.Capped {
+        n = facing(n, ray)
+      }
+      b, raylen, normal = closest(b, raylen, normal, t, n)
+    }
+  }
+  if p.Capped {
+    if t, ok := capHit(d_perp, o_perp, d_a, o_a, 0, p.Rad); ok {
+      b, raylen, normal = closest(b, raylen, normal, t, *a.Scale(-1))
+    }
+  }
+  return
+}
+
+func (p Cone) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  h := p.Axis.Length()
+  radius := func(z float64) float64 { return math.Max(0, p.Rad*(1 - z/h)) }
+  return onAxis(*origin.Add(ray.Scale(raylen)), p.Base, *p.Axis.Scale(1/h), h, radius, normal)
+}
+
+func (p Cone) Bounds() AABB {
+  return (Disk{p.Base, p.Axis, p.Rad, p.Mat}).Bounds().Grow(*p.Base.Add(&p.Axis))
+}
+
+func (p Cone) UV(point glm.Vec3) (u, v float64) {
+  return axisUV(point, p.Base, p.Axis)
+}
+
+func (p Cone) GetMaterial() Material {
+  return p.Mat
+}
@@ -0,0 +1,219 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+func vec(x, y, z float64) glm.Vec3 {
+  return *glm.NewVec3(x, y, z)
+}
+
+func near(a, b float64) bool {
+  return a == b || math.Abs(a - b) <= 1e-9*math.Max(1, math.Abs(b))
+}
+
+func nearVec(a, b glm.Vec3) bool {
+  return near(a.Elem[0], b.Elem[0]) && near(a.Elem[1], b.Elem[1]) && near(a.Elem[2], b.Elem[2])
+}
+
+// ANALYTIC HITS
+
+var analyticHits = []struct {
+  name string
+  prim Primitive
+  ray, origin glm.Vec3
+  hit bool
+  raylen float64
+  // compared as directions.
+  normal glm.Vec3
+  u, v float64
+}{
+  {"plane from above", Plane{vec(0, -1, 0), vec(0, 1, 0), Material{}}, vec(0, -1, 0), vec(2, 3, 5), true, 4, vec(0, 1, 0), 5, 2},
+  {"plane long ray", Plane{vec(0, -1, 0), vec(0, 1, 0), Material{}}, vec(0, -2, 0), vec(2, 3, 5), true, 2, vec(0, 1, 0), 5, 2},
+  {"plane from below", Plane{vec(0, -1, 0), vec(0, 1, 0), Material{}}, vec(0, 1, 0), vec(0, -3, 0), true, 2, vec(0, -1, 0), 0, 0},
+  {"plane parallel", Plane{vec(0, -1, 0), vec(0, 1, 0), Material{}}, vec(1, 0, 0), vec(0, 3, 0), false, 0, glm.Vec3{}, 0, 0},
+  {"plane behind", Plane{vec(0, -1, 0), vec(0, 1, 0), Material{}}, vec(0, 1, 0), vec(0, 3, 0), false, 0, glm.Vec3{}, 0, 0},
+
+  {"disk", Disk{vec(0, 0, 0), vec(0, 0, 1), 2, Material{}}, vec(0, 0, -1), vec(1, 0, 5), true, 5, vec(0, 0, 1), 0.75, 0.5},
+  {"disk outside", Disk{vec(0, 0, 0), vec(0, 0, 1), 2, Material{}}, vec(0, 0, -1), vec(3, 0, 5), false, 0, glm.Vec3{}, 0, 0},
+
+  {"triangle", Triangle{[3]glm.Vec3{vec(0, 0, 0), vec(1, 0, 0), vec(0, 1, 0)}, Material{}}, vec(0, 0, -1), vec(0.25, 0.5, 2), true, 2, vec(0, 0, 1), 0.25, 0.5},
+  {"triangle back", Triangle{[3]glm.Vec3{vec(0, 0, 0), vec(1, 0, 0), vec(0, 1, 0)}, Material{}}, vec(0, 0, 1), vec(0.25, 0.5, -2), true, 2, vec(0, 0, -1), 0.25, 0.5},
+  {"triangle outside", Triangle{[3]glm.Vec3{vec(0, 0, 0), vec(1, 0, 0), vec(0, 1, 0)}, Material{}}, vec(0, 0, -1), vec(0.6, 0.6, 2), false, 0, glm.Vec3{}, 0, 0},
+
+  {"cylinder side", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(-1, 0, 0), vec(5, 1, 0), true, 4, vec(1, 0, 0), 0.75, 0.5},
+  {"cylinder from inside", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(1, 0, 0), vec(0, 1, 0), true, 1, vec(-1, 0, 0), 0.75, 0.5},
+  {"cylinder down the axis", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(0, -1, 0), vec(0.5, 5, 0), false, 0, glm.Vec3{}, 0, 0},
+  {"cylinder above", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(-1, 0, 0), vec(5, 3, 0), false, 0, glm.Vec3{}, 0, 0},
+  {"capped cylinder side", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, true, Material{}}, vec(-1, 0, 0), vec(5, 1, 0), true, 4, vec(1, 0, 0), 0.75, 0.5},
+  {"capped cylinder from inside", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, true, Material{}}, vec(1, 0, 0), vec(0, 1, 0), true, 1, vec(1, 0, 0), 0.75, 0.5},
+  {"capped cylinder top", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, true, Material{}}, vec(0, -1, 0), vec(0.5, 5, 0), true, 3, vec(0, 1, 0), 0.75, 1},
+  {"capped cylinder bottom", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, true, Material{}}, vec(0, 2, 0), vec(0.5, -4, 0), true, 2, vec(0, -1, 0), 0.75, 0},
+
+  {"cone side", Cone{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(-1, 0, 0), vec(5, 1, 0), true, 4.5, vec(1, 0.5, 0), 0.75, 0.5},
+  {"cone along the slant", Cone{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(1, -2, 0), vec(-1, 2, 0), true, 0.5, vec(-1, 0.5, 0), 0.25, 0.5},
+  {"cone up the axis", Cone{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(0, 1, 0), vec(0.2, -3, 0), true, 4.6, vec(-1, -0.5, 0), 0.75, 0.8},
+  {"cone above", Cone{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(-1, 0, 0), vec(5, 2.5, 0), false, 0, glm.Vec3{}, 0, 0},
+  {"capped cone base", Cone{vec(0, 0, 0), vec(0, 2, 0), 1, true, Material{}}, vec(0, 1, 0), vec(0.2, -3, 0), true, 3, vec(0, -1, 0), 0.75, 0},
+}
+
+func TestAnalyticHits(t *testing.T) {
+  for _, c := range analyticHits {
+    hit, raylen, normal := c.prim.Intersect(c.ray, c.origin)
+    if hit != c.hit {
+      t.Errorf("%s: hit %v, want %v", c.name, hit, c.hit)
+      continue
+    }
+    if !hit {
+      continue
+    }
+    if !near(raylen, c.raylen) {
+      t.Errorf("%s: ray length %g, want %g", c.name, raylen, c.raylen)
+    }
+    if !nearVec(unit(normal), unit(c.normal)) {
+      t.Errorf("%s: normal %v, want %v", c.name, unit(normal), unit(c.normal))
+    }
+    point := *c.origin.Add(c.ray.Scale(raylen))
+    if u, v := c.prim.(Surface).UV(point); !near(u, c.u) || !near(v, c.v) {
+      t.Errorf("%s: uv %g,%g, want %g,%g", c.name, u, v, c.u, c.v)
+    }
+  }
+}
+
+func TestAnalyticBounds(t *testing.T) {
+  inf := math.Inf(1)
+  for _, c := range []struct {
+    name string
+    prim Bounded
+    min, max glm.Vec3
+  }{
+    {"plane", Plane{vec(0, -1, 0), vec(0, 1, 0), Material{}}, vec(-inf, -inf, -inf), vec(inf, inf, inf)},
+    {"disk", Disk{vec(1, 0, 0), vec(0, 0, 3), 2, Material{}}, vec(-1, -2, 0), vec(3, 2, 0)},
+    {"tilted disk", Disk{vec(0, 0, 0), vec(1, 1, 0), 2, Material{}}, vec(-math.Sqrt2, -math.Sqrt2, -2), vec(math.Sqrt2, math.Sqrt2, 2)},
+    {"triangle", Triangle{[3]glm.Vec3{vec(0, 0, 0), vec(1, 0, -1), vec(0, 1, 0)}, Material{}}, vec(0, 0, -1), vec(1, 1, 0)},
+    {"cylinder", Cylinder{vec(0, 0, 0), vec(0, 2, 0), 1, false, Material{}}, vec(-1, 0, -1), vec(1, 2, 1)},
+    {"cone", Cone{vec(0, 0, 0), vec(0, 2, 0), 1, true, Material{}}, vec(-1, 0, -1), vec(1, 2, 1)},
+  } {
+    b := c.prim.Bounds()
+    if !nearVec(b.Min, c.min) || !nearVec(b.Max, c.max) {
+      t.Errorf("%s: bounds %v to %v, want %v to %v", c.name, b.Min, b.Max, c.min, c.max)
+    }
+  }
+}
+
+// CRACKS
+
+// crackTargets lists the points where faces of m meet, each vertex and edge
+// midpoint, with the faces that meet there.
+func crackTargets(m *Mesh) (points []glm.Vec3, faces [][]int) {
+  around := make([][]int, len(m.Verts))
+  edges := map[[2]int][]int{}
+  for i, f := range m.Faces {
+    for k, v := range f {
+      around[v] = append(around[v], i)
+      e := [2]int{v, f[(k+1)%3]}
+      if e[0] > e[1] {
+        e[0], e[1] = e[1], e[0]
+      }
+      edges[e] = append(edges[e], i)
+    }
+  }
+  for v, fs := range around {
+    if len(fs) > 0 {
+      points = append(points, m.Verts[v])
+      faces = append(faces, fs)
+    }
+  }
+  for e, fs := range edges {
+    points = append(points, *m.Verts[e[0]].Add(&m.Verts[e[1]]).Iscale(0.5))
+    faces = append(faces, fs)
+  }
+  return
+}
+
+// checkCracks fires rays at every vertex and edge midpoint of the closed
+// mesh m, from a grid of directions outside it and a grid of points inside
+// it around centre, from which every point of the surface is in view. Rays
+// that cross all the faces meeting there, rather than grazing them, must
+// hit one of those faces, and the mesh no further away.
+func checkCracks(t *testing.T, name string, m *Mesh, centre glm.Vec3) {
+  size := m.Bound.Max.Subtract(&m.Bound.Min).Length()
+  tol := 1e-9*size
+  points, faces := crackTargets(m)
+  origins := func(target glm.Vec3) []glm.Vec3 {
+    out := []glm.Vec3{}
+    // offset from the axes so no ray lies in the plane of a box face.
+    for i := 0; i < 8; i++ {
+      for j := 0; j < 16; j++ {
+        theta, phi := math.Pi*(float64(i) + 0.5)/8, 2*math.Pi*(float64(j) + 0.37)/16
+        dir := vec(math.Sin(theta)*math.Cos(phi), math.Cos(theta), math.Sin(theta)*math.Sin(phi))
+        out = append(out, *target.Add(dir.Scale(2*size)))
+      }
+    }
+    for i := -1; i <= 1; i++ {
+      for j := -1; j <= 1; j++ {
+        for k := -1; k <= 1; k++ {
+          offset := vec(float64(i) + 0.13, float64(j) - 0.07, float64(k) + 0.05)
+          out = append(out, *centre.Add(offset.Scale(0.02*size)))
+        }
+      }
+    }
+    return out
+  }
+  fired, misses := 0, 0
+  for p, target := range points {
+    for _, origin := range origins(target) {
+      ray := *target.Subtract(&origin)
+      dist := ray.Length()
+      ray.Iscale(1 / dist)
+      if !crosses(m, faces[p], ray) {
+        continue
+      }
+      fired++
+      sheared := newShearedRay(ray, origin)
+      found := false
+      for _, f := range faces[p] {
+        if hit, raylen, _ := m.faceHit(f, sheared); hit && math.Abs(raylen - dist) <= tol {
+          found = true
+        }
+      }
+      hit, raylen, _ := m.Intersect(ray, origin)
+      if !found || !hit || raylen > dist + tol {
+        if misses++; misses <= 10 {
+          t.Errorf("%s: ray from %v to %v: faces there hit %v, mesh hit %v at %g of %g", name, origin, target, found, hit, raylen, dist)
+        }
+      }
+    }
+  }
+  if misses > 10 {
+    t.Errorf("%s: %d of %d rays slipped through", name, misses, fired)
+  }
+  if fired < 50*len(points) {
+    t.Errorf("%s: only %d rays at %d points", name, fired, len(points))
+  }
+}
+
+// crosses reports whether ray meets all the faces clearly from one side.
+func crosses(m *Mesh, faces []int, ray glm.Vec3) bool {
+  side := 0.0
+  for _, f := range faces {
+    n := unit(m.Normals[f])
+    d := n.Dot(&ray)
+    if math.Abs(d) < 0.05 || d*side < 0 {
+      return false
+    }
+    side = d
+  }
+  return true
+}
+
+func TestMeshCracks(t *testing.T) {
+  sphere := Sphere{vec(0.3, -1.7, 2.9), 1.3, Material{}}
+  checkCracks(t, "sphere", sphereMesh(sphere, 8), sphere.Pos)
+  box := AABB{vec(-1, -2, -3), vec(2, 1, 0.5), Material{}}
+  checkCracks(t, "box", boxMesh(box), *box.Min.Add(&box.Max).Iscale(0.5))
+  checkCracks(t, "steldodec", steldodec, *steldodec.Bound.Min.Add(&steldodec.Bound.Max).Iscale(0.5))
+}
@@ -0,0 +1,257 @@
+package scene
+
+import (
+	"fmt"
+	"math"
+
+	"gray/glm"
+)
+
+// TORUS PRIMITIVES
+
+// A Torus lies in the plane through Pos normal to Axis. Major is the radius
+// of the ring and Minor that of the tube. u runs around the ring and v
+// around the tube.
+type Torus struct {
+  Pos glm.Vec3
+  Axis glm.Vec3
+  Major, Minor float64
+  Mat Material
+}
+
+// local returns the torus frame: two tangents and the unit axis.
+func (p Torus) local() (t, b, a glm.Vec3) {
+  a = unit(p.Axis)
+  t, b = orthonormal(a)
+  return
+}
+
+func toFrame(v, t, b, a glm.Vec3) glm.Vec3 {
+  return *glm.NewVec3(v.Dot(&t), v.Dot(&b), v.Dot(&a))
+}
+
+func fromFrame(v, t, b, a glm.Vec3) glm.Vec3 {
+  return *t.Scale(v.Elem[0]).Add(b.Scale(v.Elem[1])).Add(a.Scale(v.Elem[2]))
+}
+
+// startNear moves origin along the unit direction d up to the sphere of
+// radius rad around centre, so that the polynomial is solved with small
+// coefficients. It returns the new origin and the distance moved.
+func startNear(origin, d, centre glm.Vec3, rad float64) (glm.Vec3, float64) {
+  t0 := math.Max(0, centre.Subtract(&origin).Dot(&d) - rad)
+  return *origin.Add(d.Scale(t0)), t0
+}
+
+func (p Torus) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  t, bt, a := p.local()
+  scale := ray.Length()
+  d := toFrame(*ray.Scale(1/scale), t, bt, a)
+  size := p.Major + p.Minor
+  o, t0 := startNear(toFrame(*origin.Subtract(&p.Pos), t, bt, a), d, glm.Vec3{}, size)
+  // solve for a torus of unit size, as the root finder's tolerances expect.
+  o.Iscale(1 / size)
+  // (|p|^2 - R^2 - r^2)^2 = 4R^2(r^2 - z^2) along p = o + sd.
+  R2, r2 := p.Major*p.Major/(size*size), p.Minor*p.Minor/(size*size)
+  f := o.Dot(&d)
+  e := o.Dot(&o) - R2 - r2
+  roots := quarticRoots(1, 4*f, 2*e + 4*f*f + 4*R2*d.Elem[2]*d.Elem[2],
+    4*f*e + 8*R2*o.Elem[2]*d.Elem[2], e*e - 4*R2*(r2 - o.Elem[2]*o.Elem[2]))
+  for _, s := range roots {
+    if raylen = (t0 + s*size) / scale; raylen > 0 {
+      q := o.Add(d.Scale(s))
+      grad := *q.Scale(4*(q.Dot(q) - R2 - r2))
+      grad.Elem[2] += 8*R2*q.Elem[2]
+      return true, raylen, fromFrame(grad, t, bt, a)
+    }
+  }
+  return false, 0, normal
+}
+
+// HitPoint moves the hit onto the tube, out from the nearest point on the
+// ring.
+func (p Torus) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  t, b, a := p.local()
+  q := toFrame(*origin.Add(ray.Scale(raylen)).Subtract(&p.Pos), t, b, a)
+  ring := *glm.NewVec3(q.Elem[0], q.Elem[1], 0)
+  if l := ring.Length(); l > 0 {
+    ring.Iscale(p.Major / l)
+  }
+  tube := *q.Subtract(&ring)
+  if l := tube.Length(); l > 0 {
+    tube.Iscale(p.Minor / l)
+  }
+  w := fromFrame(*ring.Add(&tube), t, b, a)
+  h := Hit{Point: *p.Pos.Add(&w), Normal: normal}
+  for i := range h.Error.Elem {
+    h.Error.Elem[i] = Gamma(8)*(p.Major + p.Minor) + Gamma(2)*math.Abs(p.Pos.Elem[i])
+  }
+  return h
+}
+
+func (p Torus) Bounds() AABB {
+  a := unit(p.Axis)
+  top := Disk{*p.Pos.Add(a.Scale(p.Minor)), a, p.Major + p.Minor, p.Mat}
+  bottom := Disk{*p.Pos.Subtract(a.Scale(p.Minor)), a, p.Major + p.Minor, p.Mat}
+  return top.Bounds().Union(bottom.Bounds())
+}
+
+func (p Torus) UV(point glm.Vec3) (u, v float64) {
+  t, b, a := p.local()
+  q := toFrame(*point.Subtract(&p.Pos), t, b, a)
+  u = (math.Atan2(q.Elem[1], q.Elem[0]) + math.Pi) / (2*math.Pi)
+  ring := math.Hypot(q.Elem[0], q.Elem[1]) - p.Major
+  v = (math.Atan2(q.Elem[2], ring) + math.Pi) / (2*math.Pi)
+  return
+}
+
+func (p Torus) GetMaterial() Material {
+  return p.Mat
+}
+
+// QUARTIC PRIMITIVES
+
+// QUARTIC_NEWTON_STEPS bounds the steps taken to move a hit onto the surface.
+const QUARTIC_NEWTON_STEPS = 4
+
+// A QuarticTerm is Coef * x^I * y^J * z^K.
+type QuarticTerm struct {
+  Coef float64
+  I, J, K int
+}
+
+// A Quartic is the implicit surface where its terms, of total degree at most
+// four in coordinates relative to Pos, sum to zero. Only the part inside
+// Bound (also relative to Pos) is rendered.
+type Quartic struct {
+  Pos glm.Vec3
+  Terms []QuarticTerm
+  Bound AABB
+  Mat Material
+}
+
+// Validate checks that every term has non-negative exponents and degree at
+// most four.
+func (p Quartic) Validate() error {
+  for _, t := range p.Terms {
+    if t.I < 0 || t.J < 0 || t.K < 0 || t.I + t.J + t.K > 4 {
+      return fmt.Errorf("quartic term %+v is not of degree 0 to 4", t)
+    }
+  }
+  return nil
+}
+
+// power returns the coefficients of (o + td)^n.
+func power(o, d float64, n int) []float64 {
+  r := []float64{1}
+  for i := 0; i < n; i++ {
+    r = polyMul(r, []float64{o, d})
+  }
+  return r
+}
+
+func (p Quartic) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  scale := ray.Length()
+  d := *ray.Scale(1/scale)
+  o := *origin.Subtract(&p.Pos)
+  near, far, ok := p.Bound.Span(d, o)
+  if !ok || far < 0 {
+    return false, 0, normal
+  }
+  // solve from where the ray enters the bound.
+  t0 := math.Max(0, near)
+  o.Iadd(d.Scale(t0))
+  c := make([]float64, 5)
+  for _, term := range p.Terms {
+    tp := polyMul(polyMul(power(o.Elem[0], d.Elem[0], term.I), power(o.Elem[1], d.Elem[1], term.J)), power(o.Elem[2], d.Elem[2], term.K))
+    for i, x := range tp {
+      c[i] += term.Coef * x
+    }
+  }
+  // solve in units of the bound's size, so that the degree found does not
+  // depend on the scale.
+  size := p.Bound.Max.Subtract(&p.Bound.Min).Length()
+  if size > 0 && !math.IsInf(size, 0) {
+    for i := range c {
+      c[i] *= math.Pow(size, float64(i))
+    }
+  } else {
+    size = 1
+  }
+  for _, u := range polyRoots(c) {
+    s := u*size
+    if s + t0 > far {
+      break
+    }
+    if raylen = (t0 + s) / scale; raylen > 0 && s + t0 >= near {
+      return true, raylen, p.gradient(*o.Add(d.Scale(s)))
+    }
+  }
+  return false, 0, normal
+}
+
+// value returns the sum of the terms at q, and the sum of their magnitudes,
+// which bounds its rounding error.
+func (p Quartic) value(q glm.Vec3) (f, mag float64) {
+  for _, t := range p.Terms {
+    x := t.Coef * math.Pow(q.Elem[0], float64(t.I)) * math.Pow(q.Elem[1], float64(t.J)) * math.Pow(q.Elem[2], float64(t.K))
+    f += x
+    mag += math.Abs(x)
+  }
+  return
+}
+
+// HitPoint takes Newton steps from the hit towards the surface, then bounds
+// its distance from it by the residual over the gradient, doubled as the
+// surface curves away from its tangent plane.
+func (p Quartic) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  q := *origin.Add(ray.Scale(raylen)).Subtract(&p.Pos)
+  f, mag := p.value(q)
+  for i := 0; i < QUARTIC_NEWTON_STEPS; i++ {
+    grad := p.gradient(q)
+    g2 := grad.Dot(&grad)
+    if g2 == 0 {
+      break
+    }
+    next := *q.Subtract(grad.Scale(f / g2))
+    nf, nmag := p.value(next)
+    if math.Abs(nf) >= math.Abs(f) {
+      break
+    }
+    q, f, mag = next, nf, nmag
+  }
+  grad := p.gradient(q)
+  g := grad.Length()
+  if g == 0 {
+    return alongRay(ray, origin, raylen, 16, normal)
+  }
+  dist := 2 * (math.Abs(f) + Gamma(16)*mag) / g
+  h := Hit{Point: *q.Add(&p.Pos), Normal: normal}
+  for i := range h.Error.Elem {
+    h.Error.Elem[i] = dist + Gamma(2)*(math.Abs(p.Pos.Elem[i]) + math.Abs(q.Elem[i]))
+  }
+  return h
+}
+
+func (p Quartic) gradient(q glm.Vec3) (grad glm.Vec3) {
+  pow := func(x float64, n int) float64 {
+    if n < 0 {
+      return 0
+    }
+    return math.Pow(x, float64(n))
+  }
+  x, y, z := q.Elem[0], q.Elem[1], q.Elem[2]
+  for _, t := range p.Terms {
+    grad.Elem[0] += t.Coef * float64(t.I) * pow(x, t.I-1) * pow(y, t.J) * pow(z, t.K)
+    grad.Elem[1] += t.Coef * float64(t.J) * pow(x, t.I) * pow(y, t.J-1) * pow(z, t.K)
+    grad.Elem[2] += t.Coef * float64(t.K) * pow(x, t.I) * pow(y, t.J) * pow(z, t.K-1)
+  }
+  return
+}
+
+func (p Quartic) Bounds() AABB {
+  return AABB{*p.Bound.Min.Add(&p.Pos), *p.Bound.Max.Add(&p.Pos), p.Mat}
+}
+
+func (p Quartic) GetMaterial() Material {
+  return p.Mat
+}
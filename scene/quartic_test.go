@@ -0,0 +1,130 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+// GRAZING RAYS
+
+type grazingCase struct {
+  name string
+  ray, origin glm.Vec3
+  hit bool
+  raylen, tol float64
+}
+
+// torusCases graze a torus around the z axis with radii 2 and 0.5: along
+// the top of the tube, which it touches at x = -2 and 2, and along the
+// outer equator, which it touches at y = 0.
+func torusCases() []grazingCase {
+  // a line at height 0.5-d crosses the tube where the ring is this far out.
+  chord := func(d float64) float64 {
+    return math.Sqrt(0.25 - (0.5 - d)*(0.5 - d))
+  }
+  return []grazingCase{
+    {"through the tube", vec(0, 0, -1), vec(2, 0, 5), true, 4.5, 1e-9},
+    {"through the tube, long ray", vec(0, 0, -2), vec(2, 0, 5), true, 2.25, 1e-9},
+    {"tangent on top", vec(1, 0, 0), vec(-5, 0, 0.5), true, 3, 1e-6},
+    {"tangent on top, from inside the ring", vec(1, 0, 0), vec(0, 0, 0.5), true, 2, 1e-6},
+    {"tangent to the equator", vec(0, 1, 0), vec(2.5, -5, 0), true, 5, 1e-6},
+    {"grazing inside the top", vec(1, 0, 0), vec(-5, 0, 0.5 - 1e-6), true, 3 - chord(1e-6), 1e-6},
+    {"grazing further inside", vec(1, 0, 0), vec(-5, 0, 0.5 - 1e-3), true, 3 - chord(1e-3), 1e-9},
+    {"grazing inside the equator", vec(0, 1, 0), vec(2.5 - 1e-6, -5, 0), true, 5 - math.Sqrt(2.5*2.5 - (2.5 - 1e-6)*(2.5 - 1e-6)), 1e-6},
+    {"missing the top", vec(1, 0, 0), vec(-5, 0, 0.5 + 1e-6), false, 0, 0},
+    {"missing the equator", vec(0, 1, 0), vec(2.5 + 1e-6, -5, 0), false, 0, 0},
+    {"clear of the top", vec(1, 0, 0), vec(-5, 0, 0.5 + 1e-3), false, 0, 0},
+  }
+}
+
+// torusNormal is the direction of the torus's normal at p.
+func torusNormal(p glm.Vec3) glm.Vec3 {
+  ring := vec(p.Elem[0], p.Elem[1], 0)
+  ring.Iscale(2 / ring.Length())
+  return unit(*p.Subtract(&ring))
+}
+
+func checkGrazing(t *testing.T, prim Primitive, cases []grazingCase, normalAt func(p glm.Vec3) glm.Vec3) {
+  for _, c := range cases {
+    hit, raylen, normal := prim.Intersect(c.ray, c.origin)
+    if hit != c.hit {
+      t.Errorf("%s: hit %v at %g, want %v", c.name, hit, raylen, c.hit)
+      continue
+    }
+    if !hit {
+      continue
+    }
+    if math.Abs(raylen - c.raylen) > c.tol {
+      t.Errorf("%s: ray length %.12g, want %.12g", c.name, raylen, c.raylen)
+    }
+    want := normalAt(*c.origin.Add(c.ray.Scale(c.raylen)))
+    if got := unit(normal); got.Dot(&want) < 1 - 1e-6 {
+      t.Errorf("%s: normal %v, want %v", c.name, got, want)
+    }
+  }
+}
+
+func TestTorusGrazing(t *testing.T) {
+  torus := Torus{vec(0, 0, 0), vec(0, 0, 1), 2, 0.5, Material{}}
+  checkGrazing(t, torus, torusCases(), torusNormal)
+  // a ray just above the tube may count as touching it, but then where it
+  // first does so.
+  for _, d := range []float64{1e-12, 1e-9} {
+    if hit, raylen, _ := torus.Intersect(vec(1, 0, 0), vec(-5, 0, 0.5 + d)); hit && math.Abs(raylen - 3) > 1e-3 {
+      t.Errorf("%g above the tube: hit at %g, want 3 or none", d, raylen)
+    }
+  }
+}
+
+func TestQuarticGrazing(t *testing.T) {
+  // the same torus as a quartic: (|p|^2 + K)^2 - 16(x^2 + y^2), K = R^2 - r^2.
+  K := 3.75
+  torus := Quartic{vec(0, 0, 0), []QuarticTerm{
+    {1, 4, 0, 0}, {1, 0, 4, 0}, {1, 0, 0, 4}, {2, 2, 2, 0}, {2, 2, 0, 2}, {2, 0, 2, 2},
+    {2*K - 16, 2, 0, 0}, {2*K - 16, 0, 2, 0}, {2*K, 0, 0, 2}, {K*K, 0, 0, 0},
+  }, AABB{vec(-3, -3, -1), vec(3, 3, 1), Material{}}, Material{}}
+  checkGrazing(t, torus, torusCases(), torusNormal)
+
+  // x^4 + y^4 + z^4 = 1 is so flat where the axes cross it that rays along
+  // its faces touch it with a quadruple root.
+  cube := Quartic{vec(0, 0, 0), []QuarticTerm{{1, 4, 0, 0}, {1, 0, 4, 0}, {1, 0, 0, 4}, {-1, 0, 0, 0}},
+    AABB{vec(-2, -2, -2), vec(2, 2, 2), Material{}}, Material{}}
+  // a line at 1-d crosses the surface this far off the axis.
+  across := func(d float64) float64 {
+    return math.Pow(1 - math.Pow(1 - d, 4), 0.25)
+  }
+  checkGrazing(t, cube, []grazingCase{
+    {"through a face", vec(0, -1, 0), vec(0.5, 5, 0), true, 5 - math.Pow(1 - 0.0625, 0.25), 1e-9},
+    {"tangent to a face", vec(1, 0, 0), vec(-5, 1, 0), true, 5, 1e-2},
+    {"grazing inside a face", vec(1, 0, 0), vec(-5, 1 - 1e-6, 0), true, 5 - across(1e-6), 1e-6},
+    {"missing a face", vec(1, 0, 0), vec(-5, 1 + 1e-6, 0), false, 0, 0},
+  }, func(p glm.Vec3) glm.Vec3 {
+    return unit(vec(math.Pow(p.Elem[0], 3), math.Pow(p.Elem[1], 3), math.Pow(p.Elem[2], 3)))
+  })
+}
+
+func TestQuarticValidate(t *testing.T) {
+  bound := AABB{vec(-2, -2, -2), vec(2, 2, 2), Material{}}
+  for _, c := range []struct {
+    name string
+    terms []QuarticTerm
+    ok bool
+  }{
+    {"sphere", []QuarticTerm{{1, 2, 0, 0}, {1, 0, 2, 0}, {1, 0, 0, 2}, {-1, 0, 0, 0}}, true},
+    {"quartic", []QuarticTerm{{1, 2, 1, 1}, {-1, 0, 0, 0}}, true},
+    {"degree five", []QuarticTerm{{1, 5, 0, 0}, {-1, 0, 0, 0}}, false},
+    {"degree five across axes", []QuarticTerm{{1, 2, 2, 1}}, false},
+    {"negative exponent", []QuarticTerm{{1, 2, -1, 0}}, false},
+  } {
+    q := Quartic{vec(0, 0, 0), c.terms, bound, Material{}}
+    if err := q.Validate(); (err == nil) != c.ok {
+      t.Errorf("%s: Validate gave %v", c.name, err)
+    }
+    sc := &Scene{Primitives: []Primitive{Sphere{vec(0, 0, 0), 1, Material{}}, q}}
+    if err := sc.Validate(); (err == nil) != c.ok {
+      t.Errorf("%s: scene Validate gave %v", c.name, err)
+    }
+  }
+}
@@ -0,0 +1,320 @@
+package scene
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gray/glm"
+)
+
+// MESH REPAIR
+
+type MeshProblemKind int
+
+const (
+  // a face refers to a vertex that does not exist; the face is removed.
+  BadIndex MeshProblemKind = iota
+  // a face has no area, often after welding; the face is removed.
+  DegenerateFace
+  // more than two faces share an edge.
+  NonManifoldEdge
+  // two faces share an edge but run along it the same way, so one of them
+  // faces the wrong way.
+  InconsistentWinding
+)
+
+var meshProblemNames = [...]string{
+  "bad vertex index", "degenerate face", "non-manifold edge", "inconsistent winding",
+}
+
+func (k MeshProblemKind) String() string {
+  if k < 0 || int(k) >= len(meshProblemNames) {
+    return fmt.Sprintf("MeshProblemKind(%d)", int(k))
+  }
+  return meshProblemNames[k]
+}
+
+// A MeshProblem is one thing wrong with a mesh. Face is the index of the
+// face in the input, and Edge the vertex indices of the edge concerned, in
+// the repaired mesh; each is set only where it applies.
+type MeshProblem struct {
+  Kind MeshProblemKind
+  Face int
+  Edge [2]int
+}
+
+func (p MeshProblem) Error() string {
+  if p.Kind == NonManifoldEdge || p.Kind == InconsistentWinding {
+    return fmt.Sprintf("%v at edge %d-%d", p.Kind, p.Edge[0], p.Edge[1])
+  }
+  return fmt.Sprintf("%v at face %d", p.Kind, p.Face)
+}
+
+// A MeshReport lists what a repair found. Errors are problems in the input
+// data itself; Warnings are left for the caller to judge.
+type MeshReport struct {
+  Welded int
+  Errors, Warnings []MeshProblem
+}
+
+// Err returns the report's errors as one error, or nil.
+func (r MeshReport) Err() error {
+  if len(r.Errors) == 0 {
+    return nil
+  }
+  msgs := []string{}
+  for i, p := range r.Errors {
+    if i == 5 {
+      msgs = append(msgs, fmt.Sprintf("and %d more", len(r.Errors) - i))
+      break
+    }
+    msgs = append(msgs, p.Error())
+  }
+  return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
+
+// String summarises the report by kind of problem.
+func (r MeshReport) String() string {
+  counts := make([]int, len(meshProblemNames))
+  for _, p := range append(append([]MeshProblem{}, r.Errors...), r.Warnings...) {
+    counts[p.Kind]++
+  }
+  parts := []string{fmt.Sprintf("welded %d vertices", r.Welded)}
+  for k, n := range counts {
+    if n > 0 {
+      parts = append(parts, fmt.Sprintf("%d x %v", n, MeshProblemKind(k)))
+    }
+  }
+  return strings.Join(parts, ", ")
+}
+
+// A MeshRepair is a cleaned up vertex and face list. VertFrom and FaceFrom
+// give the input index of each vertex and face kept, so that per vertex
+// and per face data can follow.
+type MeshRepair struct {
+  Verts [][3]float64
+  Faces [][3]int
+  VertFrom, FaceFrom []int
+  Report MeshReport
+}
+
+// RepairFaces welds vertices within weld of each other (a negative weld
+// turns welding off), removes faces with bad indices or no area, drops
+// vertices no face uses, and reports non-manifold and inconsistently wound
+// edges.
+func RepairFaces(verts [][3]float64, faces [][3]int, weld float64) *MeshRepair {
+  return repairFaces(verts, faces, nil, weld)
+}
+
+// repairFaces is RepairFaces for vertices carrying attrs, such as colours
+// and texture coordinates, if not nil. Only vertices with equal attributes
+// are welded, so seams survive.
+func repairFaces(verts [][3]float64, faces [][3]int, attrs [][]float64, weld float64) *MeshRepair {
+  r := &MeshRepair{}
+  // rep maps each input vertex to the first vertex it was welded to.
+  rep := make([]int, len(verts))
+  for i := range rep {
+    rep[i] = i
+  }
+  if weld >= 0 {
+    r.Report.Welded = weldVerts(verts, attrs, weld, rep)
+  }
+
+  index := make([]int, len(verts))
+  for i := range index {
+    index[i] = -1
+  }
+  for i, f := range faces {
+    if !faceInRange(f, len(verts)) {
+      r.Report.Errors = append(r.Report.Errors, MeshProblem{Kind: BadIndex, Face: i})
+      continue
+    }
+    f = [3]int{rep[f[0]], rep[f[1]], rep[f[2]]}
+    if degenerate(verts[f[0]], verts[f[1]], verts[f[2]]) || f[0] == f[1] || f[1] == f[2] || f[2] == f[0] {
+      r.Report.Warnings = append(r.Report.Warnings, MeshProblem{Kind: DegenerateFace, Face: i})
+      continue
+    }
+    for k, v := range f {
+      if index[v] < 0 {
+        index[v] = len(r.Verts)
+        r.Verts = append(r.Verts, verts[v])
+        r.VertFrom = append(r.VertFrom, v)
+      }
+      f[k] = index[v]
+    }
+    r.Faces = append(r.Faces, f)
+    r.FaceFrom = append(r.FaceFrom, i)
+  }
+
+  // count the faces running each way along every edge.
+  type use struct{ forward, backward int }
+  edges := map[[2]int]*use{}
+  order := [][2]int{}
+  for _, f := range r.Faces {
+    for k := 0; k < 3; k++ {
+      a, b := f[k], f[(k+1)%3]
+      e := edgeKey(a, b)
+      u, ok := edges[e]
+      if !ok {
+        u = &use{}
+        edges[e] = u
+        order = append(order, e)
+      }
+      if a < b {
+        u.forward++
+      } else {
+        u.backward++
+      }
+    }
+  }
+  for _, e := range order {
+    switch u := edges[e]; {
+      case u.forward + u.backward > 2:
+        r.Report.Warnings = append(r.Report.Warnings, MeshProblem{Kind: NonManifoldEdge, Face: -1, Edge: e})
+      case u.forward == 2 || u.backward == 2:
+        r.Report.Warnings = append(r.Report.Warnings, MeshProblem{Kind: InconsistentWinding, Face: -1, Edge: e})
+    }
+  }
+  return r
+}
+
+// weldVerts points rep at the first earlier vertex within tol with the same
+// attrs, if any, using a grid of tol sized cells so only neighbouring cells
+// are searched. It returns the number of vertices welded.
+func weldVerts(verts [][3]float64, attrs [][]float64, tol float64, rep []int) int {
+  cell := func(v [3]float64) [3]int64 {
+    c := [3]int64{}
+    for i := range c {
+      if tol > 0 {
+        c[i] = int64(math.Floor(v[i] / tol))
+      } else if v[i] == 0 {
+        // -0 welds to 0.
+        c[i] = 0
+      } else {
+        c[i] = int64(math.Float64bits(v[i]))
+      }
+    }
+    return c
+  }
+  same := func(i, j int) bool {
+    if attrs == nil {
+      return true
+    }
+    for k := range attrs[i] {
+      if attrs[i][k] != attrs[j][k] {
+        return false
+      }
+    }
+    return true
+  }
+  grid := map[[3]int64][]int{}
+  welded := 0
+  for i, v := range verts {
+    c := cell(v)
+    found := -1
+    if tol > 0 {
+      for d := 0; d < 27 && found < 0; d++ {
+        n := [3]int64{c[0] + int64(d%3) - 1, c[1] + int64(d/3%3) - 1, c[2] + int64(d/9) - 1}
+        for _, j := range grid[n] {
+          a, b := glm.NewVec3(v[0], v[1], v[2]), glm.NewVec3(verts[j][0], verts[j][1], verts[j][2])
+          if a.Subtract(b).Length() <= tol && same(i, j) {
+            found = j
+            break
+          }
+        }
+      }
+    } else {
+      for _, j := range grid[c] {
+        if same(i, j) {
+          found = j
+          break
+        }
+      }
+    }
+    if found >= 0 {
+      rep[i] = found
+      welded++
+      continue
+    }
+    grid[c] = append(grid[c], i)
+  }
+  return welded
+}
+
+// degenerate reports a triangle whose area is zero to rounding error.
+func degenerate(a, b, c [3]float64) bool {
+  va, vb, vc := glm.NewVec3(a[0], a[1], a[2]), glm.NewVec3(b[0], b[1], b[2]), glm.NewVec3(c[0], c[1], c[2])
+  e1, e2, e3 := vb.Subtract(va), vc.Subtract(va), vc.Subtract(vb)
+  longest := math.Max(e1.Dot(e1), math.Max(e2.Dot(e2), e3.Dot(e3)))
+  cross := e1.Cross(e2)
+  return cross.Length() <= 1e-12*longest
+}
+
+// repairLoaded repairs a mesh read from file. Bad indices fail the load
+// and warnings are printed, as the loaders print their errors.
+func repairLoaded(file string, verts [][3]float64, faces [][3]int, weld float64) (*MeshRepair, error) {
+  r := RepairFaces(verts, faces, weld)
+  if err := r.Report.Err(); err != nil {
+    return nil, fmt.Errorf("%s: %v", file, err)
+  }
+  if len(r.Faces) == 0 {
+    return nil, fmt.Errorf("%s: no faces", file)
+  }
+  if len(r.Report.Warnings) > 0 {
+    fmt.Printf("%s: %v\n", file, r.Report)
+  }
+  return r, nil
+}
+
+// Repair welds, cleans and checks the mesh as RepairFaces does, carrying
+// its colours, texture coordinates and corner normals across. Vertices are
+// only welded where their colours and texture coordinates agree.
+func (p Mesh) Repair(weld float64) (*Mesh, MeshReport) {
+  verts := make([][3]float64, len(p.Verts))
+  for i, v := range p.Verts {
+    verts[i] = v.Elem
+  }
+  var attrs [][]float64
+  if p.Colours != nil || p.UVs != nil {
+    attrs = make([][]float64, len(p.Verts))
+    for i := range attrs {
+      if p.Colours != nil {
+        attrs[i] = append(attrs[i], p.Colours[i].Elem[:]...)
+      }
+      if p.UVs != nil {
+        attrs[i] = append(attrs[i], p.UVs[i][:]...)
+      }
+    }
+  }
+  r := repairFaces(verts, p.Faces, attrs, weld)
+  if len(r.Faces) == 0 {
+    return &Mesh{Mat: p.Mat}, r.Report
+  }
+  m := NewMesh(r.Verts, r.Faces, p.Mat)
+  m.copyAttributes(p, r)
+  return m, r.Report
+}
+
+// copyAttributes fills in m's per vertex and per corner data from the mesh
+// it was repaired from.
+func (m *Mesh) copyAttributes(from Mesh, r *MeshRepair) {
+  if from.Colours != nil {
+    m.Colours = make([]glm.Vec3, len(r.VertFrom))
+    for i, v := range r.VertFrom {
+      m.Colours[i] = from.Colours[v]
+    }
+  }
+  if from.UVs != nil {
+    m.UVs = make([][2]float64, len(r.VertFrom))
+    for i, v := range r.VertFrom {
+      m.UVs[i] = from.UVs[v]
+    }
+  }
+  if from.VertNormals != nil {
+    m.VertNormals = make([][3]glm.Vec3, len(r.FaceFrom))
+    for i, f := range r.FaceFrom {
+      m.VertNormals[i] = from.VertNormals[f]
+    }
+  }
+}
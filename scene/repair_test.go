@@ -0,0 +1,74 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+func TestNewMeshBadFaces(t *testing.T) {
+  verts := [][3]float64{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}
+  faces := [][3]int{{0, 1, 2}, {0, 1, 3}, {-1, 1, 2}}
+  m := NewMesh(verts, faces, Material{})
+  if len(m.Faces) != 1 || m.Faces[0] != [3]int{0, 1, 2} || len(m.Normals) != 1 {
+    t.Errorf("faces %v with %d normals, want only the first", m.Faces, len(m.Normals))
+  }
+  if faces[1] != [3]int{0, 1, 3} {
+    t.Errorf("the caller's faces were changed to %v", faces)
+  }
+  if hit, raylen, _ := m.Intersect(vec(0, 0, -1), vec(0.25, 0.25, 1)); !hit || !near(raylen, 1) {
+    t.Errorf("hit %v at %g, want the good face at 1", hit, raylen)
+  }
+
+  empty := NewMesh(nil, [][3]int{{0, 1, 2}}, Material{})
+  if len(empty.Faces) != 0 {
+    t.Errorf("empty mesh has faces %v", empty.Faces)
+  }
+  if hit, _, _ := empty.Intersect(vec(0, 0, -1), vec(0, 0, 1)); hit {
+    t.Error("hit an empty mesh")
+  }
+}
+
+func TestMeshProblemKind(t *testing.T) {
+  if s := InconsistentWinding.String(); s != "inconsistent winding" {
+    t.Errorf("InconsistentWinding is %q", s)
+  }
+  for _, k := range []MeshProblemKind{-1, InconsistentWinding + 1} {
+    if s := (MeshProblem{Kind: k}).Error(); s == "" {
+      t.Errorf("kind %d has no description", int(k))
+    }
+  }
+}
+
+func TestWeldKeepsSeams(t *testing.T) {
+  // two triangles meeting along x = 0, their UVs split along it.
+  verts := []glm.Vec3{vec(0, 0, 0), vec(0, 1, 0), vec(-1, 0, 0), vec(0, 0, 0), vec(0, 1, 0), vec(1, 0, 0)}
+  m := Mesh{Verts: verts, Faces: [][3]int{{0, 1, 2}, {3, 5, 4}}}
+  m.UVs = [][2]float64{{0, 0}, {0, 1}, {1, 0}, {0, 0}, {0, 1}, {1, 0}}
+  if _, report := m.Repair(0); report.Welded != 2 {
+    t.Errorf("welded %d vertices with equal UVs, want 2", report.Welded)
+  }
+  m.UVs[4] = [2]float64{1, 1}
+  fixed, report := m.Repair(0.1)
+  if report.Welded != 1 || len(fixed.Verts) != 5 {
+    t.Errorf("welded %d vertices to leave %d, want 1 to leave 5", report.Welded, len(fixed.Verts))
+  }
+  if fixed.UVs[fixed.Faces[1][2]] != [2]float64{1, 1} {
+    t.Errorf("seam UV lost: %v", fixed.UVs)
+  }
+  m.UVs = nil
+  m.Colours = []glm.Vec3{vec(1, 0, 0), vec(1, 0, 0), vec(1, 0, 0), vec(0, 1, 0), vec(0, 1, 0), vec(0, 1, 0)}
+  if _, report := m.Repair(0); report.Welded != 0 {
+    t.Errorf("welded %d vertices of different colours", report.Welded)
+  }
+}
+
+func TestWeldSignedZero(t *testing.T) {
+  z := math.Copysign(0, -1)
+  verts := [][3]float64{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {z, z, 0}, {0, 1, z}, {0, 0, 1}}
+  r := RepairFaces(verts, [][3]int{{0, 1, 2}, {3, 4, 5}}, 0)
+  if r.Report.Welded != 2 || len(r.Verts) != 4 {
+    t.Errorf("welded %d vertices to leave %d, want 2 to leave 4", r.Report.Welded, len(r.Verts))
+  }
+}
@@ -0,0 +1,95 @@
+package scene
+
+import (
+	"math"
+	"sort"
+
+	"gray/glm"
+)
+
+// SAMPLING
+//
+// Samplers map uniform numbers u1, u2 in [0,1) to directions or points, so
+// that callers choose where their random numbers come from.
+
+// SampleGGX returns a microfacet normal around the unit normal n, distributed
+// by the GGX (Trowbridge-Reitz) distribution of width alpha.
+func SampleGGX(n glm.Vec3, alpha, u1, u2 float64) glm.Vec3 {
+  theta := math.Atan(alpha * math.Sqrt(u1/(1 - u1)))
+  phi := 2*math.Pi*u2
+  t, b := orthonormal(n)
+  h := t.Scale(math.Sin(theta)*math.Cos(phi))
+  h.Iadd(b.Scale(math.Sin(theta)*math.Sin(phi))).Iadd(n.Scale(math.Cos(theta)))
+  return *h
+}
+
+// Reflect mirrors the direction d about the unit normal n.
+func Reflect(d, n glm.Vec3) glm.Vec3 {
+  return *d.Subtract(n.Scale(2*n.Dot(&d)))
+}
+
+// A piecewise density is constant over each of len(weights) equal steps of
+// [0,1), in proportion to the step's weight.
+type piecewise struct {
+  weights []float64
+  // the running total of weights, from 0 up to total.
+  cdf []float64
+  total float64
+}
+
+func newPiecewise(weights []float64) piecewise {
+  p := piecewise{weights: weights, cdf: make([]float64, len(weights)+1)}
+  for i, w := range weights {
+    p.cdf[i+1] = p.cdf[i] + w
+  }
+  p.total = p.cdf[len(weights)]
+  return p
+}
+
+// sample returns a point in [0,1) drawn from the density, the step it falls
+// in and its density there. The density is 0 when every weight is.
+func (p piecewise) sample(u float64) (x float64, i int, pdf float64) {
+  if p.total <= 0 {
+    return 0, 0, 0
+  }
+  n := len(p.weights)
+  i = min(max(sort.SearchFloat64s(p.cdf, u*p.total) - 1, 0), n - 1)
+  // skip empty steps that u*total lands on the edge of.
+  for p.weights[i] == 0 && i < n - 1 {
+    i++
+  }
+  du := (u*p.total - p.cdf[i]) / p.weights[i]
+  du = math.Min(math.Max(du, 0), math.Nextafter(1, 0))
+  return (float64(i) + du) / float64(n), i, p.weights[i] * float64(n) / p.total
+}
+
+// SampleCone returns a unit direction picked evenly from the cone of
+// directions within an angle of the unit axis, whose cosine is cos_max.
+func SampleCone(axis glm.Vec3, cos_max, u1, u2 float64) glm.Vec3 {
+  cos := 1 - u1*(1 - cos_max)
+  sin := math.Sqrt(math.Max(0, 1 - cos*cos))
+  phi := 2*math.Pi*u2
+  t, b := orthonormal(axis)
+  w := t.Scale(sin*math.Cos(phi))
+  w.Iadd(b.Scale(sin*math.Sin(phi))).Iadd(axis.Scale(cos))
+  return *w
+}
+
+// SampleSphere returns a unit direction picked evenly from all directions.
+func SampleSphere(u1, u2 float64) glm.Vec3 {
+  z := 1 - 2*u1
+  r := math.Sqrt(math.Max(0, 1 - z*z))
+  phi := 2*math.Pi*u2
+  return *glm.NewVec3(r*math.Cos(phi), r*math.Sin(phi), z)
+}
+
+// SampleCosine returns a unit direction about the unit normal n, with density
+// in proportion to its cosine with n.
+func SampleCosine(n glm.Vec3, u1, u2 float64) glm.Vec3 {
+  r := math.Sqrt(u1)
+  phi := 2*math.Pi*u2
+  t, b := orthonormal(n)
+  w := t.Scale(r*math.Cos(phi))
+  w.Iadd(b.Scale(r*math.Sin(phi))).Iadd(n.Scale(math.Sqrt(math.Max(0, 1 - u1))))
+  return *w
+}
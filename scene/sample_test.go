@@ -0,0 +1,64 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+// ggx is the GGX distribution of microfacet normals at an angle with cosine
+// cos to the surface normal.
+func ggx(cos, alpha float64) float64 {
+  tan2 := (1 - cos*cos) / (cos*cos)
+  a2 := alpha*alpha
+  return a2 / (math.Pi*cos*cos*cos*cos*(a2 + tan2)*(a2 + tan2))
+}
+
+// SampleGGX draws microfacet normals with density D(h) cos(theta) over solid
+// angle, so each patch of the unit square maps to a patch of directions
+// 1/(D(h) cos(theta)) times its size.
+func TestSampleGGX(t *testing.T) {
+  n := unit(vec(1, -2, 0.5))
+  const du = 1e-6
+  for _, alpha := range []float64{0.05, 0.3, 1} {
+    for _, u := range [][2]float64{{0.01, 0.2}, {0.3, 0.9}, {0.5, 0.5}, {0.9, 0.1}, {0.999, 0.6}} {
+      h := SampleGGX(n, alpha, u[0], u[1])
+      cos := h.Dot(&n)
+      if math.Abs(h.Length() - 1) > 1e-9 || cos <= 0 {
+        t.Fatalf("alpha %g at %v: normal %v is not a unit vector above the surface", alpha, u, h)
+      }
+      h1, h0 := SampleGGX(n, alpha, u[0] + du, u[1]), SampleGGX(n, alpha, u[0] - du, u[1])
+      h3, h2 := SampleGGX(n, alpha, u[0], u[1] + du), SampleGGX(n, alpha, u[0], u[1] - du)
+      d1, d2 := h1.Subtract(&h0), h3.Subtract(&h2)
+      area := d1.Cross(d2).Length() / (4*du*du)
+      if want := 1 / (ggx(cos, alpha)*cos); math.Abs(area - want) > 1e-4*want {
+        t.Errorf("alpha %g at %v: pdf %g, want %g", alpha, u, 1 / area, 1 / want)
+      }
+    }
+    // half the normals lie within the angle whose tangent is alpha.
+    h := SampleGGX(n, alpha, 0.5, 0.3)
+    if cos := h.Dot(&n); !near(cos, 1 / math.Sqrt(1 + alpha*alpha)) {
+      t.Errorf("alpha %g: median cosine %g, want %g", alpha, cos, 1 / math.Sqrt(1 + alpha*alpha))
+    }
+  }
+  if h := SampleGGX(n, 0, 0.7, 0.2); !nearVec(h, n) {
+    t.Errorf("a smooth surface sampled %v, want the normal %v", h, n)
+  }
+}
+
+func TestReflect(t *testing.T) {
+  for _, c := range []struct {
+    d, n, want glm.Vec3
+  }{
+    {vec(1, -1, 0), vec(0, 1, 0), vec(1, 1, 0)},
+    {vec(0, 0, -2), vec(0, 0, 1), vec(0, 0, 2)},
+    // from behind the surface too.
+    {vec(1, 1, 3), vec(0, -1, 0), vec(1, -1, 3)},
+    {vec(1, 0, 0), unit(vec(-1, 1, 0)), vec(0, 1, 0)},
+  } {
+    if got := Reflect(c.d, c.n); !nearVec(got, c.want) {
+      t.Errorf("%v off %v: %v, want %v", c.d, c.n, got, c.want)
+    }
+  }
+}
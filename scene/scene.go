@@ -6,6 +6,8 @@ import (
 	"io"
 	"math"
   "os"
+  "path/filepath"
+  "sort"
   "strings"
   "strconv"
 
@@ -21,6 +23,9 @@ type Light struct {
 	Pos      glm.Vec3
 	Colour glm.Vec3
 	Falloff  glm.Vec3
+  // the angle, in degrees, the light's disc spans as seen from the scene,
+  // which softens its shadows; 0 for a point.
+  Angle float64
 }
 
 type Material struct {
@@ -29,6 +34,15 @@ type Material struct {
   Specular glm.Vec3
   Shininess float64
   Mirror float64
+  // spreads mirror reflections into a glossy lobe; 0 is a perfect mirror.
+  Roughness float64
+  // light given off by the surface; emissive spheres and meshes become
+  // area lights.
+  Emission glm.Vec3
+  // modulates Ambient and Diffuse on primitives with UVs; may be nil.
+  Texture *Texture
+  // makes closed primitives translucent in place of Diffuse; may be nil.
+  Subsurface *Subsurface
 }
 
 type Primitive interface {
@@ -42,6 +56,11 @@ type Scene struct {
   Eye, View, Up, Ambient glm.Vec3
   Width, Height int
   FOV float64
+  // surrounds the scene; nil leaves a flat grey background that lights
+  // nothing.
+  Environment *Environment
+  // fog and smoke; space outside them is empty.
+  Volumes []Volume
 }
 
 type Sphere struct {
@@ -50,90 +69,181 @@ type Sphere struct {
   Mat Material
 }
 
+// A Box is a cube with its bottom left corner at Pos and sides of length Rad.
 type Box struct {
   Pos glm.Vec3 // bottom left
   Rad float64
   Mat Material
 }
 
+// An AABB is an axis-aligned box spanning Min to Max. It doubles as the
+// bounding volume type.
+type AABB struct {
+  Min, Max glm.Vec3
+  Mat Material
+}
+
 type Mesh struct {
   Verts []glm.Vec3
   Faces [][3]int
   Normals []glm.Vec3
-  Bound Box
+  // per face corner, for smooth shading; nil shades faces flat.
+  VertNormals [][3]glm.Vec3
+  // per vertex diffuse colours, or nil to use Mat throughout.
+  Colours []glm.Vec3
+  // per vertex texture coordinates, or nil.
+  UVs [][2]float64
+  Bound AABB
   Mat Material
 }
 
-/**
- * Real quadratic roots
- */
-func quadraticRoots(A,B,C float64) []float64 {
-  result := make([]float64, 2)
-  if A == 0 {
-    result[0] = -C/B
-    return result[:1]
+// OBJ LOADER
+
+func ReadObj(file string) *Mesh {
+  m, err := parseObj(file)
+  if err != nil {
+    fmt.Println(err)
+    fmt.Println()
+    return &Mesh{}
+  }
+  return m
+}
+
+func parseObj(file string) (*Mesh, error) {
+  verts, polys, err := ReadObjPolygons(file)
+  if err != nil {
+    return nil, err
   }
-  if t := B*B - 4*A*C; t > 0 {
-    tt := math.Sqrt(t)
-    //TODO(ssalbiz): make less dickish.
-    result[0] = (-B + tt)/(2*A)
-    result[1] = (-B - tt)/(2*A)
-    return result
+  r, err := repairLoaded(file, verts, Triangulate(polys), 0)
+  if err != nil {
+    return nil, err
   }
-  return nil
+  return NewMesh(r.Verts, r.Faces, Material{}), nil
 }
 
-// OBJ LOADER
+// ReadMesh reads an OBJ, PLY or STL file, chosen by extension.
+func ReadMesh(file string) (*Mesh, error) {
+  switch strings.ToLower(filepath.Ext(file)) {
+    case ".obj":
+      return parseObj(file)
+    case ".ply":
+      return parsePly(file)
+    case ".stl":
+      return parseStl(file)
+  }
+  return nil, fmt.Errorf("%s: unknown mesh format", file)
+}
 
-func ReadObj(file string) *Mesh {
+// ReadSubdividedMesh reads a mesh as ReadMesh does and subdivides it levels
+// times. OBJ polygons are read as they are, so quads are refined with
+// Catmull-Clark; everything else is triangles and refined with Loop.
+func ReadSubdividedMesh(file string, levels int) (*Mesh, error) {
+  if levels <= 0 {
+    return ReadMesh(file)
+  }
+  if strings.ToLower(filepath.Ext(file)) == ".obj" {
+    verts, polys, err := ReadObjPolygons(file)
+    if err != nil {
+      return nil, err
+    }
+    m, err := Subdivide(verts, polys, nil, levels, Material{})
+    if err != nil {
+      return nil, fmt.Errorf("%s: %v", file, err)
+    }
+    return m, nil
+  }
+  m, err := ReadMesh(file)
+  if err != nil {
+    return nil, err
+  }
+  if m, err = m.Subdivide(levels, nil); err != nil {
+    return nil, fmt.Errorf("%s: %v", file, err)
+  }
+  return m, nil
+}
+
+// ReadObjPolygons reads the vertices and faces of an OBJ file, keeping faces
+// as polygons so quads can be subdivided as quads.
+func ReadObjPolygons(file string) ([][3]float64, [][]int, error) {
   infile, err := os.Open(file)
   if err != nil {
-    fmt.Println(err)
-    return &Mesh{}
+    return nil, nil, err
   }
+  defer infile.Close()
   rd := bufio.NewReader(infile)
-  line, err := rd.ReadString('\n')
-  line = strings.Trim(line, "\r\n")
   verts := [][3]float64{}
+  polys := [][]int{}
+  for lineno := 1; ; lineno++ {
+    line, err := rd.ReadString('\n')
+    if err != nil && err != io.EOF {
+      return nil, nil, err
+    }
+    s := strings.Fields(line)
+    switch {
+      case len(s) > 0 && s[0] == "v":
+        if len(s) < 4 {
+          return nil, nil, fmt.Errorf("%s:%d: vertex needs 3 coordinates", file, lineno)
+        }
+        v := [3]float64{}
+        for i := range v {
+          if v[i], err = strconv.ParseFloat(s[i+1], 64); err != nil {
+            return nil, nil, fmt.Errorf("%s:%d: %v", file, lineno, err)
+          }
+        }
+        verts = append(verts, v)
+      case len(s) > 0 && s[0] == "f":
+        if len(s) < 4 {
+          return nil, nil, fmt.Errorf("%s:%d: face needs 3 vertices", file, lineno)
+        }
+        f := make([]int, len(s)-1)
+        for i, idx := range s[1:] {
+          // drop texture and normal indices.
+          if f[i], err = strconv.Atoi(strings.Split(idx, "/")[0]); err != nil {
+            return nil, nil, fmt.Errorf("%s:%d: %v", file, lineno, err)
+          }
+          // indices count from 1, or back from the last vertex read if
+          // negative; ones out of range are left for the caller to report.
+          switch {
+            case f[i] > 0:
+              f[i]--
+            case f[i] < 0:
+              f[i] += len(verts)
+            default:
+              return nil, nil, fmt.Errorf("%s:%d: vertex index 0", file, lineno)
+          }
+        }
+        polys = append(polys, f)
+    }
+    if err == io.EOF {
+      return verts, polys, nil
+    }
+  }
+}
+
+// Triangulate splits polygons into fans of triangles.
+func Triangulate(polys [][]int) [][3]int {
   faces := [][3]int{}
-  for err != io.EOF {
-    s := strings.Split(line, " ")
-    if line[0] == 'v' {
-      f1, err1 := strconv.ParseFloat(s[1], 64)
-      f2, err2 := strconv.ParseFloat(s[2], 64)
-      f3, err3 := strconv.ParseFloat(s[3], 64)
-      if err1 != nil || err2 != nil || err3 != nil {
-        fmt.Println(err1, err2, err3)
-        fmt.Println()
-        return &Mesh{}
-      }
-      v := [3]float64{ f1, f2, f3 }
-      verts = append(verts, v)
-    } else {
-      i1, err1 := strconv.Atoi(s[1])
-      i2, err2 := strconv.Atoi(s[2])
-      i3, err3 := strconv.Atoi(s[3])
-      if err1 != nil || err2 != nil || err3 != nil {
-        fmt.Println(err1, err2, err3)
-        fmt.Println()
-        return &Mesh{}
-      }
-      f := [3]int{ i1-1 , i2-1, i3-1 }
-      faces = append(faces, f)
+  for _, p := range polys {
+    for i := 2; i < len(p); i++ {
+      faces = append(faces, [3]int{p[0], p[i-1], p[i]})
     }
-    line, err = rd.ReadString('\n')
-    line = strings.Trim(line, "\r\n")
   }
-  return NewMesh(verts, faces, Material{})
+  return faces
 }
 
 // MESH PRIMITIVES
 
+// NewMesh builds a mesh with a flat normal for each face. Faces that refer
+// to vertices that do not exist are left out, as RepairFaces leaves them
+// out; run that first on loaded data to hear about them.
 func NewMesh(verts [][3]float64, faces [][3]int, mat Material) *Mesh {
   vec_verts := make([]glm.Vec3, len(verts))
   m := &Mesh{}
-  min := [3]float64{verts[0][0], verts[0][1], verts[0][2]}
-  max := [3]float64{verts[0][0], verts[0][1], verts[0][2]}
+  var min, max [3]float64
+  if len(verts) > 0 {
+    min = verts[0]
+    max = verts[0]
+  }
 
   for i, v := range verts {
     for j := 0; j < 3; j++ {
@@ -144,97 +254,212 @@ func NewMesh(verts [][3]float64, faces [][3]int, mat Material) *Mesh {
   }
   m.Verts = vec_verts
   m.Faces = faces
+  for _, f := range faces {
+    if !faceInRange(f, len(verts)) {
+      // copy the good faces, leaving the caller's slice alone.
+      m.Faces = make([][3]int, 0, len(faces))
+      for _, f := range faces {
+        if faceInRange(f, len(verts)) {
+          m.Faces = append(m.Faces, f)
+        }
+      }
+      break
+    }
+  }
   // Generate plane normals for all faces.
-  m.Normals = make([]glm.Vec3, len(faces))
-  for i, f := range faces {
+  m.Normals = make([]glm.Vec3, len(m.Faces))
+  for i, f := range m.Faces {
     v1 := vec_verts[f[0]].Subtract(&vec_verts[f[1]])
     v2 := vec_verts[f[0]].Subtract(&vec_verts[f[2]])
     m.Normals[i] = *v1.Cross(v2)
   }
-  m.Bound.Pos = *glm.NewVec3(min[0], min[1], min[2])
-  diff := glm.NewVec3(max[0], max[1], max[2]).Subtract(&m.Bound.Pos)
-  m.Bound.Rad = math.Max(math.Max(diff.Elem[0], diff.Elem[1]), diff.Elem[2])
-  m.Bound.Mat = mat
+  m.Bound = AABB{*glm.NewVec3(min[0], min[1], min[2]), *glm.NewVec3(max[0], max[1], max[2]), mat}
   m.Mat = mat
   return m
 }
 
-func major_axis(v glm.Vec3) (ret int) {
-  if v.Elem[0] > v.Elem[1] && v.Elem[0] > v.Elem[2] {
-    ret = 0
-  } else if v.Elem[1] > v.Elem[0] && v.Elem[1] > v.Elem[2] {
-    ret = 1
-  } else {
-    ret = 2
+func faceInRange(f [3]int, n int) bool {
+  return f[0] >= 0 && f[0] < n && f[1] >= 0 && f[1] < n && f[2] >= 0 && f[2] < n
+}
+
+// faceHit intersects the ray with face i, anywhere along the ray's line,
+// returning the barycentric weights of the hit.
+func (p Mesh) faceHit(i int, ray *shearedRay) (bool, float64, [3]float64) {
+  f := p.Faces[i]
+  return ray.hit(p.Verts[f[0]], p.Verts[f[1]], p.Verts[f[2]])
+}
+
+// closestFace finds the nearest face in front of the origin, with the
+// barycentric weights of the hit.
+func (p Mesh) closestFace(ray, origin glm.Vec3) (b bool, raylen float64, face int, weights [3]float64) {
+  // Check bounding box.
+  if b, _, _ := p.Bound.Intersect(ray, origin); !b {
+    return false, 0, 0, weights
+  }
+  // Go through faces and do face intersections.
+  raylen = 10000000.0
+  sheared := newShearedRay(ray, origin)
+  for i := range p.Faces {
+    if hit, new_raylen, w := p.faceHit(i, sheared); hit && new_raylen > 0 {
+      b = true
+      if new_raylen < raylen {
+        raylen = new_raylen
+        face, weights = i, w
+      }
+    }
   }
   return
 }
 
-func drop_axis(v glm.Vec3, axis int) (ret glm.Vec3) {
-  switch axis {
-    case 0:
-      ret = *glm.NewVec3(v.Elem[1], v.Elem[2], 0.0)
-    case 1:
-      ret = *glm.NewVec3(v.Elem[0], v.Elem[2], 0.0)
-    case 2:
-      ret = *glm.NewVec3(v.Elem[0], v.Elem[1], 0.0)
+func (p Mesh) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  // If we're debugging just draw the bounding box.
+  if ONLY_DRAW_BOUNDS {
+    return p.Bound.Intersect(ray, origin)
+  }
+  b, raylen, face, weights := p.closestFace(ray, origin)
+  if !b {
+    return false, 0, glm.Vec3{}
+  }
+  if p.VertNormals != nil {
+    return true, raylen, p.interpolate(face, weights)
+  }
+  return true, raylen, p.Normals[face]
+}
+
+// HitPoint rebuilds the hit from the corners of the face it is on, which is
+// the nearest unless the hit is a later crossing found by Intervals.
+func (p Mesh) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  b, t, face, weights := p.closestFace(ray, origin)
+  if b && t != raylen {
+    b = false
+    sheared := newShearedRay(ray, origin)
+    for i := range p.Faces {
+      if hit, t, w := p.faceHit(i, sheared); hit && t == raylen {
+        b, face, weights = true, i, w
+        break
+      }
+    }
+  }
+  if ONLY_DRAW_BOUNDS || !b {
+    return alongRay(ray, origin, raylen, 16, normal)
+  }
+  f := p.Faces[face]
+  return weighted([3]glm.Vec3{p.Verts[f[0]], p.Verts[f[1]], p.Verts[f[2]]}, weights)
+}
+
+// weights returns the barycentric weights of point in face i.
+func (p Mesh) weights(i int, point glm.Vec3) (w [3]float64) {
+  f := p.Faces[i]
+  n := p.Normals[i]
+  for k := 0; k < 3; k++ {
+    a, b := p.Verts[f[(k+1)%3]], p.Verts[f[(k+2)%3]]
+    // corner k is weighted by the area of the triangle opposite it.
+    w[k] = b.Subtract(&a).Cross(point.Subtract(&a)).Dot(&n) / n.Dot(&n)
   }
   return
 }
 
-func project_2dface(face [3]int, verts []glm.Vec3, axis int) [3]glm.Vec3 {
-  v1 := verts[face[0]]
-  v2 := verts[face[1]]
-  v3 := verts[face[2]]
+// interpolate blends the corner normals of face i by barycentric weights.
+func (p Mesh) interpolate(i int, weights [3]float64) glm.Vec3 {
+  normal := glm.Vec3{}
+  for k, w := range weights {
+    normal.Iadd(p.VertNormals[i][k].Scale(w))
+  }
+  return normal
+}
 
-  return [3]glm.Vec3{
-    drop_axis(v1, axis),
-    drop_axis(v2, axis),
-    drop_axis(v3, axis) }
+// faceAt finds the face nearest a point on the mesh, or -1.
+func (p Mesh) faceAt(point glm.Vec3) int {
+  best, face := math.Inf(1), -1
+  for i, f := range p.Faces {
+    n := p.Normals[i]
+    dist := math.Abs(point.Subtract(&p.Verts[f[0]]).Dot(&n)) / n.Length()
+    if dist >= best {
+      continue
+    }
+    if w := p.weights(i, point); w[0] >= -Epsilon && w[1] >= -Epsilon && w[2] >= -Epsilon {
+      best, face = dist, i
+    }
+  }
+  return face
 }
 
-func same_side(a, b, c, test glm.Vec3) bool {
-  t := b.Subtract(&a)
-  return t.Cross(test.Subtract(&a)).Dot(t.Cross(c.Subtract(&a))) > Epsilon
+// MaterialAt blends vertex colours, if any, into the diffuse and ambient
+// colours of the face nearest point.
+func (p Mesh) MaterialAt(point glm.Vec3) Material {
+  mat := p.Mat
+  if p.Colours == nil {
+    return mat
+  }
+  face := p.faceAt(point)
+  if face < 0 {
+    return mat
+  }
+  colour := glm.Vec3{}
+  for k, w := range p.weights(face, point) {
+    colour.Iadd(p.Colours[p.Faces[face][k]].Scale(w))
+  }
+  mat.Diffuse, mat.Ambient = colour, colour
+  return mat
 }
 
-func (p Mesh) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
-  // If we're debugging just draw the bounding box.
-  if ONLY_DRAW_BOUNDS {
-    return p.Bound.Intersect(ray, origin)
+// Intervals pairs up the faces the ray's line crosses, in order. The mesh
+// must be closed; face winding does not matter.
+func (p Mesh) Intervals(ray, origin glm.Vec3) []Interval {
+  if _, _, b := p.Bound.Span(ray, origin); !b {
+    return nil
   }
-  // Check bounding box.
-  if b, _, _ := p.Bound.Intersect(ray, origin); !b {
-    return false, 0, glm.Vec3{}
+  type crossing struct {
+    raylen float64
+    normal glm.Vec3
   }
-  // Go through faces and do face intersections.
-  raylen = 10000000.0
-  for i, f := range p.Faces {
-    ray_proj := p.Normals[i].Dot(&ray) // Intersect the ray with the plane.
-    if math.Abs(ray_proj) > Epsilon {
-      new_raylen := p.Verts[f[0]].Subtract(&origin).Dot(&p.Normals[i]) / ray_proj
-      // check that the ray origin is not coincident with the plane
-      if new_raylen > Epsilon {
-        // project face to 2D
-        maj_axis := major_axis(p.Normals[i])
-        verts2d := project_2dface(f, p.Verts, maj_axis)
-        test_pt := drop_axis(*origin.Add(ray.Scale(new_raylen)), maj_axis)
-        // clip the ray to the bounds of the 2D face.
-        if same_side(verts2d[0], verts2d[1], verts2d[2], test_pt) &&
-           same_side(verts2d[1], verts2d[2], verts2d[0], test_pt) &&
-           same_side(verts2d[2], verts2d[0], verts2d[1], test_pt) {
-           b = true
-           if new_raylen < raylen {
-             raylen = new_raylen
-             normal = p.Normals[i]
-           }
-        }
-      }
+  crossings := []crossing{}
+  sheared := newShearedRay(ray, origin)
+  for i := range p.Faces {
+    if hit, raylen, _ := p.faceHit(i, sheared); hit {
+      crossings = append(crossings, crossing{raylen, p.Normals[i]})
+    }
+  }
+  sort.Slice(crossings, func(i, j int) bool { return crossings[i].raylen < crossings[j].raylen })
+  intervals := []Interval{}
+  var enter *crossing
+  for i := range crossings {
+    c := &crossings[i]
+    // a ray through a shared edge or vertex hits each face there once.
+    if i > 0 && c.raylen - crossings[i-1].raylen <= Epsilon {
+      continue
+    }
+    if enter == nil {
+      enter = c
+      continue
     }
+    exit := facing(c.normal, ray)
+    intervals = append(intervals, Interval{enter.raylen, c.raylen, facing(enter.normal, ray), *exit.Scale(-1)})
+    enter = nil
+  }
+  return intervals
+}
+
+func (p Mesh) UV(point glm.Vec3) (u, v float64) {
+  if p.UVs == nil {
+    return 0, 0
+  }
+  face := p.faceAt(point)
+  if face < 0 {
+    return 0, 0
+  }
+  for k, w := range p.weights(face, point) {
+    uv := p.UVs[p.Faces[face][k]]
+    u += w*uv[0]
+    v += w*uv[1]
   }
   return
 }
 
+func (p Mesh) Bounds() AABB {
+  return p.Bound
+}
+
 func (p Mesh) GetMaterial() Material {
   return p.Mat
 }
@@ -242,37 +467,145 @@ func (p Mesh) GetMaterial() Material {
 
 // BOX PRIMITIVES
 
-func (p Box) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
-  min := p.Pos
-  max := p.Pos.Add(glm.NewVec3(p.Rad, p.Rad, p.Rad))
-  raylen_near := -100000.0
-  raylen_far :=   100000.0
-  // Assume parallel intersections are not a thing.
+// slab clips the ray's line against the box, returning the lengths at which
+// it enters and leaves and the outward normals of the faces crossed there.
+func (p AABB) slab(ray, origin glm.Vec3) (near, far float64, near_normal, far_normal glm.Vec3, b bool) {
+  near = math.Inf(-1)
+  far = math.Inf(1)
+  near_axis, far_axis := 0, 0
+  near_sign, far_sign := 0.0, 0.0
   for i, raydir := range ray.Elem {
-    if math.Abs(raydir) < Epsilon && (origin.Elem[i] < min.Elem[i] || origin.Elem[i] > max.Elem[i]) {
-      return false, 0, glm.Vec3{}
+    // an empty box is never crossed.
+    if p.Min.Elem[i] > p.Max.Elem[i] {
+      return 0, 0, near_normal, far_normal, false
+    }
+    if raydir == 0 {
+      // parallel to the slab: either always or never inside it.
+      if origin.Elem[i] < p.Min.Elem[i] || origin.Elem[i] > p.Max.Elem[i] {
+        return 0, 0, near_normal, far_normal, false
+      }
+      continue
     }
-    t1 := (min.Elem[i] - origin.Elem[i]) / raydir
-    t2 := (max.Elem[i] - origin.Elem[i]) / raydir
-    flip := false
+    t1 := (p.Min.Elem[i] - origin.Elem[i]) / raydir
+    t2 := (p.Max.Elem[i] - origin.Elem[i]) / raydir
+    sign := -1.0 // entering through the min face
     if t1 > t2 {
-      t := t1; t1 = t2; t2 = t; flip = true
+      t1, t2 = t2, t1
+      sign = 1.0
     }
-    if t1 > raylen_near {
-      raylen_near = t1
-      normal = *glm.NewVec3(0.0, 0.0, 0.0)
-      if flip {
-        normal.Elem[i] = p.Rad
-      } else {
-        normal.Elem[i] = -p.Rad
-      }
+    if t1 > near {
+      near, near_axis, near_sign = t1, i, sign
+    }
+    if t2 < far {
+      far, far_axis, far_sign = t2, i, -sign
     }
-    raylen_far = math.Min(raylen_far, t2)
-    if raylen_far < raylen_near || raylen_far < Epsilon {
-      return false, 0, normal
+    if far < near {
+      return 0, 0, near_normal, far_normal, false
     }
   }
-  return true, raylen_near, normal
+  near_normal.Elem[near_axis] = near_sign
+  far_normal.Elem[far_axis] = far_sign
+  return near, far, near_normal, far_normal, true
+}
+
+func (p AABB) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  near, far, near_normal, far_normal, b := p.slab(ray, origin)
+  if !b || far <= 0 {
+    return false, 0, normal
+  }
+  // from inside the box the ray leaves through the far face.
+  if near > 0 {
+    return true, near, near_normal
+  }
+  return true, far, far_normal
+}
+
+// HitPoint puts the hit exactly on the face crossed, leaving error only along
+// the face.
+func (p AABB) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  h := alongRay(ray, origin, raylen, 16, normal)
+  axis := 0
+  for i := range normal.Elem {
+    if math.Abs(normal.Elem[i]) > math.Abs(normal.Elem[axis]) {
+      axis = i
+    }
+  }
+  x := h.Point.Elem[axis]
+  if math.Abs(x - p.Min.Elem[axis]) < math.Abs(x - p.Max.Elem[axis]) {
+    h.Point.Elem[axis] = p.Min.Elem[axis]
+  } else {
+    h.Point.Elem[axis] = p.Max.Elem[axis]
+  }
+  h.Error.Elem[axis] = 0
+  return h
+}
+
+// Span returns the range of ray lengths for which the ray is inside the box.
+func (p AABB) Span(ray, origin glm.Vec3) (near, far float64, b bool) {
+  near, far, _, _, b = p.slab(ray, origin)
+  return
+}
+
+func (p AABB) Intervals(ray, origin glm.Vec3) []Interval {
+  if near, far, near_normal, far_normal, b := p.slab(ray, origin); b {
+    return []Interval{{near, far, near_normal, far_normal}}
+  }
+  return nil
+}
+
+func (p AABB) Bounds() AABB {
+  return p
+}
+
+func (p AABB) GetMaterial() Material {
+  return p.Mat
+}
+
+// emptyBox contains nothing, and leaves boxes it is unioned with alone.
+func emptyBox(mat Material) AABB {
+  inf := math.Inf(1)
+  return AABB{*glm.NewVec3(inf, inf, inf), *glm.NewVec3(-inf, -inf, -inf), mat}
+}
+
+// Empty reports a box that contains nothing, with Min above Max on some
+// axis.
+func (p AABB) Empty() bool {
+  for i := range p.Min.Elem {
+    if p.Min.Elem[i] > p.Max.Elem[i] {
+      return true
+    }
+  }
+  return false
+}
+
+// Union returns the smallest box containing both p and q.
+func (p AABB) Union(q AABB) AABB {
+  return AABB{vecMin(p.Min, q.Min), vecMax(p.Max, q.Max), p.Mat}
+}
+
+// Grow returns the smallest box containing p and the point v.
+func (p AABB) Grow(v glm.Vec3) AABB {
+  return AABB{vecMin(p.Min, v), vecMax(p.Max, v), p.Mat}
+}
+
+func (p Box) aabb() AABB {
+  return AABB{p.Pos, *p.Pos.Add(glm.NewVec3(p.Rad, p.Rad, p.Rad)), p.Mat}
+}
+
+func (p Box) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  return p.aabb().Intersect(ray, origin)
+}
+
+func (p Box) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  return p.aabb().HitPoint(ray, origin, raylen, normal)
+}
+
+func (p Box) Intervals(ray, origin glm.Vec3) []Interval {
+  return p.aabb().Intervals(ray, origin)
+}
+
+func (p Box) Bounds() AABB {
+  return p.aabb()
 }
 
 func (p Box) GetMaterial() Material {
@@ -282,7 +615,7 @@ func (p Box) GetMaterial() Material {
 // SPHERE PRIMITIVES
 
 func ray_epsilon_check(raylen float64, ray glm.Vec3, line glm.Vec3) (b bool, retlen float64, normal glm.Vec3) {
-  if raylen > Epsilon {
+  if raylen > 0 {
     return true, raylen, *ray.Scale(raylen).Subtract(&line)
   }
   return false, 0, *glm.NewVec3(0,0,0)
@@ -310,6 +643,41 @@ func (p Sphere) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal
   return false, 0, normal
 }
 
+// HitPoint projects the hit back onto the sphere, leaving only the error of
+// the projection.
+func (p Sphere) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  d := *origin.Add(ray.Scale(raylen)).Subtract(&p.Pos)
+  d.Iscale(p.Rad / d.Length())
+  h := Hit{Point: *p.Pos.Add(&d), Normal: d}
+  for i := range h.Error.Elem {
+    h.Error.Elem[i] = Gamma(5)*math.Abs(d.Elem[i]) + Gamma(1)*math.Abs(h.Point.Elem[i])
+  }
+  return h
+}
+
+func (p Sphere) Intervals(ray, origin glm.Vec3) []Interval {
+  line := *origin.Subtract(&p.Pos)
+  roots := quadraticRoots(ray.Dot(&ray), 2*line.Dot(&ray), line.Dot(&line) - p.Rad*p.Rad)
+  if len(roots) < 2 {
+    return nil
+  }
+  sort.Float64s(roots)
+  return []Interval{{roots[0], roots[1], *line.Add(ray.Scale(roots[0])), *line.Add(ray.Scale(roots[1]))}}
+}
+
+func (p Sphere) Bounds() AABB {
+  r := glm.NewVec3(p.Rad, p.Rad, p.Rad)
+  return AABB{*p.Pos.Subtract(r), *p.Pos.Add(r), p.Mat}
+}
+
+func (p Sphere) UV(point glm.Vec3) (u, v float64) {
+  d := point.Subtract(&p.Pos)
+  d.Normalize()
+  u = 0.5 + math.Atan2(d.Elem[2], d.Elem[0])/(2*math.Pi)
+  v = 0.5 + math.Asin(math.Max(-1, math.Min(d.Elem[1], 1)))/math.Pi
+  return
+}
+
 func (p Sphere) GetMaterial() Material {
   return p.Mat
 }
@@ -317,29 +685,108 @@ func (p Sphere) GetMaterial() Material {
 
 // SCENE DESCRIPTION
 
+// Scenes holds the built-in scenes by name.
+var Scenes = map[string]func() (*Scene, error){
+  "default": CreateScene,
+  "mandelbulb": CreateMandelbulbScene,
+  "subsurface": CreateSubsurfaceScene,
+  "terrain": CreateTerrainScene,
+}
+
+// LoadScene returns the built-in scene of that name, reads a .gltf or .glb
+// file, or shows an OBJ, PLY or STL mesh subdivided subdivide times.
+func LoadScene(name string, subdivide int) (*Scene, error) {
+  switch strings.ToLower(filepath.Ext(name)) {
+    case ".gltf", ".glb":
+      return ReadGLTF(name)
+    case ".obj", ".ply", ".stl":
+      return CreateMeshScene(name, subdivide)
+  }
+  create, ok := Scenes[name]
+  if !ok {
+    return nil, fmt.Errorf("unknown scene %s", name)
+  }
+  sc, err := create()
+  if err != nil {
+    return nil, err
+  }
+  return sc, sc.Validate()
+}
+
+// Validate checks the primitives that cannot check themselves as they are
+// built, for scenes from Go code or from the network.
+func (s *Scene) Validate() error {
+  for i, p := range s.Primitives {
+    if q, ok := p.(Quartic); ok {
+      if err := q.Validate(); err != nil {
+        return fmt.Errorf("primitive %d: %v", i, err)
+      }
+    }
+  }
+  return nil
+}
+
+// CreateMeshScene shows the mesh in file, read with ReadSubdividedMesh, on a
+// floor and lit from the front, with the camera backed off along +z to take
+// it all in.
+func CreateMeshScene(file string, subdivide int) (scene *Scene, err error) {
+  mesh, err := ReadSubdividedMesh(file, subdivide)
+  if err != nil {
+    return nil, err
+  }
+  mesh.Mat = Material{
+    *glm.NewVec3(0.7, 0.7, 0.7), *glm.NewVec3(0.7, 0.7, 0.7), *glm.NewVec3(0.3, 0.3, 0.3), 25.0, 0.0, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
+  floor := Material{
+    *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.2, 0.2, 0.2), 10.0, 0.0, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
+  centre := *mesh.Bound.Min.Add(&mesh.Bound.Max).Scale(0.5)
+  rad := mesh.Bound.Max.Subtract(&centre).Length()
+
+  scene = &Scene{}
+  scene.FOV = 50
+  // far enough back for the bounding sphere to fill the view.
+  dist := rad / math.Sin(scene.FOV/2 * math.Pi/180)
+  scene.Lights = []Light{
+    Light{*centre.Add(glm.NewVec3(-0.5*dist, 0.75*dist, dist)), *glm.NewVec3(0.7, 0.7, 0.7), *glm.NewVec3(1.0, 0.0, 0.0), 0},
+    Light{*centre.Add(glm.NewVec3(dist, 0.25*dist, 0.4*dist)), *glm.NewVec3(0.3, 0.3, 0.3), *glm.NewVec3(1.0, 0.0, 0.0), 0},
+  }
+  scene.Primitives = []Primitive{
+    *mesh,
+    Plane{*glm.NewVec3(0.0, mesh.Bound.Min.Elem[1], 0.0), *glm.NewVec3(0.0, 1.0, 0.0), floor},
+  }
+  scene.Eye = *centre.Add(glm.NewVec3(0, 0, dist))
+  scene.View = *glm.NewVec3(0.0, 0.0, -1.0)
+  scene.Up = *glm.NewVec3(0.0, 1.0, 0.0)
+  scene.Ambient = *glm.NewVec3(0.3, 0.3, 0.3)
+  scene.Width = 512
+  scene.Height = 512
+  return scene, nil
+}
+
 func CreateScene() (scene *Scene, err error) {
   scene = &Scene{}
   scene.Lights = []Light{
-    Light{*glm.NewVec3(-100.0, 150.0, 400.0), *glm.NewVec3(0.7, 0.7, 0.7), *glm.NewVec3(1.0, 0.0, 0.0)},
-    Light{*glm.NewVec3( 400.0, 100.0, 150.0), *glm.NewVec3(0.7, 0.0, 0.7), *glm.NewVec3(1.0, 0.0, 0.0)},
+    Light{*glm.NewVec3(-100.0, 150.0, 400.0), *glm.NewVec3(0.7, 0.7, 0.7), *glm.NewVec3(1.0, 0.0, 0.0), 0},
+    Light{*glm.NewVec3( 400.0, 100.0, 150.0), *glm.NewVec3(0.7, 0.0, 0.7), *glm.NewVec3(1.0, 0.0, 0.0), 0},
   }
   mat1 := Material{
-    *glm.NewVec3(0.7, 1.0, 0.7), *glm.NewVec3(0.7, 1.0, 0.7), *glm.NewVec3(0.5, 0.7, 0.5), 25.0, 0.3,
-  }
+    *glm.NewVec3(0.7, 1.0, 0.7), *glm.NewVec3(0.7, 1.0, 0.7), *glm.NewVec3(0.5, 0.7, 0.5), 25.0, 0.3, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
   mat2 := Material{
-    *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.5, 0.7, 0.5), 25.0, 0.3,
-  }
+    *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.5, 0.7, 0.5), 25.0, 0.3, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
   mat3 := Material{
-    *glm.NewVec3(1.0, 0.6, 0.1), *glm.NewVec3(1.0, 0.6, 0.1), *glm.NewVec3(0.5, 0.7, 0.5), 25.0, 0.3,
-  }
+    *glm.NewVec3(1.0, 0.6, 0.1), *glm.NewVec3(1.0, 0.6, 0.1), *glm.NewVec3(0.5, 0.7, 0.5), 25.0, 0.3, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
   mat4 := Material{
-    *glm.NewVec3(0.7, 0.6, 1.0), *glm.NewVec3(0.7, 0.6, 1.0), *glm.NewVec3(0.5, 0.4, 0.8), 25.0, 0.3,
-  }
+    *glm.NewVec3(0.7, 0.6, 1.0), *glm.NewVec3(0.7, 0.6, 1.0), *glm.NewVec3(0.5, 0.4, 0.8), 25.0, 0.3, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
 
   scene.Primitives = make([]Primitive, 7)
   scene.Primitives[0] = Sphere{ *glm.NewVec3(0.0, 0.0, -400.0), 100.0, mat1 }
   scene.Primitives[1] = Sphere{ *glm.NewVec3(200.0, 50.0, -100.0), 150.0, mat1 }
-  scene.Primitives[2] = Sphere{ *glm.NewVec3(0.0, -1200.0, -500.0), 1000.0, mat2 }
+  scene.Primitives[2] = Plane{ *glm.NewVec3(0.0, -200.0, 0.0), *glm.NewVec3(0.0, 1.0, 0.0), mat2 }
   scene.Primitives[3] = Sphere{ *glm.NewVec3(-100.0, 25.0, -300.0), 50.0, mat3 }
   scene.Primitives[4] = Sphere{ *glm.NewVec3(0.0, 100.0, -250.0), 25.0, mat1 }
   scene.Primitives[5] = Box{*glm.NewVec3(-200.0, -125.0, 0.0), 100, mat4 }
@@ -0,0 +1,69 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+// BOXES
+
+func TestAABB(t *testing.T) {
+  // a box of different sides on each axis.
+  box := AABB{vec(-1, 0, 2), vec(3, 0.5, 8), Material{}}
+  for _, c := range []struct {
+    name string
+    ray, origin glm.Vec3
+    hit bool
+    raylen float64
+    normal glm.Vec3
+  }{
+    {"from -x", vec(1, 0, 0), vec(-5, 0.25, 5), true, 4, vec(-1, 0, 0)},
+    {"from +x", vec(-2, 0, 0), vec(7, 0.25, 5), true, 2, vec(1, 0, 0)},
+    {"from -y", vec(0, 1, 0), vec(0, -2, 3), true, 2, vec(0, -1, 0)},
+    {"from +y", vec(0, -1, 0), vec(0, 2, 3), true, 1.5, vec(0, 1, 0)},
+    {"from -z", vec(0, 0, 1), vec(1, 0.1, 0), true, 2, vec(0, 0, -1)},
+    {"from +z", vec(0, 0, -1), vec(1, 0.1, 10), true, 2, vec(0, 0, 1)},
+    {"diagonal", vec(1, 1, 1), vec(-2, -1, 1), true, 1, vec(-1, 0, 0)},
+    {"from inside", vec(0, 0, 1), vec(0, 0.25, 5), true, 3, vec(0, 0, 1)},
+    {"from inside, thin axis", vec(0, -1, 0), vec(0, 0.25, 5), true, 0.25, vec(0, -1, 0)},
+    {"beside", vec(1, 0, 0), vec(-5, 0.75, 5), false, 0, glm.Vec3{}},
+    {"away", vec(-1, 0, 0), vec(-5, 0.25, 5), false, 0, glm.Vec3{}},
+    {"passing a corner", vec(1, 1, 0), vec(-3, -1.4, 5), false, 0, glm.Vec3{}},
+  } {
+    hit, raylen, normal := box.Intersect(c.ray, c.origin)
+    if hit != c.hit || hit && (!near(raylen, c.raylen) || normal != c.normal) {
+      t.Errorf("%s: hit %v at %g with normal %v, want %v at %g with %v", c.name, hit, raylen, normal, c.hit, c.raylen, c.normal)
+    }
+  }
+
+  if near_len, far_len, ok := box.Span(vec(0, 0, 1), vec(0, 0.25, 0)); !ok || near_len != 2 || far_len != 8 {
+    t.Errorf("span %g to %g (%v), want 2 to 8", near_len, far_len, ok)
+  }
+  // behind the origin still counts.
+  if near_len, far_len, ok := box.Span(vec(0, 0, 1), vec(0, 0.25, 20)); !ok || near_len != -18 || far_len != -12 {
+    t.Errorf("span behind %g to %g (%v), want -18 to -12", near_len, far_len, ok)
+  }
+  if _, _, ok := box.Span(vec(0, 0, 1), vec(0, 1, 0)); ok {
+    t.Error("span of a ray parallel to the box and above it")
+  }
+
+  inf := math.Inf(1)
+  sky := AABB{vec(-inf, -inf, -inf), vec(inf, 10, inf), Material{}}
+  if hit, raylen, normal := sky.Intersect(vec(0, 1, 0), vec(0, 0, 0)); !hit || raylen != 10 || normal != vec(0, 1, 0) {
+    t.Errorf("unbounded box: hit %v at %g with normal %v", hit, raylen, normal)
+  }
+
+  u := box.Union(AABB{vec(-2, 1, 3), vec(0, 2, 4), Material{}})
+  if u.Min != vec(-2, 0, 2) || u.Max != vec(3, 2, 8) {
+    t.Errorf("union %v to %v", u.Min, u.Max)
+  }
+  if g := box.Grow(vec(5, -1, 3)); g.Min != vec(-1, -1, 2) || g.Max != vec(5, 0.5, 8) {
+    t.Errorf("grown to %v to %v", g.Min, g.Max)
+  }
+  cube := Box{vec(1, 2, 3), 2, Material{}}
+  if b := cube.Bounds(); b.Min != vec(1, 2, 3) || b.Max != vec(3, 4, 5) {
+    t.Errorf("cube bounds %v to %v", b.Min, b.Max)
+  }
+}
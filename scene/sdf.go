@@ -0,0 +1,279 @@
+package scene
+
+import (
+	"math"
+
+	"gray/glm"
+)
+
+const (
+  MAX_MARCH_STEPS = 512
+  // distance to a field's surface that counts as a hit, in world units.
+  MARCH_EPSILON = 0.0001
+)
+
+// A Field is a signed distance field: Distance is negative inside the
+// surface and never more than the distance to it.
+type Field interface {
+  Distance(p glm.Vec3) float64
+}
+
+// A DistanceFunc lets a plain function be used as a Field. Functions cannot be
+// sent to render workers, so prefer the shapes below when distributing.
+type DistanceFunc func(p glm.Vec3) float64
+
+func (f DistanceFunc) Distance(p glm.Vec3) float64 {
+  return f(p)
+}
+
+// SDF PRIMITIVES
+
+// An SDF renders the surface of a field by sphere tracing within Bound. Step
+// scales each marching step and should be below 1 for fields that
+// overestimate distances, such as twists; zero means 1.
+type SDF struct {
+  Field Field
+  Bound AABB
+  Step float64
+  Mat Material
+}
+
+func (p SDF) Intersect(ray, origin glm.Vec3) (b bool, raylen float64, normal glm.Vec3) {
+  scale := ray.Length()
+  d := *ray.Scale(1/scale)
+  near, far, ok := p.Bound.Span(d, origin)
+  if !ok || far < 0 {
+    return false, 0, normal
+  }
+  step := p.Step
+  if step == 0 {
+    step = 1
+  }
+  t := math.Max(near, 0)
+  sign := math.Copysign(1, p.Field.Distance(*origin.Add(d.Scale(t))))
+  for i := 0; i < MAX_MARCH_STEPS && t <= far; i++ {
+    dist := sign * p.Field.Distance(*origin.Add(d.Scale(t)))
+    // accept coarser hits further away, where a pixel covers more.
+    if dist < MARCH_EPSILON*(1 + t) {
+      return true, t / scale, fieldNormal(p.Field, *origin.Add(d.Scale(t)))
+    }
+    t += step * dist
+  }
+  return false, 0, normal
+}
+
+// HitPoint bounds the hit by the distance it was accepted at, doubled so that
+// rays leaving the surface start clear of it.
+func (p SDF) HitPoint(ray, origin glm.Vec3, raylen float64, normal glm.Vec3) Hit {
+  h := alongRay(ray, origin, raylen, 16, normal)
+  for i := range h.Error.Elem {
+    h.Error.Elem[i] += 2*MARCH_EPSILON*(1 + raylen*ray.Length())
+  }
+  return h
+}
+
+// fieldNormal estimates the gradient of f at p from four samples on a
+// tetrahedron.
+func fieldNormal(f Field, p glm.Vec3) glm.Vec3 {
+  const h = MARCH_EPSILON
+  normal := glm.Vec3{}
+  for _, k := range [4]glm.Vec3{
+    *glm.NewVec3(1, -1, -1), *glm.NewVec3(-1, -1, 1), *glm.NewVec3(-1, 1, -1), *glm.NewVec3(1, 1, 1),
+  } {
+    normal.Iadd(k.Scale(f.Distance(*p.Add(k.Scale(h)))))
+  }
+  return normal
+}
+
+func (p SDF) Bounds() AABB {
+  return AABB{p.Bound.Min, p.Bound.Max, p.Mat}
+}
+
+func (p SDF) GetMaterial() Material {
+  return p.Mat
+}
+
+// SDF SHAPES
+
+type SDFSphere struct {
+  Rad float64
+}
+
+func (f SDFSphere) Distance(p glm.Vec3) float64 {
+  return p.Length() - f.Rad
+}
+
+// An SDFBox is centred on the origin with half extents Half.
+type SDFBox struct {
+  Half glm.Vec3
+}
+
+func (f SDFBox) Distance(p glm.Vec3) float64 {
+  q := glm.Vec3{}
+  for i := range q.Elem {
+    q.Elem[i] = math.Abs(p.Elem[i]) - f.Half.Elem[i]
+  }
+  outside := vecMax(q, glm.Vec3{})
+  return outside.Length() + math.Min(math.Max(q.Elem[0], math.Max(q.Elem[1], q.Elem[2])), 0)
+}
+
+// An SDFTorus lies in the xz plane.
+type SDFTorus struct {
+  Major, Minor float64
+}
+
+func (f SDFTorus) Distance(p glm.Vec3) float64 {
+  return math.Hypot(math.Hypot(p.Elem[0], p.Elem[2]) - f.Major, p.Elem[1]) - f.Minor
+}
+
+// An SDFCapsule is a line segment from A to B thickened by Rad.
+type SDFCapsule struct {
+  A, B glm.Vec3
+  Rad float64
+}
+
+func (f SDFCapsule) Distance(p glm.Vec3) float64 {
+  pa := p.Subtract(&f.A)
+  ba := f.B.Subtract(&f.A)
+  h := math.Max(0, math.Min(pa.Dot(ba) / ba.Dot(ba), 1))
+  return pa.Subtract(ba.Scale(h)).Length() - f.Rad
+}
+
+// A Mandelbulb is the power-N Mandelbulb fractal around the origin, about
+// 1.2 units in radius.
+type Mandelbulb struct {
+  Power float64
+  Iterations int
+}
+
+func (f Mandelbulb) Distance(p glm.Vec3) float64 {
+  z := p
+  dr, r := 1.0, 0.0
+  for i := 0; i < f.Iterations; i++ {
+    r = z.Length()
+    if r > 2 {
+      break
+    }
+    theta := math.Acos(math.Max(-1, math.Min(z.Elem[2]/r, 1)))
+    phi := math.Atan2(z.Elem[1], z.Elem[0])
+    dr = math.Pow(r, f.Power - 1)*f.Power*dr + 1
+    zr := math.Pow(r, f.Power)
+    theta *= f.Power
+    phi *= f.Power
+    z = *glm.NewVec3(math.Sin(theta)*math.Cos(phi), math.Sin(phi)*math.Sin(theta), math.Cos(theta))
+    z.Iscale(zr).Iadd(&p)
+  }
+  if r == 0 {
+    return 0
+  }
+  return 0.5 * math.Log(r) * r / dr
+}
+
+// SDF OPERATORS
+
+type Translate struct {
+  Field Field
+  Offset glm.Vec3
+}
+
+func (f Translate) Distance(p glm.Vec3) float64 {
+  return f.Field.Distance(*p.Subtract(&f.Offset))
+}
+
+// Scale grows a field uniformly by Factor.
+type Scale struct {
+  Field Field
+  Factor float64
+}
+
+func (f Scale) Distance(p glm.Vec3) float64 {
+  return f.Field.Distance(*p.Scale(1/f.Factor)) * f.Factor
+}
+
+// SmoothUnion blends two fields over a distance K (polynomial smooth min).
+type SmoothUnion struct {
+  A, B Field
+  K float64
+}
+
+func (f SmoothUnion) Distance(p glm.Vec3) float64 {
+  a, b := f.A.Distance(p), f.B.Distance(p)
+  if f.K <= 0 {
+    return math.Min(a, b)
+  }
+  h := math.Max(0, math.Min(0.5 + 0.5*(b - a)/f.K, 1))
+  return b*(1 - h) + a*h - f.K*h*(1 - h)
+}
+
+// Repeat tiles a field with the given Period along each axis; a zero period
+// leaves that axis alone. The field should fit inside one cell.
+type Repeat struct {
+  Field Field
+  Period glm.Vec3
+}
+
+func (f Repeat) Distance(p glm.Vec3) float64 {
+  for i, c := range f.Period.Elem {
+    if c > 0 {
+      p.Elem[i] -= c * math.Round(p.Elem[i]/c)
+    }
+  }
+  return f.Field.Distance(p)
+}
+
+// Twist rotates a field about the y axis by Rate radians per unit of height.
+// It stretches distances, so render it with an SDF Step below 1.
+type Twist struct {
+  Field Field
+  Rate float64
+}
+
+func (f Twist) Distance(p glm.Vec3) float64 {
+  s, c := math.Sincos(f.Rate * p.Elem[1])
+  q := *glm.NewVec3(c*p.Elem[0] - s*p.Elem[2], p.Elem[1], s*p.Elem[0] + c*p.Elem[2])
+  return f.Field.Distance(q)
+}
+
+// SCENE DESCRIPTION
+
+// CreateMandelbulbScene shows off the fractal and a few field operators.
+func CreateMandelbulbScene() (scene *Scene, err error) {
+  scene = &Scene{}
+  scene.Lights = []Light{
+    Light{*glm.NewVec3(-300.0, 400.0, 400.0), *glm.NewVec3(0.8, 0.8, 0.8), *glm.NewVec3(1.0, 0.0, 0.0), 0},
+    Light{*glm.NewVec3( 400.0, 100.0, 300.0), *glm.NewVec3(0.3, 0.3, 0.5), *glm.NewVec3(1.0, 0.0, 0.0), 0},
+  }
+  gold := Material{
+    *glm.NewVec3(0.9, 0.7, 0.3), *glm.NewVec3(0.9, 0.7, 0.3), *glm.NewVec3(0.6, 0.6, 0.5), 40.0, 0.0, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
+  blue := Material{
+    *glm.NewVec3(0.4, 0.5, 0.9), *glm.NewVec3(0.4, 0.5, 0.9), *glm.NewVec3(0.5, 0.5, 0.5), 25.0, 0.0, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
+  grey := Material{
+    *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.2, 0.2, 0.2), 10.0, 0.0, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
+
+  bulb := Scale{Mandelbulb{8, 12}, 150}
+  blobs := Translate{SmoothUnion{
+    Translate{SDFSphere{40}, *glm.NewVec3(-30, 0, 0)},
+    Translate{SDFSphere{40}, *glm.NewVec3(30, 0, 0)}, 25}, *glm.NewVec3(-280, -120, 0)}
+  twisted := Translate{Twist{SDFBox{*glm.NewVec3(30, 90, 30)}, 0.02}, *glm.NewVec3(280, -110, 0)}
+  posts := Translate{Repeat{SDFCapsule{*glm.NewVec3(0, 0, 0), *glm.NewVec3(0, 40, 0), 8}, *glm.NewVec3(80, 0, 80)}, *glm.NewVec3(0, -200, 0)}
+
+  scene.Primitives = []Primitive{
+    SDF{bulb, AABB{*glm.NewVec3(-190, -190, -190), *glm.NewVec3(190, 190, 190), gold}, 0.8, gold},
+    SDF{blobs, AABB{*glm.NewVec3(-360, -170, -50), *glm.NewVec3(-200, -70, 50), blue}, 1, blue},
+    SDF{twisted, AABB{*glm.NewVec3(230, -210, -50), *glm.NewVec3(330, -10, 50), blue}, 0.5, blue},
+    SDF{posts, AABB{*glm.NewVec3(-600, -210, -600), *glm.NewVec3(600, -150, 200), grey}, 1, grey},
+    Plane{*glm.NewVec3(0.0, -200.0, 0.0), *glm.NewVec3(0.0, 1.0, 0.0), grey},
+  }
+
+  scene.Eye = *glm.NewVec3(0.0, 0.0, 800.0)
+  scene.View = *glm.NewVec3(0.0, 0.0, -1.0)
+  scene.Up = *glm.NewVec3(0.0, 1.0, 0.0)
+  scene.Ambient = *glm.NewVec3(0.3, 0.3, 0.3)
+  scene.FOV = 50
+  scene.Width = 512
+  scene.Height = 512
+  return scene, nil
+}
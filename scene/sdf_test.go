@@ -0,0 +1,113 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+// SDF SHAPES
+
+func TestSDFDistances(t *testing.T) {
+  for _, c := range []struct {
+    name string
+    f Field
+    p glm.Vec3
+    want float64
+  }{
+    {"sphere outside", SDFSphere{2}, vec(0, 3, 4), 3},
+    {"sphere inside", SDFSphere{2}, vec(0, 0, 0.5), -1.5},
+    {"box face", SDFBox{vec(1, 2, 3)}, vec(0, 5, 0), 3},
+    {"box corner", SDFBox{vec(1, 2, 3)}, vec(4, 6, 3), 5},
+    {"box inside", SDFBox{vec(1, 2, 3)}, vec(0.5, 0, 0), -0.5},
+    {"torus tube", SDFTorus{3, 1}, vec(0, 0, 5), 1},
+    {"torus hole", SDFTorus{3, 1}, vec(0, 0, 0), 2},
+    {"torus above", SDFTorus{3, 1}, vec(3, 2, 0), 1},
+    {"capsule side", SDFCapsule{vec(0, 0, 0), vec(0, 4, 0), 1}, vec(3, 2, 0), 2},
+    {"capsule end", SDFCapsule{vec(0, 0, 0), vec(0, 4, 0), 1}, vec(0, 7, 0), 2},
+    {"func", DistanceFunc(func(p glm.Vec3) float64 { return p.Elem[1] }), vec(5, -2, 1), -2},
+
+    {"translate", Translate{SDFSphere{1}, vec(10, 0, 0)}, vec(13, 0, 0), 2},
+    {"scale", Scale{SDFSphere{1}, 3}, vec(0, 5, 0), 2},
+    {"union, far from the blend", SmoothUnion{SDFSphere{1}, Translate{SDFSphere{1}, vec(10, 0, 0)}, 0.5}, vec(-3, 0, 0), 2},
+    {"hard union", SmoothUnion{SDFSphere{1}, Translate{SDFSphere{1}, vec(4, 0, 0)}, 0}, vec(2, 0, 0), 1},
+    // halfway between, both are 1 away and the blend takes K/4 off.
+    {"smooth union", SmoothUnion{SDFSphere{1}, Translate{SDFSphere{1}, vec(4, 0, 0)}, 1}, vec(2, 0, 0), 0.75},
+    {"repeat", Repeat{SDFSphere{1}, vec(10, 0, 10)}, vec(31, 0, -19), 0.4142135623730951},
+    {"repeat, free axis", Repeat{SDFSphere{1}, vec(10, 0, 0)}, vec(20, 5, 0), 4},
+    {"twist on the axis", Twist{SDFBox{vec(1, 5, 1)}, 0.7}, vec(0, 3, 0), -1},
+    // a quarter turn up one unit swaps x and z.
+    {"twist", Twist{SDFBox{vec(1, 5, 3)}, math.Pi/2}, vec(2.5, 1, 0), -0.5},
+  } {
+    if got := c.f.Distance(c.p); math.Abs(got - c.want) > 1e-9 {
+      t.Errorf("%s: distance %g, want %g", c.name, got, c.want)
+    }
+  }
+}
+
+func TestMandelbulb(t *testing.T) {
+  f := Mandelbulb{8, 12}
+  // the distance estimate never overshoots the bulb, which reaches about
+  // 1.2 from the centre.
+  for _, p := range []glm.Vec3{vec(3, 0, 0), vec(0, -2, 2), vec(1.5, 1.5, 1.5)} {
+    if d := f.Distance(p); d <= 0 || d > p.Length() - 1 {
+      t.Errorf("%v: distance %g, want in (0, %g]", p, d, p.Length() - 1)
+    }
+  }
+  if d := f.Distance(vec(0.1, 0.1, 0.1)); d > 1e-3 {
+    t.Errorf("inside: distance %g", d)
+  }
+}
+
+// SPHERE TRACING
+
+func TestSDFIntersect(t *testing.T) {
+  centre := vec(1, 2, -3)
+  box := AABB{vec(-1, 0, -5), vec(3, 4, -1), Material{}}
+  p := SDF{Translate{SDFSphere{2}, centre}, box, 0, Material{}}
+  analytic := Sphere{centre, 2, Material{}}
+  for _, c := range []struct {
+    name string
+    ray, origin glm.Vec3
+  }{
+    {"head on", vec(0, 0, -1), vec(1, 2, 10)},
+    {"long ray", vec(0, 0, -4), vec(1, 2, 10)},
+    {"off centre", vec(0, 0, -1), vec(2.5, 2.5, 10)},
+    {"slanted", vec(-1, -0.5, -2), vec(6, 5, 6)},
+    {"from inside", vec(1, 1, 0), vec(1, 2, -3)},
+  } {
+    hit, raylen, normal := p.Intersect(c.ray, c.origin)
+    _, want, want_normal := analytic.Intersect(c.ray, c.origin)
+    tol := 2*MARCH_EPSILON*(1 + want*c.ray.Length()) / c.ray.Length()
+    if !hit || math.Abs(raylen - want) > tol {
+      t.Errorf("%s: hit %v at %g, want %g", c.name, hit, raylen, want)
+      continue
+    }
+    n, wn := unit(normal), unit(want_normal)
+    if math.Abs(math.Abs(n.Dot(&wn)) - 1) > 1e-3 {
+      t.Errorf("%s: normal %v, want %v", c.name, n, wn)
+    }
+  }
+  for _, c := range []struct {
+    name string
+    ray, origin glm.Vec3
+  }{
+    {"beside", vec(0, 0, -1), vec(3.5, 2, 10)},
+    {"away", vec(0, 0, 1), vec(1, 2, 10)},
+  } {
+    if hit, raylen, _ := p.Intersect(c.ray, c.origin); hit {
+      t.Errorf("%s: hit at %g", c.name, raylen)
+    }
+  }
+  // the sphere sticks out of its bound, and is cut off there.
+  if hit, raylen, _ := p.Intersect(vec(0, -1, 0), vec(1, 10, -3)); hit && raylen < 6 - 1e-3 {
+    t.Errorf("hit at %g, outside the bound", raylen)
+  }
+  // a twisted box stretches distances, but with a shorter step still meets
+  // the ray where the untwisted one does at zero height.
+  twisted := SDF{Twist{SDFBox{vec(1, 3, 1)}, 0.3}, AABB{vec(-2, -3, -2), vec(2, 3, 2), Material{}}, 0.5, Material{}}
+  if hit, raylen, _ := twisted.Intersect(vec(-1, 0, 0), vec(5, 0, 0)); !hit || math.Abs(raylen - 4) > 1e-3 {
+    t.Errorf("twisted box: hit %v at %g, want 4", hit, raylen)
+  }
+}
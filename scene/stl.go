@@ -0,0 +1,114 @@
+package scene
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// STL LOADER
+
+func ReadStl(file string) *Mesh {
+  m, err := parseStl(file)
+  if err != nil {
+    fmt.Println(err)
+    fmt.Println()
+    return &Mesh{}
+  }
+  return m
+}
+
+// parseStl reads an ASCII or binary STL file. STL stores each triangle's
+// corners separately, so identical corners are welded into shared vertices.
+// Facet normals are ignored in favour of the winding.
+func parseStl(file string) (*Mesh, error) {
+  data, err := os.ReadFile(file)
+  if err != nil {
+    return nil, err
+  }
+  var corners [][3]float64
+  // binary files may also start with "solid", so trust the size first.
+  if len(data) >= 84 && len(data) == 84 + 50*int(binary.LittleEndian.Uint32(data[80:84])) {
+    corners = stlBinary(data)
+  } else if bytes.HasPrefix(bytes.TrimSpace(data), []byte("solid")) {
+    if corners, err = stlASCII(file, data); err != nil {
+      return nil, err
+    }
+  } else {
+    return nil, fmt.Errorf("%s: not an STL file", file)
+  }
+  if len(corners) == 0 {
+    return nil, fmt.Errorf("%s: no triangles", file)
+  }
+
+  faces := make([][3]int, len(corners)/3)
+  for i := range faces {
+    faces[i] = [3]int{3*i, 3*i + 1, 3*i + 2}
+  }
+  r, err := repairLoaded(file, corners, faces, 0)
+  if err != nil {
+    return nil, err
+  }
+  return NewMesh(r.Verts, r.Faces, Material{}), nil
+}
+
+func stlBinary(data []byte) [][3]float64 {
+  n := int(binary.LittleEndian.Uint32(data[80:84]))
+  corners := make([][3]float64, 0, 3*n)
+  for i := 0; i < n; i++ {
+    // skip the normal; the attribute count follows the corners.
+    tri := data[84 + 50*i + 12:]
+    for k := 0; k < 3; k++ {
+      c := [3]float64{}
+      for j := range c {
+        c[j] = float64(math.Float32frombits(binary.LittleEndian.Uint32(tri[12*k + 4*j:])))
+      }
+      corners = append(corners, c)
+    }
+  }
+  return corners
+}
+
+func stlASCII(file string, data []byte) ([][3]float64, error) {
+  sc := bufio.NewScanner(bytes.NewReader(data))
+  corners := [][3]float64{}
+  facet := 0
+  for lineno := 1; sc.Scan(); lineno++ {
+    s := strings.Fields(sc.Text())
+    if len(s) == 0 {
+      continue
+    }
+    switch s[0] {
+      case "vertex":
+        if len(s) != 4 {
+          return nil, fmt.Errorf("%s:%d: vertex needs 3 coordinates", file, lineno)
+        }
+        c := [3]float64{}
+        for j := range c {
+          var err error
+          if c[j], err = strconv.ParseFloat(s[j+1], 64); err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", file, lineno, err)
+          }
+        }
+        corners = append(corners, c)
+        facet++
+      case "endfacet":
+        if facet != 3 {
+          return nil, fmt.Errorf("%s:%d: facet has %d vertices, want 3", file, lineno, facet)
+        }
+        facet = 0
+    }
+  }
+  if err := sc.Err(); err != nil {
+    return nil, err
+  }
+  if facet != 0 {
+    return nil, fmt.Errorf("%s: unterminated facet", file)
+  }
+  return corners, nil
+}
@@ -2,7 +2,8 @@
 package scene
 
 var (
-  steldodec = NewMesh(
+  // the source data repeats each vertex for every face using it.
+  steldodec, _ = NewMesh(
     [][3]float64{
       [3]float64{ -158.333350, 225.647350, -64.699450},
       [3]float64{ -207.117500, 241.498300, -64.699450},
@@ -245,5 +246,5 @@ var (
       [3]int{ 171, 172, 173},
       [3]int{ 174, 175, 176},
       [3]int{ 177, 178, 179} },
-    Material{})
+    Material{}).Repair(Epsilon)
 )
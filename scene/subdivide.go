@@ -0,0 +1,382 @@
+package scene
+
+import (
+	"fmt"
+
+	"gray/glm"
+)
+
+// SUBDIVISION SURFACES
+
+// A polyMesh is a polygon mesh mid-way through subdivision. Creases are
+// edges kept sharp; boundary and non-manifold edges are always sharp. Attrs,
+// if not nil, holds values per vertex, such as texture coordinates, that are
+// carried across linearly.
+type polyMesh struct {
+  verts []glm.Vec3
+  faces [][]int
+  creases map[[2]int]bool
+  attrs [][]float64
+}
+
+func edgeKey(a, b int) [2]int {
+  if a > b {
+    a, b = b, a
+  }
+  return [2]int{a, b}
+}
+
+func newPolyMesh(verts [][3]float64, polys [][]int, creases [][2]int) (*polyMesh, error) {
+  for i, f := range polys {
+    if len(f) < 3 {
+      return nil, fmt.Errorf("face %d has %d vertices", i, len(f))
+    }
+    for k, v := range f {
+      if v < 0 || v >= len(verts) {
+        return nil, MeshProblem{Kind: BadIndex, Face: i}
+      }
+      if v == f[(k+1)%len(f)] {
+        return nil, MeshProblem{Kind: DegenerateFace, Face: i}
+      }
+    }
+  }
+  m := &polyMesh{make([]glm.Vec3, len(verts)), polys, map[[2]int]bool{}, nil}
+  for i, v := range verts {
+    m.verts[i] = *glm.NewVec3(v[0], v[1], v[2])
+  }
+  for _, e := range creases {
+    m.creases[edgeKey(e[0], e[1])] = true
+  }
+  return m, nil
+}
+
+// Subdivide refines polygons levels times and returns the smooth shaded
+// result: Loop subdivision if every face is a triangle, Catmull-Clark
+// otherwise. Creases are pairs of vertex indices joined by a sharp edge.
+// Polygons with missing or repeated vertices are an error.
+func Subdivide(verts [][3]float64, polys [][]int, creases [][2]int, levels int, mat Material) (*Mesh, error) {
+  m, err := newPolyMesh(verts, polys, creases)
+  if err != nil {
+    return nil, err
+  }
+  mesh, _ := m.subdivide(levels, mat)
+  return mesh, nil
+}
+
+// subdivide refines m levels times, returning the mesh and the attributes
+// of its vertices.
+func (m *polyMesh) subdivide(levels int, mat Material) (*Mesh, [][]float64) {
+  loop := true
+  for _, f := range m.faces {
+    loop = loop && len(f) == 3
+  }
+  for i := 0; i < levels; i++ {
+    if loop {
+      m = m.loop()
+    } else {
+      m = m.catmullClark()
+    }
+  }
+  out := make([][3]float64, len(m.verts))
+  for i, v := range m.verts {
+    out[i] = v.Elem
+  }
+  mesh := NewMesh(out, Triangulate(m.faces), mat)
+  mesh.smooth(m.creases)
+  return mesh, m.attrs
+}
+
+// Subdivide refines a triangle mesh with Loop subdivision. Texture
+// coordinates and vertex colours are interpolated linearly, so they stay
+// where they were on the original faces rather than being smoothed.
+func (p Mesh) Subdivide(levels int, creases [][2]int) (*Mesh, error) {
+  verts := make([][3]float64, len(p.Verts))
+  for i, v := range p.Verts {
+    verts[i] = v.Elem
+  }
+  polys := make([][]int, len(p.Faces))
+  for i, f := range p.Faces {
+    polys[i] = []int{f[0], f[1], f[2]}
+  }
+  m, err := newPolyMesh(verts, polys, creases)
+  if err != nil {
+    return nil, err
+  }
+  uvs := p.UVs != nil && len(p.UVs) == len(p.Verts)
+  colours := p.Colours != nil && len(p.Colours) == len(p.Verts)
+  if uvs || colours {
+    m.attrs = make([][]float64, len(p.Verts))
+    for i := range m.attrs {
+      a := make([]float64, 0, 5)
+      if uvs {
+        a = append(a, p.UVs[i][0], p.UVs[i][1])
+      }
+      if colours {
+        a = append(a, p.Colours[i].Elem[:]...)
+      }
+      m.attrs[i] = a
+    }
+  }
+  mesh, attrs := m.subdivide(levels, p.Mat)
+  if uvs {
+    mesh.UVs = make([][2]float64, len(attrs))
+    for i, a := range attrs {
+      mesh.UVs[i] = [2]float64{a[0], a[1]}
+      attrs[i] = a[2:]
+    }
+  }
+  if colours {
+    mesh.Colours = make([]glm.Vec3, len(attrs))
+    for i, a := range attrs {
+      mesh.Colours[i] = *glm.NewVec3(a[0], a[1], a[2])
+    }
+  }
+  return mesh, nil
+}
+
+// mean averages the attributes of the given vertices, or is nil if there
+// are none.
+func (m *polyMesh) mean(vs ...int) []float64 {
+  if m.attrs == nil {
+    return nil
+  }
+  a := make([]float64, len(m.attrs[vs[0]]))
+  for _, v := range vs {
+    for i, x := range m.attrs[v] {
+      a[i] += x / float64(len(vs))
+    }
+  }
+  return a
+}
+
+// edges lists each edge once, in the order faces first use it, along with
+// the faces around it.
+func (m *polyMesh) edges() ([][2]int, map[[2]int][]int) {
+  keys := [][2]int{}
+  faces := map[[2]int][]int{}
+  for i, f := range m.faces {
+    for k := range f {
+      e := edgeKey(f[k], f[(k+1)%len(f)])
+      if _, ok := faces[e]; !ok {
+        keys = append(keys, e)
+      }
+      faces[e] = append(faces[e], i)
+    }
+  }
+  return keys, faces
+}
+
+func (m *polyMesh) sharp(e [2]int, faces map[[2]int][]int) bool {
+  return m.creases[e] || len(faces[e]) != 2
+}
+
+// neighbours returns the vertices joined to each vertex, and those joined to
+// it by sharp edges.
+func (m *polyMesh) neighbours(keys [][2]int, faces map[[2]int][]int) (adj, sharp [][]int) {
+  adj = make([][]int, len(m.verts))
+  sharp = make([][]int, len(m.verts))
+  for _, e := range keys {
+    adj[e[0]] = append(adj[e[0]], e[1])
+    adj[e[1]] = append(adj[e[1]], e[0])
+    if m.sharp(e, faces) {
+      sharp[e[0]] = append(sharp[e[0]], e[1])
+      sharp[e[1]] = append(sharp[e[1]], e[0])
+    }
+  }
+  return
+}
+
+// creaseVertex places vertices on sharp edges: corners, where three or more
+// meet, stay put and vertices along a crease follow the crease curve. The
+// second result is false for smooth vertices.
+func (m *polyMesh) creaseVertex(v int, sharp []int) (glm.Vec3, bool) {
+  switch {
+    case len(sharp) == 2:
+      p := m.verts[v].Scale(0.75)
+      p.Iadd(m.verts[sharp[0]].Scale(0.125)).Iadd(m.verts[sharp[1]].Scale(0.125))
+      return *p, true
+    case len(sharp) > 2:
+      return m.verts[v], true
+  }
+  return glm.Vec3{}, false
+}
+
+// splitCreases carries each crease onto the two halves it is split into.
+func (m *polyMesh) splitCreases(edgePoint map[[2]int]int) map[[2]int]bool {
+  creases := map[[2]int]bool{}
+  for e := range m.creases {
+    if mid, ok := edgePoint[e]; ok {
+      creases[edgeKey(e[0], mid)] = true
+      creases[edgeKey(mid, e[1])] = true
+    }
+  }
+  return creases
+}
+
+// loop splits each triangle into four.
+func (m *polyMesh) loop() *polyMesh {
+  keys, faces := m.edges()
+  adj, sharp := m.neighbours(keys, faces)
+  verts := make([]glm.Vec3, len(m.verts), len(m.verts) + len(keys))
+  attrs := m.attrs
+
+  for v := range m.verts {
+    p, ok := m.creaseVertex(v, sharp[v])
+    if !ok && len(adj[v]) > 0 {
+      n := float64(len(adj[v]))
+      beta := 3.0/16
+      if len(adj[v]) > 3 {
+        beta = 3/(8*n)
+      }
+      p = *m.verts[v].Scale(1 - n*beta)
+      for _, u := range adj[v] {
+        p.Iadd(m.verts[u].Scale(beta))
+      }
+    } else if !ok {
+      p = m.verts[v]
+    }
+    verts[v] = p
+  }
+
+  edgePoint := map[[2]int]int{}
+  for _, e := range keys {
+    p := m.verts[e[0]].Add(&m.verts[e[1]])
+    if m.sharp(e, faces) {
+      p.Iscale(0.5)
+    } else {
+      p.Iscale(0.375)
+      for _, f := range faces[e] {
+        for _, v := range m.faces[f] {
+          if v != e[0] && v != e[1] {
+            p.Iadd(m.verts[v].Scale(0.125))
+          }
+        }
+      }
+    }
+    edgePoint[e] = len(verts)
+    verts = append(verts, *p)
+    if attrs != nil {
+      attrs = append(attrs, m.mean(e[0], e[1]))
+    }
+  }
+
+  out := make([][]int, 0, 4*len(m.faces))
+  for _, f := range m.faces {
+    a, b, c := f[0], f[1], f[2]
+    ab, bc, ca := edgePoint[edgeKey(a, b)], edgePoint[edgeKey(b, c)], edgePoint[edgeKey(c, a)]
+    out = append(out, []int{a, ab, ca}, []int{ab, b, bc}, []int{ca, bc, c}, []int{ab, bc, ca})
+  }
+  return &polyMesh{verts, out, m.splitCreases(edgePoint), attrs}
+}
+
+// catmullClark splits each n-sided face into n quads.
+func (m *polyMesh) catmullClark() *polyMesh {
+  keys, faces := m.edges()
+  adj, sharp := m.neighbours(keys, faces)
+  nv := len(m.verts)
+  verts := make([]glm.Vec3, nv + len(m.faces), nv + len(m.faces) + len(keys))
+
+  attrs := m.attrs
+  vertFaces := make([][]int, nv)
+  for i, f := range m.faces {
+    p := glm.Vec3{}
+    for _, v := range f {
+      p.Iadd(&m.verts[v])
+      vertFaces[v] = append(vertFaces[v], i)
+    }
+    verts[nv + i] = *p.Scale(1/float64(len(f)))
+    if attrs != nil {
+      attrs = append(attrs, m.mean(f...))
+    }
+  }
+
+  edgePoint := map[[2]int]int{}
+  for _, e := range keys {
+    p := m.verts[e[0]].Add(&m.verts[e[1]])
+    if m.sharp(e, faces) {
+      p.Iscale(0.5)
+    } else {
+      p.Iadd(&verts[nv + faces[e][0]]).Iadd(&verts[nv + faces[e][1]]).Iscale(0.25)
+    }
+    edgePoint[e] = len(verts)
+    verts = append(verts, *p)
+    if attrs != nil {
+      attrs = append(attrs, m.mean(e[0], e[1]))
+    }
+  }
+
+  for v := range m.verts {
+    p, ok := m.creaseVertex(v, sharp[v])
+    if !ok && len(adj[v]) > 0 && len(vertFaces[v]) > 0 {
+      // (F + 2R + (n-3)P) / n, from the average face point F and edge
+      // midpoint R around the vertex.
+      n := float64(len(adj[v]))
+      face, mid := glm.Vec3{}, glm.Vec3{}
+      for _, f := range vertFaces[v] {
+        face.Iadd(&verts[nv + f])
+      }
+      for _, u := range adj[v] {
+        mid.Iadd(m.verts[u].Add(&m.verts[v]).Scale(0.5))
+      }
+      p = *face.Scale(1/float64(len(vertFaces[v])))
+      p.Iadd(mid.Scale(2/n)).Iadd(m.verts[v].Scale(n - 3)).Iscale(1/n)
+    } else if !ok {
+      p = m.verts[v]
+    }
+    verts[v] = p
+  }
+
+  out := [][]int{}
+  for i, f := range m.faces {
+    for k, v := range f {
+      next := f[(k+1)%len(f)]
+      prev := f[(k+len(f)-1)%len(f)]
+      out = append(out, []int{v, edgePoint[edgeKey(v, next)], nv + i, edgePoint[edgeKey(prev, v)]})
+    }
+  }
+  return &polyMesh{verts, out, m.splitCreases(edgePoint), attrs}
+}
+
+// smooth averages the normals of faces meeting at each vertex, except across
+// creases, boundaries and non-manifold edges, and stores them per corner.
+func (p *Mesh) smooth(creases map[[2]int]bool) {
+  // union corners (3*face + k) that share a smooth edge.
+  parent := make([]int, 3*len(p.Faces))
+  for i := range parent {
+    parent[i] = i
+  }
+  var find func(i int) int
+  find = func(i int) int {
+    if parent[i] != i {
+      parent[i] = find(parent[i])
+    }
+    return parent[i]
+  }
+  corners := map[[2]int][][2]int{}
+  for i, f := range p.Faces {
+    for k := 0; k < 3; k++ {
+      e := edgeKey(f[k], f[(k+1)%3])
+      corners[e] = append(corners[e], [2]int{3*i + k, 3*i + (k+1)%3})
+    }
+  }
+  for e, c := range corners {
+    if len(c) != 2 || creases[e] {
+      continue
+    }
+    for _, a := range c[0] {
+      for _, b := range c[1] {
+        if p.Faces[a/3][a%3] == p.Faces[b/3][b%3] {
+          parent[find(a)] = find(b)
+        }
+      }
+    }
+  }
+  sums := make([]glm.Vec3, len(parent))
+  for i := range parent {
+    sums[find(i)].Iadd(&p.Normals[i/3])
+  }
+  p.VertNormals = make([][3]glm.Vec3, len(p.Faces))
+  for i := range parent {
+    p.VertNormals[i/3][i%3] = unit(sums[find(i)])
+  }
+}
@@ -0,0 +1,180 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+// the cube from -1 to 1, with bit i of a corner's index choosing +1 on axis
+// i, as quads wound outwards.
+var (
+  cubeVerts = [][3]float64{{-1, -1, -1}, {1, -1, -1}, {-1, 1, -1}, {1, 1, -1}, {-1, -1, 1}, {1, -1, 1}, {-1, 1, 1}, {1, 1, 1}}
+  cubeQuads = [][]int{{0, 4, 6, 2}, {1, 3, 7, 5}, {0, 1, 5, 4}, {2, 6, 7, 3}, {0, 2, 3, 1}, {4, 5, 7, 6}}
+  cubeEdges = [][2]int{{0, 1}, {2, 3}, {4, 5}, {6, 7}, {0, 2}, {1, 3}, {4, 6}, {5, 7}, {0, 4}, {1, 5}, {2, 6}, {3, 7}}
+  tetVerts = [][3]float64{{1, 1, 1}, {1, -1, -1}, {-1, 1, -1}, {-1, -1, 1}}
+  tetFaces = [][]int{{0, 1, 2}, {0, 3, 1}, {0, 2, 3}, {1, 3, 2}}
+)
+
+func hasVert(m *Mesh, p glm.Vec3) bool {
+  for _, v := range m.Verts {
+    if nearVec(v, p) {
+      return true
+    }
+  }
+  return false
+}
+
+func subdivide(t *testing.T, verts [][3]float64, polys [][]int, creases [][2]int, levels int) *Mesh {
+  m, err := Subdivide(verts, polys, creases, levels, Material{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  return m
+}
+
+func TestCatmullClark(t *testing.T) {
+  m := subdivide(t, cubeVerts, cubeQuads, nil, 1)
+  if len(m.Verts) != 26 || len(m.Faces) != 48 {
+    t.Errorf("%d vertices and %d faces, want 26 and 48", len(m.Verts), len(m.Faces))
+  }
+  // (F + 2R + (n-3)P)/n with n = 3: F = 1/3 and R = 2/3 along each axis.
+  if !nearVec(m.Verts[7], vec(5.0/9, 5.0/9, 5.0/9)) {
+    t.Errorf("corner moved to %v, want 5/9 along each axis", m.Verts[7])
+  }
+  // the +x face point, and the edge point between -y and -z.
+  for _, p := range []glm.Vec3{vec(1, 0, 0), vec(0, -0.75, -0.75)} {
+    if !hasVert(m, p) {
+      t.Errorf("no vertex at %v", p)
+    }
+  }
+  if len(m.VertNormals) != len(m.Faces) {
+    t.Errorf("%d corner normals for %d faces", len(m.VertNormals), len(m.Faces))
+  }
+}
+
+func TestCatmullClarkCreases(t *testing.T) {
+  // creased all round, the cube stays a cube.
+  m := subdivide(t, cubeVerts, cubeQuads, cubeEdges, 2)
+  for _, v := range m.Verts {
+    if d := math.Max(math.Abs(v.Elem[0]), math.Max(math.Abs(v.Elem[1]), math.Abs(v.Elem[2]))); !near(d, 1) {
+      t.Fatalf("vertex %v is off the cube", v)
+    }
+  }
+  if !nearVec(m.Verts[7], vec(1, 1, 1)) {
+    t.Errorf("corner moved to %v", m.Verts[7])
+  }
+}
+
+func TestCatmullClarkBoundary(t *testing.T) {
+  // without its +y face, the rim is a boundary, which is always sharp.
+  m := subdivide(t, cubeVerts, append(append([][]int{}, cubeQuads[:3]...), cubeQuads[4:]...), nil, 1)
+  if !nearVec(m.Verts[7], vec(0.75, 1, 0.75)) {
+    t.Errorf("rim corner moved to %v, want 0.75,1,0.75", m.Verts[7])
+  }
+  rim := 0
+  for _, v := range m.Verts {
+    if v.Elem[1] > 1 + 1e-9 {
+      t.Errorf("vertex %v is above the rim", v)
+    }
+    if near(v.Elem[1], 1) {
+      rim++
+    }
+  }
+  // the four corners and four edge points of the rim.
+  if rim != 8 {
+    t.Errorf("%d vertices on the rim, want 8", rim)
+  }
+}
+
+func TestLoop(t *testing.T) {
+  m := subdivide(t, tetVerts, tetFaces, nil, 1)
+  if len(m.Verts) != 10 || len(m.Faces) != 16 {
+    t.Errorf("%d vertices and %d faces, want 10 and 16", len(m.Verts), len(m.Faces))
+  }
+  // about the centre, each vertex's neighbours sum to -P and the far
+  // vertices of an edge's faces to -(a+b).
+  if !nearVec(m.Verts[0], vec(0.25, 0.25, 0.25)) {
+    t.Errorf("vertex moved to %v, want a quarter of the way out", m.Verts[0])
+  }
+  if !hasVert(m, vec(0.5, 0, 0)) {
+    t.Error("no edge point for 0-1 at (a+b)/4")
+  }
+
+  creased := subdivide(t, tetVerts, tetFaces, [][2]int{{1, 0}}, 1)
+  if !hasVert(creased, vec(1, 0, 0)) {
+    t.Error("creased edge not split at its midpoint")
+  }
+
+  open := subdivide(t, tetVerts, tetFaces[:3], nil, 1)
+  if !nearVec(open.Verts[1], vec(0.5, -0.75, -0.75)) {
+    t.Errorf("boundary vertex moved to %v, want 0.5,-0.75,-0.75", open.Verts[1])
+  }
+  if !hasVert(open, vec(0, 0, -1)) {
+    t.Error("boundary edge not split at its midpoint")
+  }
+}
+
+func TestSubdivideBadFaces(t *testing.T) {
+  for _, c := range []struct {
+    name string
+    polys [][]int
+  }{
+    {"out of range", [][]int{{0, 1, 4}}},
+    {"negative", [][]int{{0, -1, 2}}},
+    {"too few vertices", [][]int{{0, 1}}},
+    {"repeated vertex", [][]int{{0, 1, 1, 2}}},
+  } {
+    if _, err := Subdivide(tetVerts, c.polys, nil, 1, Material{}); err == nil {
+      t.Errorf("%s: subdivided without error", c.name)
+    }
+  }
+  m := NewMesh(tetVerts, [][3]int{{0, 1, 2}}, Material{})
+  m.Faces = append(m.Faces, [3]int{0, 1, 9})
+  if _, err := m.Subdivide(1, nil); err == nil {
+    t.Error("subdivided a mesh with a bad face")
+  }
+}
+
+func TestSubdivideAttributes(t *testing.T) {
+  m := NewMesh(tetVerts, Triangulate(tetFaces), Material{})
+  m.UVs = [][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}}
+  m.Colours = []glm.Vec3{vec(1, 0, 0), vec(0, 1, 0), vec(0, 0, 1), vec(1, 1, 1)}
+  s, err := m.Subdivide(1, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(s.UVs) != len(s.Verts) || len(s.Colours) != len(s.Verts) {
+    t.Fatalf("%d uvs and %d colours for %d vertices", len(s.UVs), len(s.Colours), len(s.Verts))
+  }
+  if s.UVs[1] != m.UVs[1] || s.Colours[1] != m.Colours[1] {
+    t.Errorf("vertex 1 has uv %v and colour %v, want them kept", s.UVs[1], s.Colours[1])
+  }
+  // the edge point of 0-1, which lands halfway out along x.
+  for i, v := range s.Verts {
+    if nearVec(v, vec(0.5, 0, 0)) {
+      if s.UVs[i] != [2]float64{0.5, 0} || !nearVec(s.Colours[i], vec(0.5, 0.5, 0)) {
+        t.Errorf("edge point has uv %v and colour %v, want the mean of its ends", s.UVs[i], s.Colours[i])
+      }
+    }
+  }
+}
+
+func TestReadSubdividedObj(t *testing.T) {
+  // the cube, its faces indexed back from the last vertex.
+  obj := "v -1 -1 -1\nv 1 -1 -1\nv -1 1 -1\nv 1 1 -1\nv -1 -1 1\nv 1 -1 1\nv -1 1 1\nv 1 1 1\n" +
+    "f -8 -4 -2 -6\nf -7 -5 -1 -3\nf -8 -7 -3 -4\nf -6 -2 -1 -5\nf -8 -6 -5 -7\nf -4 -3 -1 -2\n"
+  m, err := ReadSubdividedMesh(writeTemp(t, "cube.obj", obj), 1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(m.Verts) != 26 || !nearVec(m.Verts[7], vec(5.0/9, 5.0/9, 5.0/9)) {
+    t.Errorf("%d vertices with the corner at %v, want 26 and 5/9", len(m.Verts), m.Verts[7])
+  }
+  for _, bad := range []string{"f 1 2 9\n", "f 1 2 -9\n", "f 0 1 2\n"} {
+    if _, err := ReadSubdividedMesh(writeTemp(t, "bad.obj", obj + bad), 1); err == nil {
+      t.Errorf("%q: read without error", bad)
+    }
+  }
+}
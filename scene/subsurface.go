@@ -0,0 +1,82 @@
+package scene
+
+import (
+	"math"
+
+	"gray/glm"
+)
+
+// SUBSURFACE SCATTERING
+
+// Subsurface makes a material translucent: light enters the surface, wanders
+// about inside and leaves elsewhere, as in skin, wax and marble. It only
+// makes sense on closed primitives. Colour is the colour the surface takes
+// on overall, and MeanFreePath the mean distance light travels between
+// scatterings, both per channel.
+type Subsurface struct {
+  Colour, MeanFreePath glm.Vec3
+}
+
+// Albedo is the share of light each scattering inside keeps that gives the
+// surface its Colour overall, after the fit of Chiang, Kutz and Burley,
+// "Practical and Controllable Subsurface Scattering for Production Path
+// Tracing" (2016).
+func (s Subsurface) Albedo() glm.Vec3 {
+  a := glm.Vec3{}
+  for i, c := range s.Colour.Elem {
+    c = math.Max(0, math.Min(1, c))
+    x := 4.09712 + 4.20863*c - math.Sqrt(9.59217 + 41.6808*c + 17.7126*c*c)
+    a.Elem[i] = math.Max(0, math.Min(1, 1 - x*x))
+  }
+  return a
+}
+
+// Extinction is the fraction of light scattered or absorbed per unit length
+// inside.
+func (s Subsurface) Extinction() glm.Vec3 {
+  e := glm.Vec3{}
+  for i, d := range s.MeanFreePath.Elem {
+    e.Elem[i] = 1 / math.Max(d, 1e-9)
+  }
+  return e
+}
+
+// CreateSubsurfaceScene shows off marble, skin and wax, lit from behind as
+// well as in front so that light shows through their edges.
+func CreateSubsurfaceScene() (scene *Scene, err error) {
+  scene = &Scene{}
+  scene.Lights = []Light{
+    Light{*glm.NewVec3(-300.0, 300.0, 400.0), *glm.NewVec3(0.6, 0.6, 0.6), *glm.NewVec3(1.0, 0.0, 0.0), 0},
+    Light{*glm.NewVec3( 100.0, 250.0, -700.0), *glm.NewVec3(0.9, 0.8, 0.6), *glm.NewVec3(1.0, 0.0, 0.0), 0},
+  }
+  subsurface := func(colour, mfp glm.Vec3) Material {
+    linear := SRGBToLinearVec(colour)
+    return Material{
+      linear, linear, *glm.NewVec3(0.3, 0.3, 0.3), 40.0, 0.0, 0, glm.Vec3{}, nil, &Subsurface{linear, mfp},
+    }
+  }
+  marble := subsurface(*glm.NewVec3(0.92, 0.9, 0.86), *glm.NewVec3(40, 35, 30))
+  skin := subsurface(*glm.NewVec3(0.9, 0.65, 0.55), *glm.NewVec3(30, 12, 6))
+  wax := subsurface(*glm.NewVec3(1.0, 0.75, 0.3), *glm.NewVec3(20, 15, 8))
+  grey := Material{
+    *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.5, 0.5, 0.5), *glm.NewVec3(0.2, 0.2, 0.2), 10.0, 0.0, 0, glm.Vec3{}, nil, nil,
+  }.Linear()
+
+  star := *steldodec
+  star.Mat = wax
+  scene.Primitives = []Primitive{
+    Sphere{*glm.NewVec3(150.0, -50.0, -200.0), 150.0, marble},
+    Sphere{*glm.NewVec3(-150.0, -110.0, -50.0), 90.0, skin},
+    star,
+    Plane{*glm.NewVec3(0.0, -200.0, 0.0), *glm.NewVec3(0.0, 1.0, 0.0), grey},
+  }
+
+  scene.Eye = *glm.NewVec3(0.0, 0.0, 800.0)
+  scene.View = *glm.NewVec3(0.0, 0.0, -1.0)
+  scene.Up = *glm.NewVec3(0.0, 1.0, 0.0)
+  scene.Ambient = *glm.NewVec3(0.1, 0.1, 0.1)
+  scene.FOV = 50
+  scene.Width = 512
+  scene.Height = 512
+  return scene, nil
+}
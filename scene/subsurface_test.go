@@ -0,0 +1,44 @@
+package scene
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSubsurfaceAlbedo(t *testing.T) {
+  for _, c := range []struct {
+    colour, want float64
+  }{
+    // the fit runs from black to white, clamping colours out of range.
+    {-0.5, 0},
+    {0, 0},
+    {0.1, 0.3842405},
+    {0.5, 0.9117089},
+    {0.8, 0.9905888},
+    {1, 1},
+    {2, 1},
+  } {
+    s := Subsurface{vec(c.colour, c.colour, c.colour), vec(1, 1, 1)}
+    if got := s.Albedo(); math.Abs(got.Elem[0] - c.want) > 1e-5 || got.Elem[1] != got.Elem[0] || got.Elem[2] != got.Elem[0] {
+      t.Errorf("colour %g: albedo %v, want %g", c.colour, got, c.want)
+    }
+  }
+  // each channel on its own, and darker colours need less of the light kept.
+  a := Subsurface{vec(0.9, 0.5, 0.1), vec(1, 1, 1)}.Albedo()
+  if !(a.Elem[0] > a.Elem[1] && a.Elem[1] > a.Elem[2] && a.Elem[2] > 0) {
+    t.Errorf("albedo %v is not in the order of its colour", a)
+  }
+}
+
+func TestSubsurfaceExtinction(t *testing.T) {
+  s := Subsurface{vec(1, 1, 1), vec(0.5, 4, 0)}
+  e := s.Extinction()
+  if e.Elem[0] != 2 || e.Elem[1] != 0.25 {
+    t.Errorf("extinction %v, want 2 and 0.25 for paths of 0.5 and 4", e)
+  }
+  // light under a surface with no path at all goes nowhere, but not
+  // infinitely so.
+  if math.IsInf(e.Elem[2], 0) || e.Elem[2] < 1e8 {
+    t.Errorf("extinction %g for no path", e.Elem[2])
+  }
+}
@@ -0,0 +1,75 @@
+package scene
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+
+	"gray/glm"
+)
+
+// A Texture is an image decoded to linear RGB, looked up with bilinear
+// filtering and wrapping at the edges.
+type Texture struct {
+  Width, Height int
+  Texels []glm.Vec3
+}
+
+// LoadTexture reads a PNG or JPEG image. Colour images are sRGB encoded and
+// are converted to linear; pass srgb=false for data such as height or
+// displacement maps.
+func LoadTexture(file string, srgb bool) (*Texture, error) {
+  infile, err := os.Open(file)
+  if err != nil {
+    return nil, err
+  }
+  defer infile.Close()
+  img, _, err := image.Decode(infile)
+  if err != nil {
+    return nil, fmt.Errorf("%s: %v", file, err)
+  }
+  return NewTexture(img, srgb), nil
+}
+
+func NewTexture(img image.Image, srgb bool) *Texture {
+  b := img.Bounds()
+  t := &Texture{b.Dx(), b.Dy(), make([]glm.Vec3, b.Dx()*b.Dy())}
+  for y := 0; y < t.Height; y++ {
+    for x := 0; x < t.Width; x++ {
+      r, g, bl, _ := img.At(b.Min.X + x, b.Min.Y + y).RGBA()
+      c := *glm.NewVec3(float64(r)/0xffff, float64(g)/0xffff, float64(bl)/0xffff)
+      if srgb {
+        c = SRGBToLinearVec(c)
+      }
+      t.Texels[y*t.Width + x] = c
+    }
+  }
+  return t
+}
+
+func (t *Texture) texel(x, y int) *glm.Vec3 {
+  x %= t.Width
+  if x < 0 {
+    x += t.Width
+  }
+  y %= t.Height
+  if y < 0 {
+    y += t.Height
+  }
+  return &t.Texels[y*t.Width + x]
+}
+
+// Lookup samples the texture at u,v with v=0 at the bottom of the image.
+func (t *Texture) Lookup(u, v float64) glm.Vec3 {
+  x := u*float64(t.Width) - 0.5
+  y := (1-v)*float64(t.Height) - 0.5
+  x0, y0 := math.Floor(x), math.Floor(y)
+  fx, fy := x - x0, y - y0
+  ix, iy := int(x0), int(y0)
+  top := t.texel(ix, iy).Scale(1 - fx).Add(t.texel(ix+1, iy).Scale(fx))
+  bottom := t.texel(ix, iy+1).Scale(1 - fx).Add(t.texel(ix+1, iy+1).Scale(fx))
+  return *top.Iscale(1 - fy).Add(bottom.Scale(fy))
+}
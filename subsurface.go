@@ -0,0 +1,89 @@
+package main
+
+import (
+  "math"
+
+  "gray/glm"
+  "gray/scene"
+)
+
+// SUBSURFACE SCATTERING
+//
+// Light under a translucent surface is followed by random walks inside the
+// primitive hit: each enters diffusely, travels exponentially distributed
+// distances between scatterings in even directions, and is lit where it
+// leaves as though by a white diffuse surface. Distances are drawn for one
+// channel at a time, and weighted against all three.
+
+// MAX_WALK_STEPS bounds the scatterings of each walk.
+const MAX_WALK_STEPS = 256
+
+// subsurface is the light leaving surface along the unit ray, the hit on
+// root[node], from beneath it.
+func (tr *tracer) subsurface(surface scene.Hit, node int, ray glm.Vec3, ss *scene.Subsurface, depth int) glm.Vec3 {
+  prim := tr.sc.Primitives[node]
+  albedo, ext := ss.Albedo(), ss.Extinction()
+  white := &scene.Material{Diffuse: *glm.NewVec3(1, 1, 1)}
+  // enter on the far side of the surface from the viewer.
+  inward := surface.Normal
+  if inward.Dot(&ray) < 0 {
+    inward.Iscale(-1)
+  }
+  inward.Normalize()
+  walks := 1
+  if depth == 0 {
+    walks = max(1, tr.opts.SubsurfaceWalks)
+  }
+  sum := glm.Vec3{}
+  for w := 0; w < walks; w++ {
+    dir := scene.SampleCosine(inward, tr.s.Float64(), tr.s.Float64())
+    origin := surface.Offset(dir)
+    weight := *glm.NewVec3(1, 1, 1)
+    for step := 0; step < MAX_WALK_STEPS; step++ {
+      c := min(int(tr.s.Float64()*3), 2)
+      dist := -math.Log(1 - tr.s.Float64()) / ext.Elem[c]
+      hit, raylen, normal := prim.Intersect(dir, origin)
+      if hit && raylen <= dist {
+        // out: the chance of getting this far on each channel, over the
+        // chance averaged over the channel choice.
+        pdf := 0.0
+        for i := range weight.Elem {
+          pdf += math.Exp(-ext.Elem[i]*raylen) / 3
+        }
+        for i := range weight.Elem {
+          weight.Elem[i] *= math.Exp(-ext.Elem[i]*raylen) / pdf
+        }
+        exit := scene.HitAt(prim, dir, origin, raylen, normal)
+        if exit.Normal.Dot(&dir) < 0 {
+          exit.Normal.Iscale(-1)
+        }
+        if normal.Dot(&dir) < 0 {
+          normal.Iscale(-1)
+        }
+        normal.Normalize()
+        light, _ := tr.direct(exit, node, white, normal, dir)
+        light = mul(weight, light)
+        sum.Iadd(&light)
+        break
+      }
+      // scatter, keeping the albedo's share.
+      pdf := 0.0
+      for i := range weight.Elem {
+        pdf += ext.Elem[i] * math.Exp(-ext.Elem[i]*dist) / 3
+      }
+      for i := range weight.Elem {
+        weight.Elem[i] *= albedo.Elem[i] * ext.Elem[i] * math.Exp(-ext.Elem[i]*dist) / pdf
+      }
+      origin = *origin.Add(dir.Scale(dist))
+      dir = scene.SampleSphere(tr.s.Float64(), tr.s.Float64())
+      // end dim walks at random, making up for it in those that go on.
+      if q := math.Max(weight.Elem[0], math.Max(weight.Elem[1], weight.Elem[2])); q < 1 {
+        if tr.s.Float64() >= q {
+          break
+        }
+        weight.Iscale(1 / q)
+      }
+    }
+  }
+  return *sum.Scale(1 / float64(walks))
+}
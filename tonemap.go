@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gray/glm"
+	"gray/scene"
+)
+
+// ToneMapping turns linear scene radiance into display sRGB. Exposure is in
+// stops and White is the linear colour that should come out neutral.
+type ToneMapping struct {
+  Exposure float64
+  White glm.Vec3
+  Operator string
+}
+
+var toneOperators = map[string]func(float64) float64{
+  "clamp": func(x float64) float64 {
+    return x
+  },
+  "reinhard": func(x float64) float64 {
+    return x / (1 + x)
+  },
+  // Narkowicz's fit of the ACES filmic curve.
+  "aces": func(x float64) float64 {
+    return (x*(2.51*x + 0.03)) / (x*(2.43*x + 0.59) + 0.14)
+  },
+  "hable": func(x float64) float64 {
+    const W = 11.2
+    return hable(2*x) / hable(W)
+  },
+}
+
+// hable is John Hable's filmic curve from Uncharted 2.
+func hable(x float64) float64 {
+  const A, B, C, D, E, F = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+  return (x*(A*x + C*B) + D*E)/(x*(A*x + B) + D*F) - E/F
+}
+
+func (tm *ToneMapping) Validate() error {
+  if _, ok := toneOperators[tm.Operator]; !ok {
+    return fmt.Errorf("unknown tone mapping operator %q", tm.Operator)
+  }
+  for _, w := range tm.White.Elem {
+    if w <= 0 {
+      return fmt.Errorf("white point %v must be positive", tm.White.Elem)
+    }
+  }
+  return nil
+}
+
+// Map returns the display colour, in [0,1] sRGB, for linear colour c.
+func (tm *ToneMapping) Map(c glm.Vec3) glm.Vec3 {
+  op := toneOperators[tm.Operator]
+  scale := math.Pow(2, tm.Exposure)
+  for i := range c.Elem {
+    x := math.Max(0, c.Elem[i] * scale / tm.White.Elem[i])
+    c.Elem[i] = scene.LinearToSRGB(math.Min(op(x), 1.0))
+  }
+  return c
+}
+
+// parseColour reads an "r,g,b" triple.
+func parseColour(s string) (glm.Vec3, error) {
+  parts := strings.Split(s, ",")
+  if len(parts) != 3 {
+    return glm.Vec3{}, fmt.Errorf("colour %q is not r,g,b", s)
+  }
+  c := glm.Vec3{}
+  for i, p := range parts {
+    f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+    if err != nil {
+      return glm.Vec3{}, err
+    }
+    c.Elem[i] = f
+  }
+  return c, nil
+}
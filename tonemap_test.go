@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"gray/glm"
+)
+
+func TestToneOperators(t *testing.T) {
+  for name, op := range toneOperators {
+    if y := op(0); math.Abs(y) > 1e-9 {
+      t.Errorf("%s: 0 maps to %g", name, y)
+    }
+    // every curve rises.
+    last := op(0)
+    for x := 0.01; x < 20; x += 0.01 {
+      if y := op(x); y <= last {
+        t.Errorf("%s: falls to %g at %g", name, y, x)
+        break
+      } else {
+        last = y
+      }
+    }
+  }
+  for _, c := range []struct {
+    name string
+    x, want float64
+  }{
+    {"clamp", 0.3, 0.3},
+    {"reinhard", 1, 0.5},
+    {"reinhard", 3, 0.75},
+    {"aces", 1, 2.54/3.16},
+    // hable is scaled for its white point, 11.2 before the doubling.
+    {"hable", 5.6, 1},
+  } {
+    if y := toneOperators[c.name](c.x); math.Abs(y - c.want) > 1e-9 {
+      t.Errorf("%s(%g) = %g, want %g", c.name, c.x, y, c.want)
+    }
+  }
+}
+
+func TestToneMap(t *testing.T) {
+  grey := *glm.NewVec3(0.2, 0.2, 0.2)
+  plain := ToneMapping{0, *glm.NewVec3(1, 1, 1), "clamp"}
+  // 0.2 linear is 0.4845 in sRGB.
+  if c := plain.Map(grey); math.Abs(c.Elem[0] - 0.4845292) > 1e-6 {
+    t.Errorf("0.2 maps to %g, want 0.4845", c.Elem[0])
+  }
+  // a stop up doubles the light.
+  brighter := ToneMapping{1, *glm.NewVec3(1, 1, 1), "clamp"}
+  if a, b := brighter.Map(grey), plain.Map(*grey.Scale(2)); a != b {
+    t.Errorf("a stop up gives %v, want %v", a, b)
+  }
+  // the white point comes out neutral, whatever its colour.
+  warm := ToneMapping{0, *glm.NewVec3(0.9, 0.6, 0.3), "reinhard"}
+  if c := warm.Map(*glm.NewVec3(0.9, 0.6, 0.3)); c.Elem[0] != c.Elem[1] || c.Elem[1] != c.Elem[2] {
+    t.Errorf("white point maps to %v", c)
+  }
+  for name := range toneOperators {
+    tm := ToneMapping{0, *glm.NewVec3(1, 1, 1), name}
+    c := tm.Map(*glm.NewVec3(-1, 0, 1e6))
+    if c.Elem[0] != 0 || c.Elem[1] != 0 || c.Elem[2] < 0.99 || c.Elem[2] > 1 {
+      t.Errorf("%s: -1, 0 and 1e6 map to %v", name, c)
+    }
+  }
+}
+
+func TestToneMappingValidate(t *testing.T) {
+  for _, c := range []struct {
+    tm ToneMapping
+    ok bool
+  }{
+    {ToneMapping{0, *glm.NewVec3(1, 1, 1), "aces"}, true},
+    {ToneMapping{-3, *glm.NewVec3(0.5, 1, 2), "hable"}, true},
+    {ToneMapping{0, *glm.NewVec3(1, 1, 1), "gamma"}, false},
+    {ToneMapping{0, *glm.NewVec3(1, 0, 1), "clamp"}, false},
+    {ToneMapping{0, *glm.NewVec3(1, 1, -1), "clamp"}, false},
+  } {
+    if err := c.tm.Validate(); (err == nil) != c.ok {
+      t.Errorf("%+v: error %v", c.tm, err)
+    }
+  }
+}
+
+func TestParseColour(t *testing.T) {
+  if c, err := parseColour("1, 0.5,2e-1"); err != nil || c != *glm.NewVec3(1, 0.5, 0.2) {
+    t.Errorf("parsed %v, %v", c, err)
+  }
+  for _, s := range []string{"", "1,2", "1,2,3,4", "1,x,3"} {
+    if _, err := parseColour(s); err == nil {
+      t.Errorf("%q parsed", s)
+    }
+  }
+}